@@ -2,15 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/enercity/billing-data-aggregator/internal/config"
 	"github.com/enercity/billing-data-aggregator/internal/database"
+	"github.com/enercity/billing-data-aggregator/internal/diff"
 	"github.com/enercity/billing-data-aggregator/internal/export"
 	"github.com/enercity/billing-data-aggregator/internal/processors"
+	"github.com/enercity/billing-data-aggregator/internal/publish"
+	"github.com/enercity/billing-data-aggregator/internal/quality"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -33,7 +44,16 @@ func main() {
 		cancel()
 	}()
 
-	cfg, err := config.Load()
+	opts, err := config.ParseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid arguments: %v\n", err)
+		os.Exit(2)
+	}
+	if opts.Command == config.CommandEffectiveConfig {
+		os.Exit(printEffectiveConfig(opts))
+	}
+
+	cfg, err := config.LoadOptions(opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
 		os.Exit(1)
@@ -41,15 +61,21 @@ func main() {
 
 	setupLogging(cfg)
 
+	runID := newRunID()
+	log.Logger = log.With().Str("run_id", runID).Logger()
+
 	log.Info().
 		Str("version", version).
 		Str("commit", commit).
 		Str("date", date).
-		Str("client_id", cfg.ClientID).
+		Strs("clients", cfg.Clients()).
 		Str("environment", cfg.Environment).
 		Msg("Starting billing-data-aggregator")
+	for _, key := range cfg.Deprecated() {
+		log.Warn().Str("setting", key).Msg("Setting is deprecated and ignored")
+	}
 
-	if err := run(ctx, cfg); err != nil {
+	if err := runClients(ctx, cfg, runID); err != nil {
 		log.Error().Err(err).Msg("Application failed")
 		os.Exit(1)
 	}
@@ -77,21 +103,148 @@ func setupLogging(cfg *config.Config) {
 
 	log.Logger = log.With().
 		Str("service", "billing-data-aggregator").
-		Str("client_id", cfg.ClientID).
 		Str("environment", cfg.Environment).
 		Logger()
 
 	if jobID := os.Getenv("AWS_BATCH_JOB_ID"); jobID != "" {
 		log.Logger = log.With().Str("batch_job_id", jobID).Logger()
 	}
+
+	// Packages log through the logger of their context, which carries the
+	// client_id of a client run, and otherwise through the global logger.
+	zerolog.DefaultContextLogger = &log.Logger
+}
+
+// printEffectiveConfig prints every setting with its source, secrets
+// redacted, and reports whether the configuration is valid.
+func printEffectiveConfig(opts config.Options) int {
+	cfg, err := config.Resolve(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	if err := cfg.WriteEffective(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newRunID returns a sortable, unique identifier for this run.
+func newRunID() string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix) // crypto/rand.Read never fails
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
+// runClients runs the pipeline once per client, at most ClientConcurrency
+// clients at a time, each with its own client_id logger. Clients sharing a
+// database run one after another, see databaseLocks. It returns the errors
+// of all failed clients.
+func runClients(ctx context.Context, cfg *config.Config, runID string) error {
+	clients := cfg.Clients()
+	errs := make([]error, len(clients))
+	slots := make(chan struct{}, max(cfg.ClientConcurrency, 1))
+	locks := databaseLocks(cfg, clients)
+
+	var wg sync.WaitGroup
+	for i, id := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clientCfg := cfg.ForClient(id)
+			mu := locks[databaseKey(clientCfg)]
+			mu.Lock()
+			defer mu.Unlock()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			logger := log.With().Str("client_id", id).Logger()
+			started := time.Now()
+			if err := run(logger.WithContext(ctx), clientCfg, runID); err != nil {
+				logRunFailure(logger, cfg, err, time.Since(started))
+				errs[i] = fmt.Errorf("client %s: %w", id, err)
+				return
+			}
+			logger.Info().Dur("duration", time.Since(started)).Msg("Client run succeeded")
+		}()
+	}
+	wg.Wait()
+
+	if len(clients) > 1 {
+		failed := 0
+		for _, err := range errs {
+			if err != nil {
+				failed++
+			}
+		}
+		log.Info().Int("clients", len(clients)).Int("failed", failed).Msg("Client runs finished")
+	}
+	return errors.Join(errs...)
+}
+
+// databaseLocks returns a mutex per database of the clients. Clients of
+// one database share its staging and report_oibl schemas: run in parallel
+// they would stage into and publish each other's tables.
+func databaseLocks(cfg *config.Config, clients []string) map[string]*sync.Mutex {
+	locks := make(map[string]*sync.Mutex)
+	for _, id := range clients {
+		key := databaseKey(cfg.ForClient(id))
+		if _, ok := locks[key]; ok {
+			if cfg.ClientConcurrency > 1 {
+				log.Info().Str("client_id", id).Str("database", key).Msg("Client shares its database, running it after the others")
+			}
+			continue
+		}
+		locks[key] = &sync.Mutex{}
+	}
+	return locks
 }
-func run(ctx context.Context, cfg *config.Config) error {
+
+// databaseKey identifies the database of a client run.
+func databaseKey(cfg *config.Config) string {
+	return fmt.Sprintf("%s:%d/%s", cfg.Database.Host, cfg.Database.Port, cfg.Database.Database)
+}
+
+// logRunFailure logs why a client run failed. A failed script statement
+// adds its position, SQLSTATE and excerpt as fields; local runs also print
+// it in human form.
+func logRunFailure(logger zerolog.Logger, cfg *config.Config, err error, duration time.Duration) {
+	event := logger.Error().Err(err).Dur("duration", duration)
+	var scriptErr *database.ScriptError
+	if errors.As(err, &scriptErr) {
+		event.EmbedObject(scriptErr)
+	}
+	event.Msg("Client run failed")
+
+	if scriptErr != nil && cfg.Environment == "local" {
+		fmt.Fprintln(os.Stderr, scriptErr.Report())
+	}
+}
+
+func run(ctx context.Context, cfg *config.Config, runID string) (err error) {
+	if cfg.JobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.JobTimeout,
+			fmt.Errorf("job timeout of %s exceeded", cfg.JobTimeout))
+		defer cancel()
+	}
+
 	// Initialize database connection
-	log.Info().Msg("Initializing database connection")
+	log.Ctx(ctx).Info().Msg("Initializing database connection")
+	tokens, err := newTokenProvider(ctx, cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
 	db, err := database.NewConnection(
+		ctx,
 		cfg.ConnectionString(),
+		tokens,
 		cfg.DBMaxConnections,
-		cfg.DBMaxIdleConns,
 		cfg.DBConnMaxIdleTime,
 	)
 	if err != nil {
@@ -99,73 +252,521 @@ func run(ctx context.Context, cfg *config.Config) error {
 	}
 	defer func() {
 		if err := db.Close(); err != nil {
-			log.Error().Err(err).Msg("Failed to close database connection")
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to close database connection")
 		}
 	}()
 
+	lock, err := acquireRunLock(ctx, cfg, db, runID)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	// The ledger is bookkeeping only, a run does not fail without it.
+	ledger := database.NewRunLedger(db.DB())
+	entry := &database.LedgerEntry{RunID: runID, ClientID: cfg.ClientID, Environment: cfg.Environment, Version: version}
+	if err := ledger.Start(ctx, entry); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to record run in the run ledger")
+	} else {
+		defer func() {
+			// Record the outcome even if the job timed out or was cancelled
+			finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+			defer cancel()
+			if err := ledger.Finish(finishCtx, entry, err); err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msg("Failed to record run outcome in the run ledger")
+			}
+		}()
+	}
+
+	publisher, err := publish.NewPublisher(db.DB(), publish.Options{Keep: cfg.PublishKeep})
+	if err != nil {
+		return fmt.Errorf("failed to create publisher: %w", err)
+	}
+	if cfg.PublishRollback != "" {
+		log.Ctx(ctx).Info().Str("version", cfg.PublishRollback).Msg("Rolling back report tables")
+		return publisher.Rollback(ctx, cfg.PublishRollback, publish.Version(runID))
+	}
+
 	// Create script executor
-	executor := database.NewScriptExecutor(db, cfg.IgnoreSystems)
+	retryPolicy := database.DefaultStatementRetry
+	retryPolicy.MaxAttempts = cfg.StatementRetries + 1
+	executor := database.NewScriptExecutor(db, cfg.IgnoreSystems).WithClient(cfg.ClientID).WithEnvironment(cfg.Environment).WithRetry(retryPolicy).WithTransaction(cfg.ScriptTransaction).WithTimeouts(database.Timeouts{
+		Script:    cfg.ScriptTimeout,
+		Statement: cfg.StatementTimeout,
+	})
 	// Execute initialization scripts
-	log.Info().Msg("Executing initialization scripts")
-	if err := executor.ExecuteScriptsInDir(ctx, "scripts/init"); err != nil {
+	log.Ctx(ctx).Info().Msg("Executing initialization scripts")
+	if err := executor.ExecuteScriptsInDir(ctx, cfg.InitScriptsDir); err != nil {
 		return fmt.Errorf("failed to execute init scripts: %w", err)
 	}
 
 	// Run processors based on configured systems
-	log.Info().Strs("systems", cfg.Systems).Msg("Running processors")
+	log.Ctx(ctx).Info().Strs("systems", cfg.Systems).Msg("Running processors")
 	for _, system := range cfg.Systems {
 		var processor processors.Processor
 		
 		switch system {
 		case "tripica":
-			processor = processors.NewTripicaProcessor(db, executor, "scripts")
+			processor = processors.NewTripicaProcessor(db, executor, cfg.ScriptsDir)
 		case "bookkeeper":
-			processor = processors.NewBookkeeperProcessor(db, executor, "scripts")
+			processor = processors.NewBookkeeperProcessor(db, executor, cfg.ScriptsDir)
 		default:
-			log.Warn().Str("system", system).Msg("Unknown system, skipping")
+			log.Ctx(ctx).Warn().Str("system", system).Msg("Unknown system, skipping")
 			continue
 		}
 		
-		log.Info().Str("system", processor.Name()).Msg("Processing system")
+		log.Ctx(ctx).Info().Str("system", processor.Name()).Msg("Processing system")
 		if err := processor.Process(ctx); err != nil {
 			return fmt.Errorf("processor %s failed: %w", processor.Name(), err)
 		}
 	}
 
-	// Export results to CSV
-	log.Info().Msg("Exporting results to CSV")
-	exporter := export.NewCSVExporter(db.DB(), "/tmp/exports", cfg.MaxRowSizeFile)
-	
-	var allFiles []string
-	for _, system := range cfg.Systems {
-		tableName := fmt.Sprintf("%s_results", system)
-		files, err := exporter.ExportTable(ctx, tableName, system)
-		if err != nil {
-			log.Warn().Err(err).Str("table", tableName).Msg("Failed to export table, continuing")
-		} else {
+	// Assert data quality on the staged tables, error severity failures
+	// block publishing and export
+	report, err := runQualityChecks(ctx, cfg, db)
+	if err != nil {
+		return err
+	}
+
+	// Swap the staged report tables in before exporting them
+	log.Ctx(ctx).Info().Msg("Publishing report tables")
+	if _, err := publisher.Publish(ctx, publish.Version(runID)); err != nil {
+		if !errors.Is(err, publish.ErrNothingStaged) {
+			return fmt.Errorf("failed to publish report tables: %w", err)
+		}
+		log.Ctx(ctx).Warn().Err(err).Msg("No report tables published")
+	}
+
+	location, err := time.LoadLocation(cfg.ExportTimezone)
+	if err != nil {
+		return fmt.Errorf("invalid export timezone %q: %w", cfg.ExportTimezone, err)
+	}
+	runInfo := export.RunInfo{
+		ID:          runID,
+		ClientID:    cfg.ClientID,
+		Environment: cfg.Environment,
+		Version:     version,
+		Commit:      commit,
+		Started:     time.Now().In(location),
+	}
+
+	sinks, uploader, err := newSinks(ctx, cfg, runInfo)
+	defer closeSinks(ctx, sinks)
+	if err != nil {
+		return err
+	}
+	sink := export.NewMultiSink(sinks...)
+
+	// Export results
+	log.Ctx(ctx).Info().
+		Str("format", cfg.ExportFormat).
+		Bool("streaming", cfg.ExportStreaming).
+		Strs("sinks", cfg.ExportSinks()).
+		Msg("Exporting results")
+	exporter, err := newExporter(cfg, db, location)
+	if err != nil {
+		return fmt.Errorf("failed to create exporter: %w", err)
+	}
+
+	var allFiles []export.File
+	var mismatches []error
+	failed := 0
+	switch {
+	case len(sinks) == 0:
+		// Only the postgres sink is configured
+	case cfg.ExportStreaming:
+		streamer, ok := exporter.(export.StreamWriter)
+		if !ok {
+			return fmt.Errorf("export format %s does not support streaming", cfg.ExportFormat)
+		}
+
+		for _, system := range cfg.Systems {
+			tableName := fmt.Sprintf("%s_results", system)
+			files, err := streamer.StreamTable(ctx, tableName, system, sink)
+			if err != nil {
+				log.Ctx(ctx).Warn().Err(err).Str("table", tableName).Msg("Failed to stream table, continuing")
+				failed++
+				mismatches = appendMismatch(mismatches, err)
+			} else {
+				allFiles = append(allFiles, files...)
+			}
+		}
+	default:
+		for _, system := range cfg.Systems {
+			tableName := fmt.Sprintf("%s_results", system)
+			files, err := exporter.ExportTable(ctx, tableName, system)
+			if err != nil {
+				log.Ctx(ctx).Warn().Err(err).Str("table", tableName).Msg("Failed to export table, continuing")
+				failed++
+				mismatches = appendMismatch(mismatches, err)
+			} else {
+				allFiles = append(allFiles, files...)
+			}
+		}
+
+		// Deliver to the sinks
+		log.Ctx(ctx).Info().Int("files", len(allFiles)).Msg("Delivering files")
+		if len(allFiles) > 0 {
+			if err := export.PutFiles(ctx, sink, allFiles); err != nil {
+				return fmt.Errorf("failed to upload files: %w", err)
+			}
+		}
+	}
+
+	// Report the open items that changed since the previous run
+	var changes *diff.Report
+	if cfg.Diff && len(sinks) > 0 && slices.Contains(cfg.Systems, "tripica") && !slices.Contains(cfg.IgnoreSystems, "tripica") {
+		files, report, err := exportChanges(ctx, db, publisher, runID, outputDir(cfg))
+		switch {
+		case errors.Is(err, diff.ErrNoPrevious):
+			log.Ctx(ctx).Info().Err(err).Msg("Skipping open items diff")
+		case err != nil:
+			log.Ctx(ctx).Warn().Err(err).Msg("Failed to compare open items with the previous run, continuing")
+		default:
+			if err := export.PutFiles(ctx, sink, files); err != nil {
+				return fmt.Errorf("failed to upload open items diff: %w", err)
+			}
 			allFiles = append(allFiles, files...)
+			changes = report
 		}
 	}
 
-	// Upload to S3
-	log.Info().Int("files", len(allFiles)).Msg("Uploading files to S3")
-	uploader, err := export.NewS3Uploader(ctx, cfg.S3.Region, cfg.S3.Bucket, fmt.Sprintf("%s/%s", cfg.ClientID, cfg.Environment))
+	// Drop old archives only now, the diff compares with the newest one
+	if err := publisher.Prune(ctx); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to prune archived versions")
+	}
+
+	// Compare the uploaded objects with the written chunks
+	if cfg.Reconcile && uploader != nil && len(allFiles) > 0 {
+		if err := uploader.Verify(ctx, allFiles); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Uploaded objects do not match the export")
+			failed++
+			mismatches = appendMismatch(mismatches, err)
+		}
+	}
+
+	pgSink, target, err := newPostgresSink(ctx, cfg, db)
 	if err != nil {
-		return fmt.Errorf("failed to create S3 uploader: %w", err)
+		return err
 	}
-	
-	if len(allFiles) > 0 {
-		if err := uploader.UploadFiles(ctx, allFiles); err != nil {
-			return fmt.Errorf("failed to upload files: %w", err)
+	if target != nil {
+		defer func() {
+			if err := target.Close(); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("Failed to close target database connection")
+			}
+		}()
+
+		for _, system := range cfg.Systems {
+			tableName := fmt.Sprintf("%s_results", system)
+			if _, err := pgSink.ExportTable(ctx, tableName, system); err != nil {
+				log.Ctx(ctx).Warn().Err(err).Str("table", tableName).Msg("Failed to copy table to Postgres, continuing")
+				failed++
+			}
 		}
 	}
 
+	if uploader != nil {
+		if err := publishS3(ctx, cfg, uploader, allFiles, failed, report, changes); err != nil {
+			return err
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("export does not match the database: %w", errors.Join(mismatches...))
+	}
+
 	// Execute archive scripts
-	log.Info().Msg("Executing archive scripts")
-	if err := executor.ExecuteScriptsInDir(ctx, "scripts/archive"); err != nil {
+	log.Ctx(ctx).Info().Msg("Executing archive scripts")
+	if err := executor.ExecuteScriptsInDir(ctx, cfg.ArchiveScriptsDir); err != nil {
 		return fmt.Errorf("failed to execute archive scripts: %w", err)
 	}
 
-	log.Info().Msg("Job completed successfully")
+	log.Ctx(ctx).Info().Msg("Job completed successfully")
 	return nil
 }
+
+// runQualityChecks runs the data-quality assertions of every active system
+// in <scripts dir>/quality/<system>.
+func runQualityChecks(ctx context.Context, cfg *config.Config, db *database.Connection) (*quality.Report, error) {
+	var assertions []quality.Assertion
+	for _, system := range cfg.Systems {
+		if slices.Contains(cfg.IgnoreSystems, system) {
+			continue
+		}
+		loaded, err := quality.LoadDir(filepath.Join(cfg.ScriptsDir, "quality", system))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load data-quality assertions: %w", err)
+		}
+		assertions = append(assertions, loaded...)
+	}
+
+	log.Ctx(ctx).Info().Int("assertions", len(assertions)).Msg("Running data-quality assertions")
+	report := quality.NewRunner(db.DB()).Run(ctx, assertions)
+	if err := report.Err(); err != nil {
+		return nil, fmt.Errorf("data quality check failed, not exporting: %w", err)
+	}
+	if warned := report.Failed(quality.SeverityWarn); len(warned) > 0 {
+		log.Ctx(ctx).Warn().Int("assertions", len(warned)).Msg("Data-quality warnings, continuing")
+	}
+	return report, nil
+}
+
+// acquireRunLock makes sure only one run per client and environment drops
+// and recreates the report tables at a time.
+func acquireRunLock(ctx context.Context, cfg *config.Config, db *database.Connection, runID string) (*database.RunLock, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	var wait time.Duration
+	if cfg.RunLockPolicy == "wait" {
+		wait = cfg.RunLockWait
+	}
+
+	name := cfg.ClientID + "/" + cfg.Environment
+	lock, err := db.AcquireRunLock(ctx, name, database.LockOwner{RunID: runID, Host: host}, wait)
+	if err != nil {
+		var held *database.LockHeldError
+		if errors.As(err, &held) {
+			log.Ctx(ctx).Error().
+				Str("holder_run_id", held.Holder.RunID).
+				Str("holder_host", held.Holder.Host).
+				Int("holder_pid", held.PID).
+				Msg("Another run is in progress")
+		}
+		return nil, fmt.Errorf("failed to acquire run lock: %w", err)
+	}
+	return lock, nil
+}
+
+// newSinks creates the configured sinks. The S3 uploader is returned
+// separately, as the manifest and latest pointer are only written to S3.
+func newSinks(ctx context.Context, cfg *config.Config, runInfo export.RunInfo) ([]export.Sink, *export.S3Uploader, error) {
+	var sinks []export.Sink
+	var uploader *export.S3Uploader
+
+	for _, name := range cfg.ExportSinks() {
+		switch name {
+		case export.SinkS3:
+			var err error
+			uploader, err = export.NewS3Uploader(ctx, cfg.S3, fmt.Sprintf("%s/%s", cfg.ClientID, cfg.Environment))
+			if err != nil {
+				return sinks, nil, fmt.Errorf("failed to create S3 uploader: %w", err)
+			}
+			sinks = append(sinks, uploader.WithRun(runInfo))
+		case export.SinkLocal:
+			sinks = append(sinks, export.NewLocalSink(cfg.LocalSinkDir))
+		case export.SinkSFTP:
+			sftpSink, err := export.NewSFTPSink(ctx, cfg.SFTP)
+			if err != nil {
+				return sinks, nil, fmt.Errorf("failed to create SFTP sink: %w", err)
+			}
+			sinks = append(sinks, sftpSink)
+		case export.SinkPostgres:
+			// Loaded table by table, see newPostgresSink
+		default:
+			return sinks, nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+
+	return sinks, uploader, nil
+}
+
+func closeSinks(ctx context.Context, sinks []export.Sink) {
+	for _, sink := range sinks {
+		if c, ok := sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("Failed to close sink")
+			}
+		}
+	}
+}
+
+// newTokenProvider returns the RDS IAM token provider of a database with
+// IAM authentication, or nil to use its password.
+func newTokenProvider(ctx context.Context, db config.DBConfig) (database.TokenProvider, error) {
+	if !db.IAMAuth {
+		return nil, nil
+	}
+	return database.NewRDSTokenProvider(ctx, db.Host, db.Port, db.Region, db.User)
+}
+
+// newPostgresSink connects to the target database if the postgres sink is
+// configured. The returned connection must be closed by the caller.
+func newPostgresSink(ctx context.Context, cfg *config.Config, db *database.Connection) (*export.PostgresSink, *database.Connection, error) {
+	if !slices.Contains(cfg.ExportSinks(), export.SinkPostgres) {
+		return nil, nil, nil
+	}
+
+	log.Ctx(ctx).Info().Str("host", cfg.TargetDatabase.Host).Msg("Initializing target database connection")
+	tokens, err := newTokenProvider(ctx, cfg.TargetDatabase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize target database: %w", err)
+	}
+	target, err := database.NewConnection(
+		ctx,
+		cfg.TargetDatabase.ConnectionString(),
+		tokens,
+		cfg.TargetDatabase.MaxConns,
+		cfg.TargetDatabase.MinutesIdle,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize target database: %w", err)
+	}
+
+	sink, err := export.NewPostgresSink(db.DB(), db, target.DB(), export.PostgresOptions{
+		Schema:   cfg.TargetSchema,
+		Strategy: cfg.TargetLoadStrategy,
+		Keys:     cfg.TargetUpsertKeys,
+	})
+	if err != nil {
+		_ = target.Close() // Ignore close error during error handling
+		return nil, nil, err
+	}
+	return sink, target, nil
+}
+
+// exportChanges compares the published oibl_customer with the version the
+// publish of this run archived, and writes the changed items as CSV and
+// their summary as JSON into dir.
+func exportChanges(ctx context.Context, db *database.Connection, publisher *publish.Publisher, runID, dir string) ([]export.File, *diff.Report, error) {
+	previous, err := publisher.Archive(publish.Version(runID))
+	if err != nil {
+		return nil, nil, err
+	}
+	differ, err := diff.NewDiffer(db.DB(), diff.Options{
+		Current:  publish.DefaultTargetSchema + "." + diff.DefaultTable,
+		Previous: previous + "." + diff.DefaultTable,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	table := diff.DefaultTable + "_diff"
+	csvPath := filepath.Join(dir, fmt.Sprintf("tripica_%s.csv", table))
+	jsonPath := filepath.Join(dir, fmt.Sprintf("tripica_%s.json", table))
+
+	var report *diff.Report
+	err = writeFile(csvPath, func(w io.Writer) error {
+		report, err = differ.Run(ctx, w)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeFile(jsonPath, report.WriteJSON); err != nil {
+		return nil, nil, err
+	}
+
+	return []export.File{
+		{Path: csvPath, System: "tripica", Table: table, Rows: int64(report.Added + report.Removed + report.Changed)},
+		{Path: jsonPath, System: "tripica", Table: table},
+	}, report, nil
+}
+
+// writeFile creates path and writes it with write.
+func writeFile(path string, write func(io.Writer) error) error {
+	// #nosec G304 -- path is built from the fixed output directory
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := write(f); err != nil {
+		_ = f.Close() // Ignore close error during error handling
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// appendMismatch collects reconciliation errors, which fail the job once
+// the remaining tables are exported.
+func appendMismatch(mismatches []error, err error) []error {
+	var reconcileErr *export.ReconcileError
+	if errors.As(err, &reconcileErr) {
+		return append(mismatches, err)
+	}
+	return mismatches
+}
+
+// publishS3 writes the run manifest and, for complete runs only, moves the
+// latest pointer.
+func publishS3(ctx context.Context, cfg *config.Config, uploader *export.S3Uploader, files []export.File, failed int, report *quality.Report, changes *diff.Report) error {
+	manifest := uploader.Manifest(files)
+	if changes != nil {
+		manifest.Changes = &export.ChangeSummary{
+			Previous: changes.Previous,
+			Added:    changes.Added,
+			Removed:  changes.Removed,
+			Changed:  changes.Changed,
+			Delta:    changes.Delta,
+		}
+	}
+	for _, res := range report.Results {
+		check := export.QualityCheck{
+			Name:       res.Assertion.Name,
+			Severity:   string(res.Assertion.Severity),
+			Status:     res.Status,
+			Violations: res.Violations,
+		}
+		if res.Err != nil {
+			check.Error = res.Err.Error()
+		}
+		manifest.Quality = append(manifest.Quality, check)
+	}
+	if err := uploader.UploadManifest(ctx, manifest); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		log.Ctx(ctx).Warn().Int("failed_tables", failed).Msg("Export incomplete, not publishing run")
+		return nil
+	}
+
+	if err := uploader.Publish(ctx); err != nil {
+		return fmt.Errorf("failed to publish run: %w", err)
+	}
+	if cfg.S3.CleanupStale {
+		if _, err := uploader.CleanupStale(ctx); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("Failed to clean up stale objects")
+		}
+	}
+	return nil
+}
+
+// outputDir is where the exports of a client are written before delivery.
+func outputDir(cfg *config.Config) string {
+	return filepath.Join("/tmp/exports", cfg.ClientID)
+}
+
+func newExporter(cfg *config.Config, db *database.Connection, location *time.Location) (export.Writer, error) {
+	switch cfg.ExportFormat {
+	case export.FormatParquet:
+		exporter, err := export.NewParquetExporter(db.DB(), outputDir(cfg), cfg.MaxRowSizeFile, export.ParquetOptions{
+			Compression:  cfg.ParquetCompression,
+			RowGroupSize: cfg.ParquetRowGroupSize,
+			Location:     location,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Reconcile {
+			exporter.WithReconcile(cfg.ReconcileSumColumns)
+		}
+		return exporter, nil
+	default:
+		exporter := export.NewCSVExporter(db.DB(), outputDir(cfg), cfg.MaxRowSizeFile)
+		if cfg.ExportCopy {
+			exporter.WithCopy(db)
+		}
+		if cfg.Reconcile {
+			exporter.WithReconcile(cfg.ReconcileSumColumns)
+		}
+		return exporter.WithCompression(cfg.ExportCompression)
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/enercity/billing-data-aggregator/internal/secrets"
+)
+
+// Commands of the aggregator.
+const (
+	CommandRun             = "run"
+	CommandEffectiveConfig = "effective-config"
+)
+
+// Options are the command line arguments. Every setting can be passed as a
+// flag named after its environment variable, e.g. --db-host for
+// BDA_DB_HOST; flags take precedence over the environment, which takes
+// precedence over the config file.
+type Options struct {
+	Command string
+	// File is the YAML or TOML config file, from --config or BDA_CONFIG.
+	File  string
+	Flags map[string]string
+	// Resolver resolves secret references such as ssm:///path/to/param.
+	// Defaults to files, SSM Parameter Store and Secrets Manager.
+	Resolver secrets.Resolver
+}
+
+// ParseArgs parses the command line arguments without the program name:
+//
+//	billing-data-aggregator [run|effective-config] [--config file] [--setting[=value] ...]
+//
+// A flag without value, e.g. --export-copy, is set to true.
+func ParseArgs(args []string) (Options, error) {
+	opts := Options{Command: CommandRun, File: os.Getenv(EnvPrefix + "CONFIG"), Flags: make(map[string]string)}
+
+	commandSet := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			if commandSet {
+				return Options{}, fmt.Errorf("unexpected argument %q", arg)
+			}
+			switch arg {
+			case CommandRun, CommandEffectiveConfig:
+				opts.Command = arg
+				commandSet = true
+			default:
+				return Options{}, fmt.Errorf("unknown command %q, want %s or %s", arg, CommandRun, CommandEffectiveConfig)
+			}
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name == "" {
+			return Options{}, fmt.Errorf("invalid flag %q", arg)
+		}
+		if !hasValue {
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") && !isCommand(args[i+1]) {
+				value = args[i+1]
+				i++
+			} else {
+				value = "true"
+			}
+		}
+
+		if name == "config" {
+			opts.File = value
+			continue
+		}
+		key := settingKey(name)
+		if _, exists := opts.Flags[key]; exists {
+			return Options{}, fmt.Errorf("flag --%s is set twice", name)
+		}
+		opts.Flags[key] = value
+	}
+	return opts, nil
+}
+
+func isCommand(arg string) bool {
+	return arg == CommandRun || arg == CommandEffectiveConfig
+}
@@ -2,43 +2,128 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/enercity/billing-data-aggregator/internal/secrets"
 )
 
 // EnvPrefix is the prefix for all environment variables used by this application.
 const EnvPrefix = "BDA_"
 
+// resolveTimeout bounds resolving the secret references of a configuration.
+const resolveTimeout = time.Minute
+
 // Config holds the complete application configuration.
 type Config struct {
 	ClientID    string
+	// ClientIDs runs the whole pipeline once per client instead of for
+	// ClientID, at most ClientConcurrency clients at a time.
+	ClientIDs         []string
+	ClientConcurrency int
 	Environment string
 	LogLevel    string
 	Database    DBConfig
 	S3          S3Config
+	SFTP        SFTPConfig
+	// Secrets configures resolving references such as
+	// ssm:///path/to/param, secretsmanager://name#key and
+	// file:///run/secrets/db in any setting.
+	Secrets secrets.Config
+	// Sinks lists where exports are delivered: s3, local, sftp and/or postgres.
+	Sinks        []string
+	LocalSinkDir string
+	// TargetDatabase receives the exported tables for the postgres sink.
+	TargetDatabase     DBConfig
+	TargetSchema       string
+	TargetLoadStrategy string
+	TargetUpsertKeys   []string
 	Systems     []string
 	IgnoreSystems []string
 	MaxRowSizeFile int
+	ExportFormat string
+	ExportTimezone string
+	ExportCompression string
+	ExportStreaming bool
+	// ExportCopy exports CSV with COPY TO STDOUT instead of scanning rows.
+	ExportCopy bool
+	// Reconcile compares row counts and ReconcileSumColumns sums of every
+	// table with its CSV or Parquet export, and object sizes after upload.
+	Reconcile           bool
+	ReconcileSumColumns []string
+	// Diff compares report_oibl.oibl_customer with the previous run and
+	// exports the changed items.
+	Diff bool
+	ParquetCompression string
+	ParquetRowGroupSize int
 	ScriptsDir  string
 	InitScriptsDir string
 	ArchiveScriptsDir string
 	HistoryScriptsDir string
 	PrechecksScriptsDir string
 	DBMaxConnections int
+	// DBMaxIdleConns is deprecated and ignored, see Deprecated.
 	DBMaxIdleConns int
 	DBConnMaxIdleTime int
+	// JobTimeout bounds the whole run, ScriptTimeout each SQL script and
+	// StatementTimeout each statement (statement_timeout). Zero disables.
+	JobTimeout       time.Duration
+	ScriptTimeout    time.Duration
+	StatementTimeout time.Duration
+	// StatementRetries limits how often a statement or script failing on a
+	// deadlock, serialization failure, lock timeout or lost connection is
+	// run again. Zero disables.
+	StatementRetries int
+	// ScriptTransaction runs each SQL script as one implicit transaction
+	// instead of statement by statement.
+	ScriptTransaction bool
+	// RunLockPolicy decides what happens when another run of the same
+	// client and environment holds the run lock: fail or wait up to
+	// RunLockWait.
+	RunLockPolicy string
+	RunLockWait   time.Duration
+	// PublishKeep is the number of previous report_oibl versions kept for
+	// rollback. PublishRollback restores the given version instead of
+	// running the job.
+	PublishKeep     int
+	PublishRollback string
+
+	// settings are the resolved values and their sources, invalid the
+	// values that could not be parsed.
+	settings []Setting
+	invalid  []*FieldError
 }
 
 // DBConfig holds database connection configuration.
 type DBConfig struct {
-	Host        string
-	Port        int
-	Database    string
-	User        string
-	Password    string
-	MaxConns    int
+	Host     string
+	Port     int
+	Database string
+	User     string
+	Password string
+	// IAMAuth authenticates with RDS IAM tokens for Region instead of
+	// Password.
+	IAMAuth bool
+	Region  string
+	// SSLMode is a libpq sslmode, e.g. verify-full with SSLRootCert set to
+	// the RDS CA bundle. SSLCert and SSLKey authenticate the client.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// ApplicationName is shown in pg_stat_activity, SearchPath sets the
+	// schema search path of every connection.
+	ApplicationName string
+	SearchPath      string
+	MaxConns        int
+	// MaxIdle is deprecated and ignored, see Config.Deprecated.
 	MaxIdle     int
 	MinutesIdle int
 }
@@ -50,38 +135,194 @@ type S3Config struct {
 	URL             string
 	AccessKeyID     string
 	SecretAccessKey string
+	// UsePathStyle addresses buckets as <endpoint>/<bucket>, as required
+	// by MinIO and LocalStack. Defaults to true when URL is set.
+	UsePathStyle       bool
+	AssumeRoleARN      string
+	InsecureSkipVerify bool
+
+	// SSE is empty (bucket default), AES256 or aws:kms.
+	SSE            string
+	SSEKMSKeyID    string
+	StorageClass   string
+	RetentionClass string
+
+	// KeyTemplate lays out the object keys, e.g.
+	// {client}/{env}/{system}/dt={date}/run={run_id}/{file}.
+	KeyTemplate  string
+	CleanupStale bool
+
+	MultipartThresholdMB int
+	PartSizeMB           int
+	UploadConcurrency    int
+	MaxPartRetries       int
+}
+
+// SFTPConfig holds the configuration of the SFTP export sink.
+type SFTPConfig struct {
+	Host           string
+	Port           int
+	User           string
+	Password       string
+	PrivateKeyFile string
+	// KnownHostsFile verifies the server host key in OpenSSH known_hosts
+	// format. InsecureIgnoreHostKey skips the check (local only).
+	KnownHostsFile        string
+	InsecureIgnoreHostKey bool
+	RemoteDir             string
 }
 
-// Load reads configuration from environment variables and returns a Config instance.
+// Load reads configuration from environment variables and the config file
+// named by BDA_CONFIG, and returns a Config instance.
 func Load() (*Config, error) {
+	return LoadOptions(Options{File: os.Getenv(EnvPrefix + "CONFIG")})
+}
+
+// LoadOptions reads configuration from the command line options, the
+// environment, the config file and the defaults, in that order of
+// precedence, and validates it.
+func LoadOptions(opts Options) (*Config, error) {
+	cfg, err := Resolve(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Resolve reads the configuration like LoadOptions without validating it,
+// e.g. to show the effective configuration of an incomplete setup.
+func Resolve(opts Options) (*Config, error) {
+	var file map[string]string
+	if opts.File != "" {
+		var err error
+		if file, err = readFile(opts.File); err != nil {
+			return nil, err
+		}
+	}
+	l := newLoader(file, opts.File, opts.Flags)
+
+	secretsCfg := secrets.Config{
+		Region: l.get("SECRETS_REGION", "eu-central-1"),
+		URL:    l.get("SECRETS_URL", ""),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	l.ctx = ctx
+	l.resolver = opts.Resolver
+	if l.resolver == nil {
+		l.resolver = secrets.NewResolvers(secretsCfg)
+	}
+
 	cfg := &Config{
-		ClientID:    getEnv("CLIENT_ID", ""),
-		Environment: getEnv("ENVIRONMENT", detectEnvironment()),
-		LogLevel:    getEnv("LOG_LEVEL", defaultLogLevel()),
+		ClientID:    l.get("CLIENT_ID", ""),
+		ClientIDs:         parseSystems(l.get("CLIENT_IDS", "")),
+		ClientConcurrency: l.getInt("CLIENT_CONCURRENCY", 1),
+		Environment: l.get("ENVIRONMENT", detectEnvironment()),
+		LogLevel:    l.get("LOG_LEVEL", defaultLogLevel()),
 		Database: DBConfig{
-			Host:        getEnv("DB_HOST", ""),
-			Port:        getEnvInt("DB_PORT", 5432),
-			Database:    getEnv("DB_NAME", "octopus"),
-			User:        getEnv("DB_USER", "billing_aggregator"),
-			Password:    getEnv("DB_PASSWORD", ""),
-			MaxConns:    getEnvInt("DB_MAX_CONNS", 4),
-			MaxIdle:     getEnvInt("DB_MAX_IDLE", 0),
-			MinutesIdle: getEnvInt("DB_MINUTES_IDLE", 5),
+			Host:            l.get("DB_HOST", ""),
+			Port:            l.getInt("DB_PORT", 5432),
+			Database:        l.get("DB_NAME", "octopus"),
+			User:            l.get("DB_USER", "billing_aggregator"),
+			Password:        l.get("DB_PASSWORD", ""),
+			IAMAuth:         l.getBool("DB_IAM_AUTH", false),
+			Region:          l.get("DB_REGION", "eu-central-1"),
+			SSLMode:         strings.ToLower(l.get("DB_SSLMODE", defaultSSLMode())),
+			SSLRootCert:     l.get("DB_SSLROOTCERT", ""),
+			SSLCert:         l.get("DB_SSLCERT", ""),
+			SSLKey:          l.get("DB_SSLKEY", ""),
+			ApplicationName: l.get("DB_APPLICATION_NAME", "billing-data-aggregator"),
+			SearchPath:      l.get("DB_SEARCH_PATH", ""),
+			MaxConns:        l.getInt("DB_MAX_CONNS", 4),
+			MaxIdle:         l.getInt("DB_MAX_IDLE", 0),
+			MinutesIdle:     l.getInt("DB_MINUTES_IDLE", 5),
 		},
 		S3: S3Config{
-			Region:          getEnv("S3_REGION", "eu-central-1"),
-			Bucket:          getEnv("S3_BUCKET", ""),
-			URL:             getEnv("S3_URL", ""),
-			AccessKeyID:     getEnv("S3_ACCESS_KEY", ""),
-			SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+			Region:          l.get("S3_REGION", "eu-central-1"),
+			Bucket:          l.get("S3_BUCKET", ""),
+			URL:             l.get("S3_URL", ""),
+			AccessKeyID:     l.get("S3_ACCESS_KEY", ""),
+			SecretAccessKey: l.get("S3_SECRET_ACCESS_KEY", ""),
+			UsePathStyle:       l.getBool("S3_USE_PATH_STYLE", l.get("S3_URL", "") != ""),
+			AssumeRoleARN:      l.get("S3_ASSUME_ROLE_ARN", ""),
+			InsecureSkipVerify: l.getBool("S3_INSECURE_SKIP_VERIFY", false),
+
+			SSE:            l.get("S3_SSE", ""),
+			SSEKMSKeyID:    l.get("S3_SSE_KMS_KEY_ID", ""),
+			StorageClass:   l.get("S3_STORAGE_CLASS", ""),
+			RetentionClass: l.get("S3_RETENTION_CLASS", "standard"),
+
+			KeyTemplate:  l.get("S3_KEY_TEMPLATE", "{client}/{env}/{file}"),
+			CleanupStale: l.getBool("S3_CLEANUP_STALE", false),
+
+			MultipartThresholdMB: l.getInt("S3_MULTIPART_THRESHOLD_MB", 64),
+			PartSizeMB:           l.getInt("S3_PART_SIZE_MB", 16),
+			UploadConcurrency:    l.getInt("S3_UPLOAD_CONCURRENCY", 4),
+			MaxPartRetries:       l.getInt("S3_MAX_PART_RETRIES", 5),
+		},
+		Secrets: secretsCfg,
+		SFTP: SFTPConfig{
+			Host:                  l.get("SFTP_HOST", ""),
+			Port:                  l.getInt("SFTP_PORT", 22),
+			User:                  l.get("SFTP_USER", ""),
+			Password:              l.get("SFTP_PASSWORD", ""),
+			PrivateKeyFile:        l.get("SFTP_PRIVATE_KEY_FILE", ""),
+			KnownHostsFile:        l.get("SFTP_KNOWN_HOSTS_FILE", ""),
+			InsecureIgnoreHostKey: l.getBool("SFTP_INSECURE_IGNORE_HOST_KEY", false),
+			RemoteDir:             l.get("SFTP_REMOTE_DIR", "."),
 		},
-		Systems:        parseSystems(getEnv("SYSTEMS", "tripica,bookkeeper")),
-		IgnoreSystems:  parseSystems(getEnv("IGNORE_SYSTEMS", "")),
-		MaxRowSizeFile: getEnvInt("MAX_ROW_SIZE_FILE", 1000000),
-		ScriptsDir:     getEnv("SCRIPTS_DIR", "/app/scripts"),
-		DBMaxConnections: getEnvInt("DB_MAX_CONNS", 4),
-		DBMaxIdleConns: getEnvInt("DB_MAX_IDLE", 0),
-		DBConnMaxIdleTime: getEnvInt("DB_MINUTES_IDLE", 5),
+		TargetDatabase: DBConfig{
+			Host:            l.get("TARGET_DB_HOST", ""),
+			Port:            l.getInt("TARGET_DB_PORT", 5432),
+			Database:        l.get("TARGET_DB_NAME", "reporting"),
+			User:            l.get("TARGET_DB_USER", "billing_aggregator"),
+			Password:        l.get("TARGET_DB_PASSWORD", ""),
+			IAMAuth:         l.getBool("TARGET_DB_IAM_AUTH", false),
+			Region:          l.get("TARGET_DB_REGION", "eu-central-1"),
+			SSLMode:         strings.ToLower(l.get("TARGET_DB_SSLMODE", defaultSSLMode())),
+			SSLRootCert:     l.get("TARGET_DB_SSLROOTCERT", ""),
+			SSLCert:         l.get("TARGET_DB_SSLCERT", ""),
+			SSLKey:          l.get("TARGET_DB_SSLKEY", ""),
+			ApplicationName: l.get("TARGET_DB_APPLICATION_NAME", "billing-data-aggregator"),
+			SearchPath:      l.get("TARGET_DB_SEARCH_PATH", ""),
+			MaxConns:        l.getInt("TARGET_DB_MAX_CONNS", 2),
+			MaxIdle:         l.getInt("TARGET_DB_MAX_IDLE", 0),
+			MinutesIdle:     l.getInt("TARGET_DB_MINUTES_IDLE", 5),
+		},
+		TargetSchema:       l.get("TARGET_DB_SCHEMA", "public"),
+		TargetLoadStrategy: strings.ToLower(l.get("TARGET_LOAD_STRATEGY", "truncate")),
+		TargetUpsertKeys:   parseSystems(l.get("TARGET_UPSERT_KEYS", "")),
+		Sinks:          parseSystems(strings.ToLower(l.get("SINKS", "s3"))),
+		LocalSinkDir:   l.get("LOCAL_SINK_DIR", "./exports"),
+		Systems:        parseSystems(l.get("SYSTEMS", "tripica,bookkeeper")),
+		IgnoreSystems:  parseSystems(l.get("IGNORE_SYSTEMS", "")),
+		MaxRowSizeFile: l.getInt("MAX_ROW_SIZE_FILE", 1000000),
+		ExportFormat:   strings.ToLower(l.get("EXPORT_FORMAT", "csv")),
+		ExportTimezone: l.get("EXPORT_TIMEZONE", "Europe/Berlin"),
+		ExportCompression: strings.ToLower(l.get("EXPORT_COMPRESSION", "none")),
+		ExportStreaming: l.getBool("EXPORT_STREAMING", false),
+		ExportCopy:      l.getBool("EXPORT_COPY", false),
+		Reconcile:       l.getBool("RECONCILE", true),
+		ReconcileSumColumns: parseSystems(l.get("RECONCILE_SUM_COLUMNS", "")),
+		Diff:            l.getBool("DIFF", true),
+		ParquetCompression: strings.ToLower(l.get("PARQUET_COMPRESSION", "snappy")),
+		ParquetRowGroupSize: l.getInt("PARQUET_ROW_GROUP_SIZE", 100000),
+		ScriptsDir:     l.get("SCRIPTS_DIR", "/app/scripts"),
+		DBMaxConnections: l.getInt("DB_MAX_CONNS", 4),
+		DBMaxIdleConns: l.getInt("DB_MAX_IDLE", 0),
+		DBConnMaxIdleTime: l.getInt("DB_MINUTES_IDLE", 5),
+		JobTimeout:       l.getDuration("JOB_TIMEOUT", 0),
+		ScriptTimeout:    l.getDuration("SCRIPT_TIMEOUT", 0),
+		StatementTimeout: l.getDuration("STATEMENT_TIMEOUT", 0),
+		StatementRetries: l.getInt("STATEMENT_RETRIES", 3),
+		ScriptTransaction: l.getBool("SCRIPT_TRANSACTION", false),
+		RunLockPolicy:    strings.ToLower(l.get("RUN_LOCK_POLICY", "fail")),
+		RunLockWait:      l.getDuration("RUN_LOCK_WAIT", 30*time.Minute),
+		PublishKeep:      l.getInt("PUBLISH_KEEP", 3),
+		PublishRollback:  l.get("PUBLISH_ROLLBACK", ""),
 	}
 
 	if cfg.InitScriptsDir == "" {
@@ -97,28 +338,209 @@ func Load() (*Config, error) {
 		cfg.PrechecksScriptsDir = cfg.ScriptsDir + "/prechecks"
 	}
 
-	if err := cfg.Validate(); err != nil {
-		return nil, err
+	if unknown := l.unknown(); len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
 	}
+	cfg.settings = l.sorted()
+	cfg.invalid = l.invalid
 
 	return cfg, nil
 }
 
-// Validate checks if the configuration is valid and returns an error if not.
+// Validate checks the configuration and returns a *ValidationError listing
+// every invalid setting, or nil.
 func (c *Config) Validate() error {
-	if c.ClientID == "" {
-		return fmt.Errorf("CLIENT_ID is required")
+	v := &validator{errs: append([]*FieldError(nil), c.invalid...)}
+
+	switch {
+	case c.ClientID == "" && len(c.ClientIDs) == 0:
+		v.add("ClientID", "CLIENT_ID", "or BDA_CLIENT_IDS is required")
+	case c.ClientID != "" && len(c.ClientIDs) > 0:
+		v.add("ClientIDs", "CLIENT_IDS", "must not be set together with BDA_CLIENT_ID")
+	}
+	for i, id := range c.ClientIDs {
+		if slices.Contains(c.ClientIDs[:i], id) {
+			v.add("ClientIDs", "CLIENT_IDS", "must not contain %q twice", id)
+		}
+	}
+	if len(c.ClientIDs) > 0 && c.ClientConcurrency < 1 {
+		v.add("ClientConcurrency", "CLIENT_CONCURRENCY", "must be positive, got %d", c.ClientConcurrency)
 	}
 	if c.Database.Host == "" {
-		return fmt.Errorf("DB_HOST is required")
+		v.add("Database.Host", "DB_HOST", "is required")
+	}
+	if c.Database.Password == "" && !c.Database.IAMAuth {
+		v.add("Database.Password", "DB_PASSWORD", "is required unless BDA_DB_IAM_AUTH is set")
+	}
+	v.validateTLS("Database", "DB", c.Database)
+	if c.LogLevel != "" && !slices.Contains(LogLevels, c.LogLevel) {
+		v.add("LogLevel", "LOG_LEVEL", "must be one of %s, got %q", strings.Join(LogLevels, ", "), c.LogLevel)
+	}
+
+	for _, sink := range c.ExportSinks() {
+		switch sink {
+		case "s3":
+			switch {
+			case c.S3.Bucket == "":
+				v.add("S3.Bucket", "S3_BUCKET", "is required")
+			case !validBucket(c.S3.Bucket):
+				v.add("S3.Bucket", "S3_BUCKET", "must be a valid bucket name (3-63 lowercase letters, digits, dots and hyphens), got %q", c.S3.Bucket)
+			}
+			if c.S3.Region != "" && !regionPattern.MatchString(c.S3.Region) {
+				v.add("S3.Region", "S3_REGION", "must be an AWS region like eu-central-1, got %q", c.S3.Region)
+			}
+		case "local":
+			if c.LocalSinkDir == "" {
+				v.add("LocalSinkDir", "LOCAL_SINK_DIR", "is required for the local sink")
+			}
+			v.validateClientDir("LocalSinkDir", "LOCAL_SINK_DIR", c.LocalSinkDir, c.ClientIDs)
+		case "sftp":
+			if c.SFTP.Host == "" {
+				v.add("SFTP.Host", "SFTP_HOST", "is required for the sftp sink")
+			}
+			if c.SFTP.User == "" {
+				v.add("SFTP.User", "SFTP_USER", "is required for the sftp sink")
+			}
+			if c.SFTP.Password == "" && c.SFTP.PrivateKeyFile == "" {
+				v.add("SFTP.Password", "SFTP_PASSWORD", "or SFTP_PRIVATE_KEY_FILE is required for the sftp sink")
+			}
+			if c.SFTP.KnownHostsFile == "" && !c.SFTP.InsecureIgnoreHostKey {
+				v.add("SFTP.KnownHostsFile", "SFTP_KNOWN_HOSTS_FILE", "is required for the sftp sink")
+			}
+			v.validateClientDir("SFTP.RemoteDir", "SFTP_REMOTE_DIR", c.SFTP.RemoteDir, c.ClientIDs)
+		case "postgres":
+			if c.TargetDatabase.Host == "" {
+				v.add("TargetDatabase.Host", "TARGET_DB_HOST", "is required for the postgres sink")
+			}
+			if c.TargetDatabase.Password == "" && !c.TargetDatabase.IAMAuth {
+				v.add("TargetDatabase.Password", "TARGET_DB_PASSWORD", "is required for the postgres sink unless BDA_TARGET_DB_IAM_AUTH is set")
+			}
+			v.validateTLS("TargetDatabase", "TARGET_DB", c.TargetDatabase)
+			switch c.TargetLoadStrategy {
+			case "", "truncate", "swap":
+			case "upsert":
+				if len(c.TargetUpsertKeys) == 0 {
+					v.add("TargetUpsertKeys", "TARGET_UPSERT_KEYS", "is required for TARGET_LOAD_STRATEGY=upsert")
+				}
+			default:
+				v.add("TargetLoadStrategy", "TARGET_LOAD_STRATEGY", "must be truncate, swap or upsert, got %q", c.TargetLoadStrategy)
+			}
+		default:
+			v.add("Sinks", "SINKS", "must only contain s3, local, sftp or postgres, got %q", sink)
+		}
+	}
+
+	for _, system := range c.Systems {
+		if !slices.Contains(Processors, system) {
+			v.add("Systems", "SYSTEMS", "must only contain %s, got %q", strings.Join(Processors, ", "), system)
+		}
+		if slices.Contains(c.IgnoreSystems, system) {
+			v.add("IgnoreSystems", "IGNORE_SYSTEMS", "must not contain %q, which is in SYSTEMS", system)
+		}
+	}
+	v.validateDir("ScriptsDir", "SCRIPTS_DIR", c.ScriptsDir)
+	if c.ScriptsDir != "" {
+		v.validateDir("InitScriptsDir", "SCRIPTS_DIR", c.InitScriptsDir)
+	}
+
+	if c.MaxRowSizeFile <= 0 {
+		v.add("MaxRowSizeFile", "MAX_ROW_SIZE_FILE", "must be positive, got %d", c.MaxRowSizeFile)
+	}
+	switch c.ExportFormat {
+	case "", "csv", "parquet":
+	default:
+		v.add("ExportFormat", "EXPORT_FORMAT", "must be csv or parquet, got %q", c.ExportFormat)
+	}
+	if c.ExportCopy && c.ExportFormat == "parquet" {
+		v.add("ExportCopy", "EXPORT_COPY", "requires EXPORT_FORMAT=csv")
 	}
-	if c.Database.Password == "" {
-		return fmt.Errorf("DB_PASSWORD is required")
+	switch c.S3.SSE {
+	case "", "AES256", "aws:kms":
+	default:
+		v.add("S3.SSE", "S3_SSE", "must be AES256 or aws:kms, got %q", c.S3.SSE)
 	}
-	if c.S3.Bucket == "" {
-		return fmt.Errorf("S3_BUCKET is required")
+	if c.S3.SSEKMSKeyID != "" && c.S3.SSE != "aws:kms" {
+		v.add("S3.SSEKMSKeyID", "S3_SSE_KMS_KEY_ID", "requires S3_SSE=aws:kms")
 	}
-	return nil
+	if c.S3.KeyTemplate != "" && !strings.Contains(c.S3.KeyTemplate, "{file}") {
+		v.add("S3.KeyTemplate", "S3_KEY_TEMPLATE", "must contain {file}, got %q", c.S3.KeyTemplate)
+	}
+	switch c.ExportCompression {
+	case "", "none", "gzip":
+	default:
+		v.add("ExportCompression", "EXPORT_COMPRESSION", "must be none or gzip, got %q", c.ExportCompression)
+	}
+	if c.JobTimeout < 0 {
+		v.add("JobTimeout", "JOB_TIMEOUT", "must not be negative, got %s", c.JobTimeout)
+	}
+	if c.ScriptTimeout < 0 {
+		v.add("ScriptTimeout", "SCRIPT_TIMEOUT", "must not be negative, got %s", c.ScriptTimeout)
+	}
+	if c.StatementTimeout < 0 {
+		v.add("StatementTimeout", "STATEMENT_TIMEOUT", "must not be negative, got %s", c.StatementTimeout)
+	}
+	if c.StatementRetries < 0 {
+		v.add("StatementRetries", "STATEMENT_RETRIES", "must not be negative, got %d", c.StatementRetries)
+	}
+	switch c.RunLockPolicy {
+	case "", "fail", "wait":
+	default:
+		v.add("RunLockPolicy", "RUN_LOCK_POLICY", "must be fail or wait, got %q", c.RunLockPolicy)
+	}
+	if c.PublishKeep < 0 {
+		v.add("PublishKeep", "PUBLISH_KEEP", "must not be negative, got %d", c.PublishKeep)
+	}
+	return v.err()
+}
+
+// ExportSinks returns the configured sinks, defaulting to S3.
+func (c *Config) ExportSinks() []string {
+	if len(c.Sinks) == 0 {
+		return []string{"s3"}
+	}
+	return c.Sinks
+}
+
+// Deprecated returns the environment variables that are set but no longer
+// have an effect. BDA_DB_MAX_IDLE capped the idle connections of lib/pq;
+// pgxpool has no such cap and closes idle connections after
+// BDA_DB_MINUTES_IDLE instead.
+func (c *Config) Deprecated() []string {
+	var keys []string
+	if c.DBMaxIdleConns > 0 || c.Database.MaxIdle > 0 {
+		keys = append(keys, EnvPrefix+"DB_MAX_IDLE")
+	}
+	if c.TargetDatabase.MaxIdle > 0 {
+		keys = append(keys, EnvPrefix+"TARGET_DB_MAX_IDLE")
+	}
+	return keys
+}
+
+// Clients returns the clients to run, ClientIDs or else ClientID.
+func (c *Config) Clients() []string {
+	if len(c.ClientIDs) > 0 {
+		return c.ClientIDs
+	}
+	return []string{c.ClientID}
+}
+
+// ForClient returns the configuration of one client of a multi-client run.
+// {client} in database names and users, the target schema and the sink
+// directories is replaced by the client ID, e.g. BDA_DB_NAME=octopus_{client}.
+func (c *Config) ForClient(id string) *Config {
+	cfg := *c
+	cfg.ClientID = id
+	cfg.ClientIDs = nil
+
+	expand := func(s string) string { return strings.ReplaceAll(s, "{client}", id) }
+	cfg.Database.Database = expand(cfg.Database.Database)
+	cfg.Database.User = expand(cfg.Database.User)
+	cfg.TargetDatabase.Database = expand(cfg.TargetDatabase.Database)
+	cfg.TargetDatabase.User = expand(cfg.TargetDatabase.User)
+	cfg.TargetSchema = expand(cfg.TargetSchema)
+	cfg.LocalSinkDir = expand(cfg.LocalSinkDir)
+	cfg.SFTP.RemoteDir = expand(cfg.SFTP.RemoteDir)
+	return &cfg
 }
 
 // ConnectionString returns a PostgreSQL connection string from the config.
@@ -126,34 +548,45 @@ func (c *Config) ConnectionString() string {
 	return c.Database.ConnectionString()
 }
 
-// ConnectionString returns a PostgreSQL connection string from the database config.
+// ConnectionString returns a PostgreSQL connection URL from the database
+// config, with credentials and database name escaped.
 func (c *DBConfig) ConnectionString() string {
-	sslMode := "require"
-	if detectEnvironment() == "local" {
-		sslMode = "disable"
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:   "/" + c.Database,
+	}
+	if c.Password == "" {
+		u.User = url.User(c.User)
 	}
-	return fmt.Sprintf(
-		"postgres://%s:%s@%s:%d/%s?sslmode=%s",
-		c.User, c.Password, c.Host, c.Port, c.Database, sslMode,
-	)
-}
 
-func getEnv(key, defaultValue string) string {
-	key = EnvPrefix + key
-	if v, exists := os.LookupEnv(key); exists {
-		return v
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = defaultSSLMode()
 	}
-	return defaultValue
+	params := url.Values{"sslmode": {sslMode}}
+	for name, value := range map[string]string{
+		"sslrootcert":      c.SSLRootCert,
+		"sslcert":          c.SSLCert,
+		"sslkey":           c.SSLKey,
+		"application_name": c.ApplicationName,
+		"search_path":      c.SearchPath,
+	} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	key = EnvPrefix + key
-	if v, exists := os.LookupEnv(key); exists {
-		if i, err := strconv.Atoi(v); err == nil {
-			return i
-		}
+// defaultSSLMode requires TLS except for local databases.
+func defaultSSLMode() string {
+	if detectEnvironment() == "local" {
+		return "disable"
 	}
-	return defaultValue
+	return "require"
 }
 
 func detectEnvironment() string {
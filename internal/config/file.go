@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// readFile reads a YAML or TOML config file into settings keyed like the
+// environment variables without prefix. Nested keys are joined with
+// underscores, so
+//
+//	client_id: enercity
+//	systems: [tripica]
+//	db:
+//	  host: octopus.internal
+//
+// sets CLIENT_ID, SYSTEMS and DB_HOST. Lists are joined with commas.
+func readFile(path string) (map[string]string, error) {
+	// #nosec G304 -- the config file is chosen by the operator
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &doc)
+	case ".toml":
+		err = toml.Unmarshal(content, &doc)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+	if err := flatten(settings, "", doc); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// flatten adds the values of doc to settings under prefix.
+func flatten(settings map[string]string, prefix string, doc map[string]any) error {
+	for name, value := range doc {
+		key := settingKey(name)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		if nested, ok := value.(map[string]any); ok {
+			if err := flatten(settings, key, nested); err != nil {
+				return err
+			}
+			continue
+		}
+
+		v, err := settingValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if _, exists := settings[key]; exists {
+			return fmt.Errorf("%s is set twice", key)
+		}
+		settings[key] = v
+	}
+	return nil
+}
+
+// settingKey maps a file key or flag name like db-host or BDA_DB_HOST to
+// DB_HOST.
+func settingKey(name string) string {
+	key := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	return strings.TrimPrefix(key, EnvPrefix)
+}
+
+func settingValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			if _, isList := item.([]any); isList {
+				return "", fmt.Errorf("nested lists are not supported")
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
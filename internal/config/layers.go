@@ -0,0 +1,243 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/enercity/billing-data-aggregator/internal/secrets"
+)
+
+// Sources of a setting, in increasing precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// redacted replaces the value of secrets in the effective configuration.
+const redacted = "******"
+
+// secretSuffixes mark the settings whose values are never printed.
+var secretSuffixes = []string{"PASSWORD", "SECRET_ACCESS_KEY", "ACCESS_KEY", "TOKEN"}
+
+// Setting is the effective value of a configuration key and where it was
+// taken from.
+type Setting struct {
+	// Key is the environment variable name, e.g. BDA_DB_HOST.
+	Key    string
+	Value  string
+	Source string
+	Secret bool
+}
+
+// loader looks up settings in the flags, the environment, the config file
+// and the defaults, in that order, and records where each value came from.
+type loader struct {
+	file     map[string]string
+	fileName string
+	flags    map[string]string
+	settings map[string]Setting
+	// invalid are the values that could not be parsed or resolved.
+	invalid []*FieldError
+
+	// resolver replaces secret references by their values; resolved caches
+	// them by reference, as some keys are read twice.
+	ctx      context.Context
+	resolver secrets.Resolver
+	resolved map[string]string
+}
+
+func newLoader(file map[string]string, fileName string, flags map[string]string) *loader {
+	return &loader{
+		file:     file,
+		fileName: fileName,
+		flags:    flags,
+		settings: make(map[string]Setting),
+		resolved: make(map[string]string),
+	}
+}
+
+// lookup returns the raw value of key (without prefix) and its source.
+func (l *loader) lookup(key string) (string, string, bool) {
+	if v, ok := l.flags[key]; ok {
+		return v, SourceFlag, true
+	}
+	if v, ok := os.LookupEnv(EnvPrefix + key); ok {
+		return v, SourceEnv, true
+	}
+	if v, ok := l.file[key]; ok {
+		return v, SourceFile + ":" + l.fileName, true
+	}
+	return "", SourceDefault, false
+}
+
+func (l *loader) record(key, value, source string) {
+	l.settings[key] = Setting{
+		Key:    EnvPrefix + key,
+		Value:  value,
+		Source: source,
+		Secret: isSecret(key),
+	}
+}
+
+func (l *loader) get(key, defaultValue string) string {
+	v, source, ok := l.lookup(key)
+	if !ok {
+		v = defaultValue
+	}
+	l.record(key, v, source)
+	return l.resolve(key, v)
+}
+
+func (l *loader) getInt(key string, defaultValue int) int {
+	v, source, ok := l.lookup(key)
+	if !ok {
+		l.record(key, strconv.Itoa(defaultValue), SourceDefault)
+		return defaultValue
+	}
+	l.record(key, v, source)
+	v = l.resolve(key, v)
+	if i, err := strconv.Atoi(v); err == nil {
+		return i
+	}
+	l.fail(key, v, "an integer")
+	return defaultValue
+}
+
+func (l *loader) getBool(key string, defaultValue bool) bool {
+	v, source, ok := l.lookup(key)
+	if !ok {
+		l.record(key, strconv.FormatBool(defaultValue), SourceDefault)
+		return defaultValue
+	}
+	l.record(key, v, source)
+	v = l.resolve(key, v)
+	if b, err := strconv.ParseBool(v); err == nil {
+		return b
+	}
+	l.fail(key, v, "a boolean")
+	return defaultValue
+}
+
+func (l *loader) getDuration(key string, defaultValue time.Duration) time.Duration {
+	v, source, ok := l.lookup(key)
+	if !ok {
+		l.record(key, defaultValue.String(), SourceDefault)
+		return defaultValue
+	}
+	l.record(key, v, source)
+	v = l.resolve(key, v)
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	l.fail(key, v, "a duration like 30m")
+	return defaultValue
+}
+
+// resolve returns the secret a value references, or the value itself. The
+// recorded setting keeps the reference, so secrets are never shown.
+func (l *loader) resolve(key, value string) string {
+	if l.resolver == nil || !secrets.IsReference(value) {
+		return value
+	}
+	if resolved, ok := l.resolved[value]; ok {
+		return resolved
+	}
+	ref, err := secrets.Parse(value)
+	if err == nil {
+		var resolved string
+		if resolved, err = l.resolver.Resolve(l.ctx, ref); err == nil {
+			l.resolved[value] = resolved
+			return resolved
+		}
+	}
+	l.report(resolveError(key, value, err))
+	return value
+}
+
+// fail records an unparseable value.
+func (l *loader) fail(key, value, kind string) {
+	l.report(parseError(key, value, kind))
+}
+
+// report records an invalid value once, as some keys are read twice.
+func (l *loader) report(fieldErr *FieldError) {
+	for _, err := range l.invalid {
+		if err.Env == fieldErr.Env {
+			return
+		}
+	}
+	l.invalid = append(l.invalid, fieldErr)
+}
+
+// unknown returns the file and flag keys that are not settings, which are
+// most likely typos.
+func (l *loader) unknown() []string {
+	var keys []string
+	for _, layer := range []map[string]string{l.file, l.flags} {
+		for key := range layer {
+			if _, ok := l.settings[key]; !ok && !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sorted returns the recorded settings ordered by key.
+func (l *loader) sorted() []Setting {
+	settings := make([]Setting, 0, len(l.settings))
+	for _, s := range l.settings {
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings
+}
+
+func isSecret(key string) bool {
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Settings returns the effective value and source of every setting, with
+// secrets redacted unless they are secret references. It is empty for
+// configurations not created by Load.
+func (c *Config) Settings() []Setting {
+	settings := make([]Setting, len(c.settings))
+	for i, s := range c.settings {
+		if s.Secret && s.Value != "" && !secrets.IsReference(s.Value) {
+			s.Value = redacted
+		}
+		settings[i] = s
+	}
+	return settings
+}
+
+// WriteEffective writes the effective configuration as a table of keys,
+// values and sources.
+func (c *Config) WriteEffective(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE"); err != nil {
+		return err
+	}
+	for _, s := range c.Settings() {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Key, s.Value, s.Source); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
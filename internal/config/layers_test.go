@@ -0,0 +1,301 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/enercity/billing-data-aggregator/internal/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOptions_Precedence(t *testing.T) {
+	// Setup
+	file := writeConfigFile(t, "bda.yaml", `
+client_id: enercity
+systems: [tripica]
+export_copy: true
+db:
+  host: file-host
+  port: 5433
+  password: file-secret
+s3:
+  bucket: exports
+job_timeout: 4h
+`)
+	cleanup := setupTestEnv(t, map[string]string{
+		"BDA_DB_HOST":   "env-host",
+		"BDA_LOG_LEVEL": "error",
+	})
+	defer cleanup()
+
+	// Execute
+	cfg, err := LoadOptions(Options{File: file, Flags: map[string]string{"LOG_LEVEL": "warn"}})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "enercity", cfg.ClientID)
+	assert.Equal(t, []string{"tripica"}, cfg.Systems)
+	assert.True(t, cfg.ExportCopy)
+	assert.Equal(t, "env-host", cfg.Database.Host, "environment should override the file")
+	assert.Equal(t, 5433, cfg.Database.Port)
+	assert.Equal(t, "file-secret", cfg.Database.Password)
+	assert.Equal(t, 4*time.Hour, cfg.JobTimeout)
+	assert.Equal(t, "warn", cfg.LogLevel, "flags should override the environment")
+
+	sources := make(map[string]Setting)
+	for _, s := range cfg.Settings() {
+		sources[s.Key] = s
+	}
+	assert.Equal(t, SourceEnv, sources["BDA_DB_HOST"].Source)
+	assert.Equal(t, SourceFile+":"+file, sources["BDA_DB_PORT"].Source)
+	assert.Equal(t, SourceFlag, sources["BDA_LOG_LEVEL"].Source)
+	assert.Equal(t, SourceDefault, sources["BDA_EXPORT_FORMAT"].Source)
+	assert.Equal(t, "csv", sources["BDA_EXPORT_FORMAT"].Value)
+}
+
+func TestLoadOptions_TOML(t *testing.T) {
+	// Setup
+	file := writeConfigFile(t, "bda.toml", `
+client_id = "enercity"
+systems = ["tripica", "bookkeeper"]
+
+[db]
+host = "octopus.internal"
+password = "secret"
+
+[s3]
+bucket = "exports"
+upload_concurrency = 8
+`)
+	cleanup := setupTestEnv(t, nil)
+	defer cleanup()
+
+	// Execute
+	cfg, err := LoadOptions(Options{File: file})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "enercity", cfg.ClientID)
+	assert.Equal(t, []string{"tripica", "bookkeeper"}, cfg.Systems)
+	assert.Equal(t, "octopus.internal", cfg.Database.Host)
+	assert.Equal(t, 8, cfg.S3.UploadConcurrency)
+}
+
+func TestLoadOptions_SecretReferences(t *testing.T) {
+	// Setup
+	passwordFile := writeConfigFile(t, "sftp_password", "sftp-secret\n")
+	cleanup := setupTestEnv(t, map[string]string{
+		"BDA_CLIENT_ID":          "test-client",
+		"BDA_DB_HOST":            "localhost",
+		"BDA_DB_PASSWORD":        "ssm:///config/enercity/prod/secrets/db_password",
+		"BDA_DB_PORT":            "secretsmanager://billing/db#port",
+		"BDA_S3_BUCKET":          "test-bucket",
+		"BDA_SFTP_PASSWORD":      "file://" + passwordFile,
+		"BDA_TARGET_DB_PASSWORD": "plain-secret",
+	})
+	defer cleanup()
+
+	resolver := secrets.Resolvers{
+		secrets.SchemeFile: secrets.FileResolver{},
+		secrets.SchemeSSM:  secrets.Fake{"ssm:///config/enercity/prod/secrets/db_password": "db-secret"},
+		secrets.SchemeSecretsManager: secrets.Fake{
+			"secretsmanager://billing/db": `{"port": 5433}`,
+		},
+	}
+
+	// Execute
+	cfg, err := LoadOptions(Options{Resolver: resolver})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "db-secret", cfg.Database.Password)
+	assert.Equal(t, 5433, cfg.Database.Port)
+	assert.Equal(t, "sftp-secret", cfg.SFTP.Password)
+	assert.Equal(t, "plain-secret", cfg.TargetDatabase.Password)
+
+	var out bytes.Buffer
+	require.NoError(t, cfg.WriteEffective(&out))
+	assert.NotContains(t, out.String(), "db-secret")
+	assert.Regexp(t, `BDA_DB_PASSWORD\s+ssm:///config/enercity/prod/secrets/db_password\s+env`, out.String(),
+		"references should be shown instead of the secrets")
+	assert.Regexp(t, `BDA_TARGET_DB_PASSWORD\s+\*+\s+env`, out.String())
+}
+
+func TestLoadOptions_UnresolvableSecret(t *testing.T) {
+	// Setup
+	cleanup := setupTestEnv(t, map[string]string{
+		"BDA_CLIENT_ID":   "test-client",
+		"BDA_DB_HOST":     "localhost",
+		"BDA_DB_PASSWORD": "ssm:///config/enercity/prod/secrets/missing",
+		"BDA_S3_BUCKET":   "test-bucket",
+	})
+	defer cleanup()
+
+	// Execute
+	_, err := LoadOptions(Options{Resolver: secrets.Resolvers{secrets.SchemeSSM: secrets.Fake{}}})
+
+	// Assert
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr), "expected ValidationError, got %v", err)
+	require.Len(t, validationErr.Errors, 1)
+	assert.Equal(t, "Database.Password", validationErr.Errors[0].Field)
+	assert.Contains(t, validationErr.Errors[0].Reason, "cannot resolve ssm:///config/enercity/prod/secrets/missing")
+}
+
+func TestResolve_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		flags     map[string]string
+		wantError string
+	}{
+		{
+			name:      "unknown file key",
+			file:      "bda.yaml",
+			content:   "db:\n  hots: localhost\n",
+			wantError: "unknown settings: DB_HOTS",
+		},
+		{
+			name:      "unknown flag",
+			flags:     map[string]string{"EXPORT_FROMAT": "csv"},
+			wantError: "unknown settings: EXPORT_FROMAT",
+		},
+		{
+			name:      "set twice",
+			file:      "bda.yaml",
+			content:   "db_host: a\ndb:\n  host: b\n",
+			wantError: "DB_HOST is set twice",
+		},
+		{
+			name:      "unsupported extension",
+			file:      "bda.json",
+			content:   "{}",
+			wantError: "must be .yaml, .yml or .toml",
+		},
+		{
+			name:      "invalid yaml",
+			file:      "bda.yaml",
+			content:   "db: [",
+			wantError: "failed to parse config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Flags: tt.flags}
+			if tt.file != "" {
+				opts.File = writeConfigFile(t, tt.file, tt.content)
+			}
+
+			_, err := Resolve(opts)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantError)
+		})
+	}
+}
+
+func TestConfig_WriteEffectiveRedactsSecrets(t *testing.T) {
+	// Setup
+	cleanup := setupTestEnv(t, map[string]string{
+		"BDA_CLIENT_ID":            "test-client",
+		"BDA_DB_HOST":              "localhost",
+		"BDA_DB_PASSWORD":          "test-password",
+		"BDA_S3_BUCKET":            "test-bucket",
+		"BDA_S3_SECRET_ACCESS_KEY": "test-secret-key",
+	})
+	defer cleanup()
+
+	cfg, err := Resolve(Options{})
+	require.NoError(t, err)
+	var out bytes.Buffer
+
+	// Execute
+	err = cfg.WriteEffective(&out)
+
+	// Assert
+	require.NoError(t, err)
+	assert.NotContains(t, out.String(), "test-password")
+	assert.NotContains(t, out.String(), "test-secret-key")
+	assert.Regexp(t, `BDA_DB_PASSWORD\s+\*+\s+env`, out.String())
+	assert.Regexp(t, `BDA_DB_HOST\s+localhost\s+env`, out.String())
+	assert.Regexp(t, `BDA_SFTP_PASSWORD\s+default`, out.String(), "empty secrets should stay empty")
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		want      Options
+		wantError string
+	}{
+		{
+			name: "defaults",
+			want: Options{Command: CommandRun, Flags: map[string]string{}},
+		},
+		{
+			name: "command, config and settings",
+			args: []string{"effective-config", "--config", "bda.yaml", "--db-host=localhost", "--export-copy", "--systems", "tripica"},
+			want: Options{
+				Command: CommandEffectiveConfig,
+				File:    "bda.yaml",
+				Flags:   map[string]string{"DB_HOST": "localhost", "EXPORT_COPY": "true", "SYSTEMS": "tripica"},
+			},
+		},
+		{
+			name: "boolean flag before command",
+			args: []string{"--export-streaming", "run"},
+			want: Options{Command: CommandRun, Flags: map[string]string{"EXPORT_STREAMING": "true"}},
+		},
+		{
+			name:      "unknown command",
+			args:      []string{"export"},
+			wantError: `unknown command "export"`,
+		},
+		{
+			name:      "flag twice",
+			args:      []string{"--db-host=a", "--db-host=b"},
+			wantError: "flag --db-host is set twice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseArgs(tt.args)
+
+			if tt.wantError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseArgs_ConfigFromEnv(t *testing.T) {
+	cleanup := setupTestEnv(t, map[string]string{"BDA_CONFIG": "/etc/bda/enercity.yaml"})
+	defer cleanup()
+
+	opts, err := ParseArgs(nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/bda/enercity.yaml", opts.File)
+}
+
+// Helper Functions
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
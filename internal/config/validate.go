@@ -0,0 +1,196 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Processors are the systems with a processor, see internal/processors.
+var Processors = []string{"tripica", "bookkeeper"}
+
+// LogLevels are the supported log levels.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// SSLModes are the supported libpq sslmode values.
+var SSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+var (
+	regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+	bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+)
+
+// fields maps the keys of typed settings and secrets to their Config
+// fields, for reporting values that cannot be parsed or resolved.
+var fields = map[string]string{
+	"CLIENT_CONCURRENCY":            "ClientConcurrency",
+	"DB_PASSWORD":                   "Database.Password",
+	"TARGET_DB_PASSWORD":            "TargetDatabase.Password",
+	"SFTP_PASSWORD":                 "SFTP.Password",
+	"S3_ACCESS_KEY":                 "S3.AccessKeyID",
+	"S3_SECRET_ACCESS_KEY":          "S3.SecretAccessKey",
+	"DB_PORT":                       "Database.Port",
+	"DB_IAM_AUTH":                   "Database.IAMAuth",
+	"DB_MAX_CONNS":                  "Database.MaxConns",
+	"DB_MAX_IDLE":                   "Database.MaxIdle",
+	"DB_MINUTES_IDLE":               "Database.MinutesIdle",
+	"S3_USE_PATH_STYLE":             "S3.UsePathStyle",
+	"S3_INSECURE_SKIP_VERIFY":       "S3.InsecureSkipVerify",
+	"S3_CLEANUP_STALE":              "S3.CleanupStale",
+	"S3_MULTIPART_THRESHOLD_MB":     "S3.MultipartThresholdMB",
+	"S3_PART_SIZE_MB":               "S3.PartSizeMB",
+	"S3_UPLOAD_CONCURRENCY":         "S3.UploadConcurrency",
+	"S3_MAX_PART_RETRIES":           "S3.MaxPartRetries",
+	"SFTP_PORT":                     "SFTP.Port",
+	"SFTP_INSECURE_IGNORE_HOST_KEY": "SFTP.InsecureIgnoreHostKey",
+	"TARGET_DB_PORT":                "TargetDatabase.Port",
+	"TARGET_DB_IAM_AUTH":            "TargetDatabase.IAMAuth",
+	"TARGET_DB_MAX_CONNS":           "TargetDatabase.MaxConns",
+	"TARGET_DB_MAX_IDLE":            "TargetDatabase.MaxIdle",
+	"TARGET_DB_MINUTES_IDLE":        "TargetDatabase.MinutesIdle",
+	"MAX_ROW_SIZE_FILE":             "MaxRowSizeFile",
+	"EXPORT_STREAMING":              "ExportStreaming",
+	"EXPORT_COPY":                   "ExportCopy",
+	"RECONCILE":                     "Reconcile",
+	"DIFF":                          "Diff",
+	"PARQUET_ROW_GROUP_SIZE":        "ParquetRowGroupSize",
+	"JOB_TIMEOUT":                   "JobTimeout",
+	"SCRIPT_TIMEOUT":                "ScriptTimeout",
+	"STATEMENT_TIMEOUT":             "StatementTimeout",
+	"STATEMENT_RETRIES":             "StatementRetries",
+	"SCRIPT_TRANSACTION":            "ScriptTransaction",
+	"RUN_LOCK_WAIT":                 "RunLockWait",
+	"PUBLISH_KEEP":                  "PublishKeep",
+}
+
+// FieldError describes an invalid setting.
+type FieldError struct {
+	// Field is the Config field, e.g. Database.Port.
+	Field string
+	// Env is the environment variable, e.g. BDA_DB_PORT.
+	Env    string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %s (%s)", e.Env, e.Reason, e.Field)
+}
+
+// ValidationError lists every invalid setting of a configuration.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the field errors for errors.As.
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// validator collects the problems of a configuration.
+type validator struct {
+	errs []*FieldError
+}
+
+func (v *validator) add(field, key, format string, args ...any) {
+	v.errs = append(v.errs, &FieldError{Field: field, Env: EnvPrefix + key, Reason: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// parseError reports a value of key that is not of the setting's type.
+func parseError(key, value, kind string) *FieldError {
+	return &FieldError{Field: fieldOf(key), Env: EnvPrefix + key, Reason: fmt.Sprintf("must be %s, got %q", kind, value)}
+}
+
+// resolveError reports a secret reference of key that cannot be resolved.
+func resolveError(key, ref string, err error) *FieldError {
+	return &FieldError{Field: fieldOf(key), Env: EnvPrefix + key, Reason: fmt.Sprintf("cannot resolve %s: %v", ref, err)}
+}
+
+func fieldOf(key string) string {
+	if field, ok := fields[key]; ok {
+		return field
+	}
+	return key
+}
+
+// validateDir reports dir if it is set but not a directory.
+func (v *validator) validateDir(field, key, dir string) {
+	if dir == "" {
+		return
+	}
+	info, err := os.Stat(dir)
+	switch {
+	case err != nil:
+		v.add(field, key, "must be an existing directory, got %q", dir)
+	case !info.IsDir():
+		v.add(field, key, "must be a directory, got file %q", dir)
+	}
+}
+
+// validateClientDir reports a sink directory shared by the clients of a
+// multi-client run: without {client} they write the same file names into
+// it and overwrite each other's exports.
+func (v *validator) validateClientDir(field, key, dir string, clients []string) {
+	if len(clients) > 1 && dir != "" && !strings.Contains(dir, "{client}") {
+		v.add(field, key, "must contain {client} with several BDA_CLIENT_IDS, got %q", dir)
+	}
+}
+
+// validateFile reports path if it is set but not a readable file.
+func (v *validator) validateFile(field, key, path string) {
+	if path == "" {
+		return
+	}
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		v.add(field, key, "must be an existing file, got %q", path)
+	case info.IsDir():
+		v.add(field, key, "must be a file, got directory %q", path)
+	}
+}
+
+// validateTLS checks the TLS settings of a database, whose fields and keys
+// start with field and key, e.g. Database and DB.
+func (v *validator) validateTLS(field, key string, db DBConfig) {
+	if db.SSLMode != "" && !slices.Contains(SSLModes, db.SSLMode) {
+		v.add(field+".SSLMode", key+"_SSLMODE", "must be one of %s, got %q", strings.Join(SSLModes, ", "), db.SSLMode)
+	}
+	if db.IAMAuth && db.SSLMode == "disable" {
+		v.add(field+".SSLMode", key+"_SSLMODE", "must not be disable with %s%s_IAM_AUTH", EnvPrefix, key)
+	}
+	v.validateFile(field+".SSLRootCert", key+"_SSLROOTCERT", db.SSLRootCert)
+	v.validateFile(field+".SSLCert", key+"_SSLCERT", db.SSLCert)
+	v.validateFile(field+".SSLKey", key+"_SSLKEY", db.SSLKey)
+	if (db.SSLCert == "") != (db.SSLKey == "") {
+		v.add(field+".SSLKey", key+"_SSLKEY", "must be set together with %s%s_SSLCERT", EnvPrefix, key)
+	}
+}
+
+// validBucket reports whether name follows the S3 bucket naming rules.
+func validBucket(name string) bool {
+	return bucketPattern.MatchString(name) &&
+		!strings.Contains(name, "..") &&
+		net.ParseIP(name) == nil
+}
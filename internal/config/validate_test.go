@@ -0,0 +1,215 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate_CollectsAllErrors(t *testing.T) {
+	// Setup
+	cfg := &Config{MaxRowSizeFile: 1000}
+
+	// Execute
+	err := cfg.Validate()
+
+	// Assert
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr), "expected ValidationError, got %v", err)
+	assert.Equal(t, []*FieldError{
+		{Field: "ClientID", Env: "BDA_CLIENT_ID", Reason: "or BDA_CLIENT_IDS is required"},
+		{Field: "Database.Host", Env: "BDA_DB_HOST", Reason: "is required"},
+		{Field: "Database.Password", Env: "BDA_DB_PASSWORD", Reason: "is required unless BDA_DB_IAM_AUTH is set"},
+		{Field: "S3.Bucket", Env: "BDA_S3_BUCKET", Reason: "is required"},
+	}, validationErr.Errors)
+	assert.Equal(t, "invalid configuration: BDA_CLIENT_ID or BDA_CLIENT_IDS is required (ClientID); "+
+		"BDA_DB_HOST is required (Database.Host); BDA_DB_PASSWORD is required unless BDA_DB_IAM_AUTH is set (Database.Password); "+
+		"BDA_S3_BUCKET is required (S3.Bucket)", err.Error())
+
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr), "field errors should be unwrappable")
+	assert.Equal(t, "BDA_CLIENT_ID", fieldErr.Env)
+}
+
+func TestValidate_Settings(t *testing.T) {
+	scriptsFile := filepath.Join(t.TempDir(), "scripts")
+	require.NoError(t, os.WriteFile(scriptsFile, nil, 0o600))
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		field  string
+		reason string
+	}{
+		{
+			name:   "Unknown log level",
+			modify: func(c *Config) { c.LogLevel = "verbose" },
+			field:  "LogLevel",
+			reason: `must be one of debug, info, warn, error, got "verbose"`,
+		},
+		{
+			name:   "Zero rows per file",
+			modify: func(c *Config) { c.MaxRowSizeFile = 0 },
+			field:  "MaxRowSizeFile",
+			reason: "must be positive, got 0",
+		},
+		{
+			name:   "Unknown system",
+			modify: func(c *Config) { c.Systems = []string{"tripica", "sap"} },
+			field:  "Systems",
+			reason: `must only contain tripica, bookkeeper, got "sap"`,
+		},
+		{
+			name: "System included and ignored",
+			modify: func(c *Config) {
+				c.Systems = []string{"tripica", "bookkeeper"}
+				c.IgnoreSystems = []string{"bookkeeper"}
+			},
+			field:  "IgnoreSystems",
+			reason: `must not contain "bookkeeper", which is in SYSTEMS`,
+		},
+		{
+			name:   "Missing scripts directory",
+			modify: func(c *Config) { c.ScriptsDir = "/nonexistent/scripts" },
+			field:  "ScriptsDir",
+			reason: `must be an existing directory, got "/nonexistent/scripts"`,
+		},
+		{
+			name:   "Scripts directory is a file",
+			modify: func(c *Config) { c.ScriptsDir = scriptsFile },
+			field:  "ScriptsDir",
+			reason: `must be a directory, got file "` + scriptsFile + `"`,
+		},
+		{
+			name:   "Malformed region",
+			modify: func(c *Config) { c.S3.Region = "eu_central_1" },
+			field:  "S3.Region",
+			reason: `must be an AWS region like eu-central-1, got "eu_central_1"`,
+		},
+		{
+			name:   "Uppercase bucket",
+			modify: func(c *Config) { c.S3.Bucket = "Billing_Exports" },
+			field:  "S3.Bucket",
+			reason: `must be a valid bucket name (3-63 lowercase letters, digits, dots and hyphens), got "Billing_Exports"`,
+		},
+		{
+			name: "Client and clients",
+			modify: func(c *Config) {
+				c.ClientIDs = []string{"enercity", "q-cells"}
+				c.ClientConcurrency = 1
+			},
+			field:  "ClientIDs",
+			reason: "must not be set together with BDA_CLIENT_ID",
+		},
+		{
+			name: "Duplicate client",
+			modify: func(c *Config) {
+				c.ClientID = ""
+				c.ClientIDs = []string{"enercity", "q-cells", "enercity"}
+				c.ClientConcurrency = 2
+			},
+			field:  "ClientIDs",
+			reason: `must not contain "enercity" twice`,
+		},
+		{
+			name: "No client concurrency",
+			modify: func(c *Config) {
+				c.ClientID = ""
+				c.ClientIDs = []string{"enercity", "q-cells"}
+			},
+			field:  "ClientConcurrency",
+			reason: "must be positive, got 0",
+		},
+		{
+			name:   "Unknown sslmode",
+			modify: func(c *Config) { c.Database.SSLMode = "strict" },
+			field:  "Database.SSLMode",
+			reason: `must be one of disable, allow, prefer, require, verify-ca, verify-full, got "strict"`,
+		},
+		{
+			name: "IAM auth without TLS",
+			modify: func(c *Config) {
+				c.Database.IAMAuth = true
+				c.Database.SSLMode = "disable"
+			},
+			field:  "Database.SSLMode",
+			reason: "must not be disable with BDA_DB_IAM_AUTH",
+		},
+		{
+			name:   "Missing root certificate",
+			modify: func(c *Config) { c.Database.SSLRootCert = "/nonexistent/rds-global-bundle.pem" },
+			field:  "Database.SSLRootCert",
+			reason: `must be an existing file, got "/nonexistent/rds-global-bundle.pem"`,
+		},
+		{
+			name:   "Client certificate without key",
+			modify: func(c *Config) { c.Database.SSLCert = scriptsFile },
+			field:  "Database.SSLKey",
+			reason: "must be set together with BDA_DB_SSLCERT",
+		},
+		{
+			name:   "IP address bucket",
+			modify: func(c *Config) { c.S3.Bucket = "192.168.1.1" },
+			field:  "S3.Bucket",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				ClientID:       "test-client",
+				Database:       DBConfig{Host: "localhost", Password: "secret"},
+				S3:             S3Config{Bucket: "test-bucket", Region: "eu-central-1"},
+				MaxRowSizeFile: 1000,
+			}
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+
+			var validationErr *ValidationError
+			require.True(t, errors.As(err, &validationErr), "expected ValidationError, got %v", err)
+			require.Len(t, validationErr.Errors, 1)
+			assert.Equal(t, tt.field, validationErr.Errors[0].Field)
+			if tt.reason != "" {
+				assert.Equal(t, tt.reason, validationErr.Errors[0].Reason)
+			}
+		})
+	}
+}
+
+func TestLoad_InvalidValues(t *testing.T) {
+	// Setup
+	cleanup := setupTestEnv(t, map[string]string{
+		"BDA_CLIENT_ID":         "test-client",
+		"BDA_DB_HOST":           "localhost",
+		"BDA_DB_PORT":           "54x2",
+		"BDA_DB_MAX_CONNS":      "many",
+		"BDA_DB_PASSWORD":       "test-password",
+		"BDA_S3_BUCKET":         "test-bucket",
+		"BDA_EXPORT_COPY":       "yes please",
+		"BDA_JOB_TIMEOUT":       "4 hours",
+		"BDA_LOG_LEVEL":         "trace",
+		"BDA_MAX_ROW_SIZE_FILE": "0",
+	})
+	defer cleanup()
+
+	// Execute
+	_, err := Load()
+
+	// Assert
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr), "expected ValidationError, got %v", err)
+	envs := make([]string, 0, len(validationErr.Errors))
+	for _, e := range validationErr.Errors {
+		envs = append(envs, e.Env)
+	}
+	assert.ElementsMatch(t, []string{
+		"BDA_DB_PORT", "BDA_DB_MAX_CONNS", "BDA_EXPORT_COPY", "BDA_JOB_TIMEOUT",
+		"BDA_LOG_LEVEL", "BDA_MAX_ROW_SIZE_FILE",
+	}, envs, "every invalid value should be reported once")
+	assert.Contains(t, err.Error(), `BDA_DB_PORT must be an integer, got "54x2" (Database.Port)`)
+}
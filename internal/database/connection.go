@@ -5,82 +5,126 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
-	// PostgreSQL driver for database/sql
-	_ "github.com/lib/pq"
+	"github.com/enercity/billing-data-aggregator/internal/retry"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-// Connection wraps a database connection pool.
+// Connection wraps a pgx connection pool. DB exposes the pool through
+// database/sql for existing callers, Pool gives access to pgx-native
+// features like COPY streaming and LISTEN/NOTIFY.
 type Connection struct {
-	db *sql.DB
+	pool *pgxpool.Pool
+	db   *sql.DB
 }
 
 // NewConnection creates a new database connection with the given parameters.
-// It verifies the connection before returning.
-func NewConnection(connStr string, maxConns, maxIdle, minutesIdle int) (*Connection, error) {
-	db, err := sql.Open("postgres", connStr)
+// Connections idle for longer than minutesIdle are closed. With tokens,
+// e.g. an RDSTokenProvider, new connections authenticate with a token
+// instead of the password of connStr; nil uses the password. Server notices
+// are logged with the logger of ctx. It verifies the connection before
+// returning, retrying while the database is unreachable.
+func NewConnection(ctx context.Context, connStr string, tokens TokenProvider, maxConns, minutesIdle int) (*Connection, error) {
+	cfg, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to parse connection string: %w", err)
+	}
+
+	applyPoolSettings(cfg, maxConns, minutesIdle)
+	cfg.ConnConfig.OnNotice = noticeLogger(log.Ctx(ctx))
+	if tokens != nil {
+		applyTokenProvider(cfg, tokens)
 	}
 
-	db.SetMaxOpenConns(maxConns)
-	db.SetMaxIdleConns(maxIdle)
-	db.SetConnMaxIdleTime(time.Duration(minutesIdle) * time.Minute)
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db := stdlib.OpenDBFromPool(pool)
 
-	if err := verifyConnection(db); err != nil {
+	if err := verifyConnection(ctx, db, connectPolicy); err != nil {
 		_ = db.Close() // Ignore close error during error handling
+		pool.Close()
 		return nil, err
 	}
 
-	log.Info().
+	log.Ctx(ctx).Info().
+		Bool("iam_auth", tokens != nil).
 		Int("max_conns", maxConns).
-		Int("max_idle", maxIdle).
 		Int("minutes_idle", minutesIdle).
 		Msg("Database connection established")
 
-	return &Connection{db: db}, nil
+	return &Connection{pool: pool, db: db}, nil
 }
 
-func verifyConnection(db *sql.DB) error {
-	waitSeconds := []time.Duration{0, 5, 10, 20, 60}
-	maxRetries := len(waitSeconds)
+func applyPoolSettings(cfg *pgxpool.Config, maxConns, minutesIdle int) {
+	if maxConns > 0 {
+		cfg.MaxConns = int32(min(maxConns, 1<<15)) // #nosec G115 -- clamped above
+	}
+	if minutesIdle > 0 {
+		cfg.MaxConnIdleTime = time.Duration(minutesIdle) * time.Minute
+	}
+}
 
-	var lastErr error
-	for retry := 0; retry < maxRetries; retry++ {
-		if retry > 0 {
-			log.Warn().
-				Int("retry", retry).
-				Dur("wait", waitSeconds[retry]*time.Second).
-				Err(lastErr).
-				Msg("Retrying database connection")
-			time.Sleep(waitSeconds[retry] * time.Second)
-		}
+// noticeLogger forwards server notices, e.g. RAISE NOTICE in scripts, to
+// logger, which carries the client and run of the connection.
+func noticeLogger(logger *zerolog.Logger) pgconn.NoticeHandler {
+	return func(_ *pgconn.PgConn, n *pgconn.Notice) {
+		logger.Info().
+			Str("severity", n.Severity).
+			Str("code", n.Code).
+			Msg(n.Message)
+	}
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		lastErr = db.PingContext(ctx)
-		cancel()
+// connectPolicy retries the first connection for up to two minutes, e.g.
+// while the database starts or fails over. Rejected credentials fail at
+// once.
+var connectPolicy = retry.Policy{
+	Name:       "database connection",
+	BaseDelay:  2 * time.Second,
+	MaxDelay:   30 * time.Second,
+	MaxElapsed: 2 * time.Minute,
+}
 
-		if lastErr == nil {
-			return nil
-		}
+func verifyConnection(ctx context.Context, db *sql.DB, policy retry.Policy) error {
+	err := retry.Do(ctx, policy, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return db.PingContext(ctx)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
 	}
-
-	return fmt.Errorf("failed to connect after %d retries: %w", maxRetries, lastErr)
+	return nil
 }
 
-// DB returns the underlying sql.DB instance.
+// DB returns the pool as sql.DB instance.
 func (c *Connection) DB() *sql.DB {
 	return c.db
 }
 
+// Pool returns the underlying pgx pool.
+func (c *Connection) Pool() *pgxpool.Pool {
+	return c.pool
+}
+
 // Close closes the database connection pool.
 func (c *Connection) Close() error {
-	if c.db != nil {
-		return c.db.Close()
+	if c.db == nil {
+		return nil
 	}
-	return nil
+	err := c.db.Close()
+	c.pool.Close()
+	return err
 }
 
 // Ping verifies the database connection is alive.
@@ -107,3 +151,55 @@ func (c *Connection) QueryRowContext(ctx context.Context, query string, args ...
 func (c *Connection) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
 	return c.db.BeginTx(ctx, opts)
 }
+
+// CopyTo runs the COPY ... TO STDOUT statement on a pooled connection and
+// writes its output to w. It returns the number of copied rows.
+func (c *Connection) CopyTo(ctx context.Context, w io.Writer, sql string) (int64, error) {
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	tag, err := conn.Conn().PgConn().CopyTo(ctx, w, sql)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CopyToSnapshot runs the COPY ... TO STDOUT statement like CopyTo, but in
+// a read-only transaction that imports snapshot, the result of
+// pg_export_snapshot() in a still open transaction. The copy then sees the
+// same rows as that transaction.
+func (c *Connection) CopyToSnapshot(ctx context.Context, w io.Writer, sql, snapshot string) (int64, error) {
+	conn, err := c.pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(context.WithoutCancel(ctx)) // Read-only, nothing to commit
+	}()
+
+	// SET TRANSACTION does not take parameters.
+	if _, err := tx.Exec(ctx, "SET TRANSACTION SNAPSHOT "+quoteLiteral(snapshot)); err != nil {
+		return 0, fmt.Errorf("failed to import snapshot %s: %w", snapshot, err)
+	}
+
+	tag, err := tx.Conn().PgConn().CopyTo(ctx, w, sql)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// quoteLiteral quotes s as an SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -1,6 +1,15 @@
 package database
 
-import "testing"
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
 
 func TestScriptExecutorIgnoresSystems(t *testing.T) {
 	executor := NewScriptExecutor(nil, []string{"test_system", "ignored"})
@@ -18,6 +27,55 @@ func TestScriptExecutorIgnoresSystems(t *testing.T) {
 	}
 }
 
+func TestCollectScripts_Overlays(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"00_base.sql",
+		"10_contracts.sql",
+		"NOEXEC_debug.sql",
+		"README.md",
+		"enercity/10_contracts.sql",
+		"enercity/20_enercity.sql",
+		"default/30_default.sql",
+		"local/10_contracts.sql",
+		"local/40_local.sql",
+		"local/nested/50_nested.sql",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("SELECT 1;"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		client string
+		env    string
+		want   []string
+	}{
+		{"enercity", "prod", []string{"00_base.sql", "enercity/10_contracts.sql", "enercity/20_enercity.sql"}},
+		{"q-cells", "prod", []string{"00_base.sql", "10_contracts.sql", "default/30_default.sql"}},
+		{"", "", []string{"00_base.sql", "10_contracts.sql", "default/30_default.sql"}},
+		{"enercity", "local", []string{"00_base.sql", "local/10_contracts.sql", "enercity/20_enercity.sql", "local/40_local.sql"}},
+		{"q-cells", "local", []string{"00_base.sql", "local/10_contracts.sql", "default/30_default.sql", "local/40_local.sql"}},
+	}
+	for _, tt := range tests {
+		scripts, err := NewScriptExecutor(nil, nil).WithClient(tt.client).WithEnvironment(tt.env).collectScripts(context.Background(), dir)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want := make([]string, len(tt.want))
+		for i, name := range tt.want {
+			want[i] = filepath.Join(dir, name)
+		}
+		if !reflect.DeepEqual(scripts, want) {
+			t.Errorf("client %q in %q: expected %v, got %v", tt.client, tt.env, want, scripts)
+		}
+	}
+}
+
 func TestSplitStatements(t *testing.T) {
 	executor := NewScriptExecutor(nil, nil)
 	
@@ -29,3 +87,22 @@ func TestSplitStatements(t *testing.T) {
 		t.Errorf("Expected 3 statements, got %d", len(statements))
 	}
 }
+
+func TestApplyPoolSettings(t *testing.T) {
+	cfg, err := pgxpool.ParseConfig("postgres://user@localhost:5432/db")
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	applyPoolSettings(cfg, 4, 5)
+
+	if cfg.MaxConns != 4 {
+		t.Errorf("Expected MaxConns 4, got %d", cfg.MaxConns)
+	}
+	if cfg.MinIdleConns != 0 {
+		t.Errorf("Expected no idle connections kept open, got %d", cfg.MinIdleConns)
+	}
+	if cfg.MaxConnIdleTime != 5*time.Minute {
+		t.Errorf("Expected MaxConnIdleTime 5m, got %s", cfg.MaxConnIdleTime)
+	}
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+)
+
+// excerptContext is the number of script lines shown above the line of an
+// error position, or below the start of a statement without one.
+const excerptContext = 2
+
+// ScriptError reports a failed statement of a SQL script: where it is in
+// the file and what PostgreSQL reported. Error returns a single line,
+// Report a human readable block with an excerpt of the statement, and
+// MarshalZerologObject the same information as log fields.
+type ScriptError struct {
+	Script string
+	// System is the system directory of the script, Phase the scripts
+	// directory it belongs to, e.g. init or archive.
+	System string
+	Phase  string
+	// Statement is the 1-based index of the statement in the script,
+	// which spans StartLine:StartColumn to EndLine:EndColumn.
+	Statement   int
+	StartLine   int
+	StartColumn int
+	EndLine     int
+	EndColumn   int
+	// SQLState, Message, Detail and Hint are set for PostgreSQL errors.
+	SQLState string
+	Message  string
+	Detail   string
+	Hint     string
+	// Line and Column locate the error position reported by the server in
+	// the script, zero if it reported none.
+	Line   int
+	Column int
+	// Excerpt is the offending part of the statement with line numbers and
+	// a caret below the error position.
+	Excerpt string
+	Err     error
+}
+
+// newScriptError describes err of the statement at offset in script.
+func newScriptError(path, script string, index, offset int, stmt string, err error) *ScriptError {
+	e := &ScriptError{Script: path, Statement: index, Message: err.Error(), Err: err}
+	e.StartLine, e.StartColumn = lineColumn(script, offset)
+	e.EndLine, e.EndColumn = lineColumn(script, offset+max(len(stmt)-1, 0))
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		e.SQLState = pgErr.Code
+		e.Message = pgErr.Message
+		e.Detail = pgErr.Detail
+		e.Hint = pgErr.Hint
+		if pgErr.Position > 0 {
+			e.Line, e.Column = lineColumn(script, offset+runeOffset(stmt, int(pgErr.Position)-1))
+		}
+	}
+
+	if e.Line > 0 {
+		e.Excerpt = annotate(script, max(e.StartLine, e.Line-excerptContext), e.Line, e.Line, e.Column)
+	} else {
+		e.Excerpt = annotate(script, e.StartLine, min(e.EndLine, e.StartLine+excerptContext), 0, 0)
+	}
+	return e
+}
+
+func (e *ScriptError) Error() string {
+	line, column := e.StartLine, e.StartColumn
+	if e.Line > 0 {
+		line, column = e.Line, e.Column
+	}
+	return fmt.Sprintf("script %s:%d:%d: statement %d failed: %v", e.Script, line, column, e.Statement, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// Report describes the error on several lines for humans, e.g. on the
+// console of a local run:
+//
+//	script scripts/init/tripica/120_charge-data.sql (system tripica, phase init)
+//	statement 3 (lines 12-18) failed: syntax error at or near "FORM" (SQLSTATE 42601)
+//	  13 |   SELECT id
+//	  14 |     FORM charges
+//	     |     ^
+func (e *ScriptError) Report() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "script %s", e.Script)
+	if e.System != "" {
+		fmt.Fprintf(&b, " (system %s, phase %s)", e.System, e.Phase)
+	}
+	fmt.Fprintf(&b, "\nstatement %d (lines %d-%d) failed: %s", e.Statement, e.StartLine, e.EndLine, e.Message)
+	if e.SQLState != "" {
+		fmt.Fprintf(&b, " (SQLSTATE %s)", e.SQLState)
+	}
+	if e.Detail != "" {
+		fmt.Fprintf(&b, "\ndetail: %s", e.Detail)
+	}
+	if e.Hint != "" {
+		fmt.Fprintf(&b, "\nhint: %s", e.Hint)
+	}
+	if e.Excerpt != "" {
+		b.WriteString("\n")
+		b.WriteString(e.Excerpt)
+	}
+	return b.String()
+}
+
+// MarshalZerologObject adds the error as fields for JSON logs.
+func (e *ScriptError) MarshalZerologObject(event *zerolog.Event) {
+	event.Str("script", e.Script).
+		Str("system", e.System).
+		Str("phase", e.Phase).
+		Int("statement", e.Statement).
+		Int("statement_start_line", e.StartLine).
+		Int("statement_end_line", e.EndLine).
+		Str("sqlstate", e.SQLState).
+		Str("pg_message", e.Message)
+	if e.Detail != "" {
+		event.Str("pg_detail", e.Detail)
+	}
+	if e.Hint != "" {
+		event.Str("pg_hint", e.Hint)
+	}
+	if e.Line > 0 {
+		event.Int("line", e.Line).Int("column", e.Column)
+	}
+	event.Str("excerpt", e.Excerpt)
+}
+
+// lineColumn returns the 1-based line and column, counted in characters,
+// of the byte offset in text.
+func lineColumn(text string, offset int) (int, int) {
+	offset = min(offset, len(text))
+	before := text[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	return line, column
+}
+
+// runeOffset returns the byte offset of the n-th character of s, or len(s)
+// if s is shorter.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+// annotate returns the lines first to last of text prefixed with their
+// numbers, and a caret below column of line if line is not zero.
+func annotate(text string, first, last, line, column int) string {
+	lines := strings.Split(text, "\n")
+	last = min(last, len(lines))
+	width := len(fmt.Sprint(last))
+
+	var b strings.Builder
+	for n := first; n <= last; n++ {
+		fmt.Fprintf(&b, "%*d | %s\n", width, n, strings.TrimRight(lines[n-1], "\r"))
+		if n == line {
+			fmt.Fprintf(&b, "%*s | %s^\n", width, "", caretIndent(lines[n-1], column))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// caretIndent returns the whitespace before column of line, keeping tabs so
+// the caret lines up with the character above.
+func caretIndent(line string, column int) string {
+	var b strings.Builder
+	for i, r := range []rune(line) {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return b.String()
+}
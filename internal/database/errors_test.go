@@ -0,0 +1,143 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+)
+
+func TestSplitStatements_Offsets(t *testing.T) {
+	script := "-- header\nSELECT 1;\n\n  UPDATE charges\n     SET amount = 0;\n"
+
+	statements := NewScriptExecutor(nil, nil).splitStatements(script)
+
+	if len(statements) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(statements))
+	}
+	for _, stmt := range statements {
+		if got := script[stmt.Offset : stmt.Offset+len(stmt.SQL)]; got != stmt.SQL {
+			t.Errorf("Offset %d points to %q instead of %q", stmt.Offset, got, stmt.SQL)
+		}
+	}
+}
+
+func TestNewScriptError(t *testing.T) {
+	script := "SELECT 1;\n\nSELECT id,\n\tamount\n  FORM charges;\n"
+	stmt := NewScriptExecutor(nil, nil).splitStatements(script)[1]
+	pgErr := &pgconn.PgError{
+		Severity: "ERROR",
+		Code:     "42601",
+		Message:  `syntax error at or near "FORM"`,
+		Hint:     "Did you mean FROM?",
+		Position: int32(strings.Index(stmt.SQL, "FORM") + 1),
+	}
+
+	err := newScriptError("scripts/init/tripica/120_charge-data.sql", script, 2, stmt.Offset, stmt.SQL, pgErr)
+
+	if err.StartLine != 3 || err.StartColumn != 1 || err.EndLine != 5 || err.EndColumn != 14 {
+		t.Errorf("Expected statement at 3:1-5:14, got %d:%d-%d:%d", err.StartLine, err.StartColumn, err.EndLine, err.EndColumn)
+	}
+	if err.Line != 5 || err.Column != 3 {
+		t.Errorf("Expected error position 5:3, got %d:%d", err.Line, err.Column)
+	}
+	if err.SQLState != "42601" || err.Hint != "Did you mean FROM?" {
+		t.Errorf("Expected SQLSTATE and hint of the PostgreSQL error, got %+v", err)
+	}
+	if !errors.Is(err, pgErr) {
+		t.Error("Expected ScriptError to wrap the PostgreSQL error")
+	}
+
+	wantExcerpt := "3 | SELECT id,\n4 | \tamount\n5 |   FORM charges;\n  |   ^"
+	if err.Excerpt != wantExcerpt {
+		t.Errorf("Unexpected excerpt:\n%s\nwant:\n%s", err.Excerpt, wantExcerpt)
+	}
+	if !strings.HasPrefix(err.Error(), "script scripts/init/tripica/120_charge-data.sql:5:3: statement 2 failed: ") {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+	if report := err.Report(); !strings.Contains(report, "statement 2 (lines 3-5) failed: syntax error at or near \"FORM\" (SQLSTATE 42601)\nhint: Did you mean FROM?\n3 | SELECT id,") {
+		t.Errorf("Unexpected report:\n%s", report)
+	}
+}
+
+func TestNewScriptError_WithoutPosition(t *testing.T) {
+	script := "INSERT INTO charge_data\nSELECT *\n  FROM charges\n WHERE amount > 0\n   AND booked"
+
+	err := newScriptError("120_charge-data.sql", script, 1, 0, script, errors.New("conn closed"))
+
+	if err.Line != 0 || err.SQLState != "" || err.Message != "conn closed" {
+		t.Errorf("Expected error without position and SQLSTATE, got %+v", err)
+	}
+	if want := "1 | INSERT INTO charge_data\n2 | SELECT *\n3 |   FROM charges"; err.Excerpt != want {
+		t.Errorf("Expected the first lines of the statement, got:\n%s", err.Excerpt)
+	}
+}
+
+func TestScriptError_MarshalZerologObject(t *testing.T) {
+	var buf bytes.Buffer
+	scriptErr := &ScriptError{
+		Script: "scripts/init/tripica/120_charge-data.sql", System: "tripica", Phase: "init",
+		Statement: 2, StartLine: 3, EndLine: 5, SQLState: "42601", Message: "syntax error",
+		Line: 5, Column: 3, Excerpt: "5 | FORM charges\n  | ^",
+	}
+
+	logger := zerolog.New(&buf)
+	logger.Error().EmbedObject(scriptErr).Msg("Client run failed")
+
+	var fields map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatalf("Failed to parse log line: %v", err)
+	}
+	for key, want := range map[string]any{
+		"script": scriptErr.Script, "system": "tripica", "phase": "init", "statement": 2.0,
+		"sqlstate": "42601", "pg_message": "syntax error", "line": 5.0, "column": 3.0,
+	} {
+		if fields[key] != want {
+			t.Errorf("Expected %s=%v, got %v", key, want, fields[key])
+		}
+	}
+}
+
+func TestExecuteScriptsInDir_ScriptError(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	dir := filepath.Join(t.TempDir(), "init")
+	if err := os.MkdirAll(filepath.Join(dir, "tripica"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tripica", "120_charge-data.sql"), []byte("SELECT 1;\nSELECT x FORM y;"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec("SELECT 1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT x FORM y").WillReturnError(&pgconn.PgError{Code: "42601", Message: "syntax error", Position: 10})
+
+	err = NewScriptExecutor(&Connection{db: db}, nil).ExecuteScriptsInDir(context.Background(), dir)
+
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) {
+		t.Fatalf("Expected ScriptError, got %v", err)
+	}
+	if scriptErr.System != "tripica" || scriptErr.Phase != "init" || scriptErr.Statement != 2 {
+		t.Errorf("Expected statement 2 of tripica in phase init, got %+v", scriptErr)
+	}
+	if scriptErr.Line != 2 || scriptErr.Column != 10 {
+		t.Errorf("Expected error position 2:10, got %d:%d", scriptErr.Line, scriptErr.Column)
+	}
+	if n := strings.Count(err.Error(), "120_charge-data.sql"); n != 1 {
+		t.Errorf("Expected the script path once, got %q", err.Error())
+	}
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// tokenLifetime is how long RDS accepts an IAM authentication token.
+	tokenLifetime = 15 * time.Minute
+	// tokenRefresh is when a token is replaced, well before it expires.
+	tokenRefresh = 10 * time.Minute
+	// emptyPayloadHash is the SHA-256 of an empty body.
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// TokenProvider returns the password of new connections, e.g. a short-lived
+// RDS IAM authentication token instead of a static password.
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// RDSTokenProvider generates RDS IAM authentication tokens, which are valid
+// for 15 minutes. A token is reused for new connections until it is 10
+// minutes old; established connections are not affected by its expiry.
+type RDSTokenProvider struct {
+	endpoint    string
+	region      string
+	user        string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	now         func() time.Time
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+}
+
+// NewRDSTokenProvider creates a token provider for user at host:port,
+// signed with the default AWS credential chain.
+func NewRDSTokenProvider(ctx context.Context, host string, port int, region, user string) (*RDSTokenProvider, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return NewRDSTokenProviderWithCredentials(host, port, region, user, awsCfg.Credentials), nil
+}
+
+// NewRDSTokenProviderWithCredentials creates a token provider signed with
+// the given credentials.
+func NewRDSTokenProviderWithCredentials(host string, port int, region, user string, creds aws.CredentialsProvider) *RDSTokenProvider {
+	return &RDSTokenProvider{
+		endpoint:    net.JoinHostPort(host, strconv.Itoa(port)),
+		region:      region,
+		user:        user,
+		credentials: creds,
+		signer:      v4.NewSigner(),
+		now:         time.Now,
+	}
+}
+
+// Token returns the current token, generating a new one when it is due.
+func (p *RDSTokenProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if p.token != "" && now.Before(p.refreshAt) {
+		return p.token, nil
+	}
+
+	token, err := p.generate(ctx, now)
+	if err != nil {
+		return "", err
+	}
+	p.token = token
+	p.refreshAt = now.Add(tokenRefresh)
+	return token, nil
+}
+
+// generate presigns a connect request for the database user, which RDS
+// accepts as password.
+func (p *RDSTokenProvider) generate(ctx context.Context, now time.Time) (string, error) {
+	creds, err := p.credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+
+	query := url.Values{
+		"Action":        {"connect"},
+		"DBUser":        {p.user},
+		"X-Amz-Expires": {strconv.Itoa(int(tokenLifetime.Seconds()))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+p.endpoint+"/?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build auth token request: %w", err)
+	}
+
+	signed, _, err := p.signer.PresignHTTP(ctx, creds, req, emptyPayloadHash, "rds-db", p.region, now.UTC())
+	if err != nil {
+		return "", fmt.Errorf("failed to sign auth token: %w", err)
+	}
+	return strings.TrimPrefix(signed, "https://"), nil
+}
+
+// applyTokenProvider makes every new pool connection authenticate with a
+// token from tokens instead of the password of the connection string.
+func applyTokenProvider(cfg *pgxpool.Config, tokens TokenProvider) {
+	cfg.BeforeConnect = func(ctx context.Context, connCfg *pgx.ConnConfig) error {
+		token, err := tokens.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get database auth token: %w", err)
+		}
+		connCfg.Password = token
+		return nil
+	}
+}
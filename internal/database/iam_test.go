@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestRDSTokenProvider_Token(t *testing.T) {
+	now := time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)
+	provider := NewRDSTokenProviderWithCredentials("octopus.example.com", 5432, "eu-central-1", "billing_aggregator",
+		credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""))
+	provider.now = func() time.Time { return now }
+
+	token, err := provider.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(token, "octopus.example.com:5432/?") {
+		t.Errorf("Expected token for octopus.example.com:5432, got %q", token)
+	}
+	for _, want := range []string{
+		"Action=connect",
+		"DBUser=billing_aggregator",
+		"X-Amz-Expires=900",
+		"X-Amz-Credential=AKID%2F20261019%2Feu-central-1%2Frds-db%2Faws4_request",
+		"X-Amz-Signature=",
+	} {
+		if !strings.Contains(token, want) {
+			t.Errorf("Expected token to contain %s, got %q", want, token)
+		}
+	}
+
+	now = now.Add(5 * time.Minute)
+	if reused, _ := provider.Token(context.Background()); reused != token {
+		t.Error("Expected token to be reused within the refresh interval")
+	}
+
+	now = now.Add(6 * time.Minute)
+	if renewed, _ := provider.Token(context.Background()); renewed == token {
+		t.Error("Expected token to be regenerated before it expires")
+	}
+}
+
+func TestApplyTokenProvider(t *testing.T) {
+	cfg, err := pgxpool.ParseConfig("postgres://billing_aggregator@localhost:5432/octopus")
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	tokens := &fakeTokens{tokens: []string{"token-1", "token-2"}}
+	applyTokenProvider(cfg, tokens)
+
+	for _, want := range []string{"token-1", "token-2"} {
+		connCfg := cfg.ConnConfig.Copy()
+		if err := cfg.BeforeConnect(context.Background(), connCfg); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if connCfg.Password != want {
+			t.Errorf("Expected password %s for new connection, got %q", want, connCfg.Password)
+		}
+	}
+
+	tokens.err = errors.New("no credentials")
+	if err := cfg.BeforeConnect(context.Background(), cfg.ConnConfig.Copy()); err == nil || !strings.Contains(err.Error(), "no credentials") {
+		t.Errorf("Expected token error, got %v", err)
+	}
+}
+
+// fakeTokens returns the given tokens in order.
+type fakeTokens struct {
+	tokens []string
+	err    error
+}
+
+func (f *fakeTokens) Token(context.Context) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	token := f.tokens[0]
+	f.tokens = f.tokens[1:]
+	return token, nil
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LedgerTable records every run of a client, see RunLedger.
+const LedgerTable = "public.bda_run_ledger"
+
+// Run statuses in the ledger.
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// LedgerEntry is the ledger row of one client run.
+type LedgerEntry struct {
+	RunID       string
+	ClientID    string
+	Environment string
+	Version     string
+	Started     time.Time
+	Finished    time.Time
+	Status      string
+	Error       string
+}
+
+// RunLedger records the runs of each client in the client's database, so
+// runs of a multi-client job can be traced per client.
+type RunLedger struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewRunLedger creates a ledger in db.
+func NewRunLedger(db *sql.DB) *RunLedger {
+	return &RunLedger{db: db, now: time.Now}
+}
+
+// undefinedTable is the SQLSTATE of a missing table.
+const undefinedTable = "42P01"
+
+// ledgerDDL creates the ledger table. It only runs if the table is missing,
+// so roles without CREATE on public can write to a table created by an
+// administrator.
+const ledgerDDL = `CREATE TABLE IF NOT EXISTS ` + LedgerTable + ` (
+	run_id      text NOT NULL,
+	client_id   text NOT NULL,
+	environment text NOT NULL,
+	version     text NOT NULL,
+	started_at  timestamptz NOT NULL,
+	finished_at timestamptz,
+	status      text NOT NULL,
+	error       text,
+	PRIMARY KEY (run_id, client_id)
+)`
+
+// Start records entry as running, creating the ledger table if it does
+// not exist yet.
+func (l *RunLedger) Start(ctx context.Context, entry *LedgerEntry) error {
+	entry.Started = l.now()
+	entry.Status = RunRunning
+
+	err := l.insert(ctx, entry)
+	if sqlState(err) == undefinedTable {
+		if _, err := l.db.ExecContext(ctx, ledgerDDL); err != nil {
+			return fmt.Errorf("failed to create run ledger: %w", err)
+		}
+		err = l.insert(ctx, entry)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record run start: %w", err)
+	}
+	return nil
+}
+
+func (l *RunLedger) insert(ctx context.Context, entry *LedgerEntry) error {
+	_, err := l.db.ExecContext(ctx,
+		`INSERT INTO `+LedgerTable+` (run_id, client_id, environment, version, started_at, status) VALUES ($1, $2, $3, $4, $5, $6)`,
+		entry.RunID, entry.ClientID, entry.Environment, entry.Version, entry.Started, entry.Status,
+	)
+	return err
+}
+
+// Finish records the outcome of entry, failed if runErr is not nil.
+func (l *RunLedger) Finish(ctx context.Context, entry *LedgerEntry, runErr error) error {
+	entry.Finished = l.now()
+	entry.Status = RunSucceeded
+	var errText sql.NullString
+	if runErr != nil {
+		entry.Status = RunFailed
+		entry.Error = runErr.Error()
+		errText = sql.NullString{String: entry.Error, Valid: true}
+	}
+
+	if _, err := l.db.ExecContext(ctx,
+		`UPDATE `+LedgerTable+` SET finished_at = $3, status = $4, error = $5 WHERE run_id = $1 AND client_id = $2`,
+		entry.RunID, entry.ClientID, entry.Finished, entry.Status, errText,
+	); err != nil {
+		return fmt.Errorf("failed to record run outcome: %w", err)
+	}
+	return nil
+}
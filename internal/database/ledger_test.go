@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRunLedger(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	started := time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Hour)
+	mock.ExpectExec("INSERT INTO public.bda_run_ledger").
+		WithArgs("run-1", "enercity", "prod", "1.2.3", started, RunRunning).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE public.bda_run_ledger").
+		WithArgs("run-1", "enercity", finished, RunFailed, sql.NullString{String: "boom", Valid: true}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ledger := NewRunLedger(db)
+	ledger.now = func() time.Time { return started }
+	entry := &LedgerEntry{RunID: "run-1", ClientID: "enercity", Environment: "prod", Version: "1.2.3"}
+	if err := ledger.Start(context.Background(), entry); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ledger.now = func() time.Time { return finished }
+	if err := ledger.Finish(context.Background(), entry, errors.New("boom")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if entry.Status != RunFailed || entry.Error != "boom" {
+		t.Errorf("Expected failed entry with error, got %+v", entry)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunLedger_CreatesMissingTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO public.bda_run_ledger").
+		WillReturnError(&pgconn.PgError{Code: undefinedTable, Message: `relation "public.bda_run_ledger" does not exist`})
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS public.bda_run_ledger").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO public.bda_run_ledger").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	entry := &LedgerEntry{RunID: "run-1", ClientID: "enercity"}
+	if err := NewRunLedger(db).Start(context.Background(), entry); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunLedger_Succeeded(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE public.bda_run_ledger").
+		WithArgs("run-1", "q-cells", sqlmock.AnyArg(), RunSucceeded, sql.NullString{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	entry := &LedgerEntry{RunID: "run-1", ClientID: "q-cells"}
+	if err := NewRunLedger(db).Finish(context.Background(), entry, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// lockPollInterval is how often a waiting run retries the advisory lock.
+var lockPollInterval = 5 * time.Second
+
+// LockOwner identifies the run holding a run lock. It is stored in the
+// application_name of the locking session, where other runs can read it.
+type LockOwner struct {
+	RunID string
+	Host  string
+}
+
+func (o LockOwner) applicationName() string {
+	return fmt.Sprintf("bda run=%s host=%s", o.RunID, o.Host)
+}
+
+// parseLockOwner reads a LockOwner from an application_name.
+func parseLockOwner(applicationName string) LockOwner {
+	var owner LockOwner
+	for _, field := range strings.Fields(applicationName) {
+		if v, ok := strings.CutPrefix(field, "run="); ok {
+			owner.RunID = v
+		}
+		if v, ok := strings.CutPrefix(field, "host="); ok {
+			owner.Host = v
+		}
+	}
+	return owner
+}
+
+// LockHeldError is returned when another run holds the run lock.
+type LockHeldError struct {
+	Name   string
+	Holder LockOwner
+	PID    int
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("run lock %s is held by run %s on host %s (pid %d)", e.Name, e.Holder.RunID, e.Holder.Host, e.PID)
+}
+
+// RunLock is a session-level advisory lock held for the duration of a run.
+// PostgreSQL releases it when the session ends, so a crashed run never
+// leaves a stale lock behind.
+type RunLock struct {
+	ctx  context.Context
+	conn *sql.Conn
+	name string
+	key  int64
+}
+
+// AcquireRunLock takes the advisory lock name on a dedicated connection.
+// With wait 0 it fails fast with a *LockHeldError if another run holds the
+// lock, otherwise it retries until wait expires.
+func (c *Connection) AcquireRunLock(ctx context.Context, name string, owner LockOwner, wait time.Duration) (*RunLock, error) {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('application_name', $1, false)", owner.applicationName()); err != nil {
+		_ = conn.Close() // Ignore close error during error handling
+		return nil, fmt.Errorf("failed to set application_name: %w", err)
+	}
+
+	lock := &RunLock{ctx: ctx, conn: conn, name: name, key: lockKey(name)}
+	deadline := time.Now().Add(wait)
+	for {
+		err := lock.try(ctx)
+		if err == nil {
+			log.Ctx(ctx).Info().Str("lock", name).Msg("Acquired run lock")
+			return lock, nil
+		}
+
+		var held *LockHeldError
+		if !errors.As(err, &held) {
+			_ = conn.Close() // Ignore close error during error handling
+			return nil, err
+		}
+		if time.Now().Add(lockPollInterval).After(deadline) {
+			_ = conn.Close() // Ignore close error during error handling
+			if wait > 0 {
+				return nil, fmt.Errorf("gave up waiting %s for run lock: %w", wait, err)
+			}
+			return nil, err
+		}
+
+		log.Ctx(ctx).Warn().
+			Str("lock", name).
+			Str("holder_run_id", held.Holder.RunID).
+			Str("holder_host", held.Holder.Host).
+			Int("holder_pid", held.PID).
+			Dur("wait", time.Until(deadline)).
+			Msg("Run lock is held by another run, waiting")
+
+		select {
+		case <-ctx.Done():
+			_ = conn.Close() // Ignore close error during error handling
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// try takes the lock once and reports its holder if it is taken.
+func (l *RunLock) try(ctx context.Context) error {
+	var acquired bool
+	if err := l.conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		return fmt.Errorf("failed to take run lock: %w", err)
+	}
+	if acquired {
+		return nil
+	}
+
+	// pg_locks shows a bigint key as its high and low 32 bits.
+	classID := uint32(uint64(l.key) >> 32) // #nosec G115 -- intentional split of the key
+	objID := uint32(uint64(l.key))         // #nosec G115 -- intentional split of the key
+
+	held := &LockHeldError{Name: l.name}
+	var applicationName string
+	err := l.conn.QueryRowContext(ctx, `SELECT a.pid, a.application_name
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted
+		  AND l.classid = $1 AND l.objid = $2 AND l.objsubid = 1`,
+		classID, objID).Scan(&held.PID, &applicationName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Ctx(ctx).Warn().Err(err).Str("lock", l.name).Msg("Failed to look up run lock holder")
+	}
+	held.Holder = parseLockOwner(applicationName)
+	return held
+}
+
+// Release unlocks the run lock and returns its connection to the pool.
+func (l *RunLock) Release() {
+	if l == nil {
+		return
+	}
+	// The run context may already be cancelled; unlocking must still happen.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(l.ctx), cancelGrace)
+	defer cancel()
+
+	if _, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("lock", l.name).Msg("Failed to release run lock")
+	} else if _, err := l.conn.ExecContext(ctx, "RESET application_name"); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to reset application_name")
+	}
+	_ = l.conn.Close() // Closing the session releases the lock as well
+}
+
+// lockKey maps name to the bigint key of pg_try_advisory_lock.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("billing-data-aggregator:" + name))
+	return int64(h.Sum64()) // #nosec G115 -- wrap-around is fine for a hash
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAcquireRunLock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	key := lockKey("enercity/prod")
+	mock.ExpectExec("SELECT set_config").WithArgs("bda run=run-1 host=batch-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(key).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RESET application_name").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	conn := &Connection{db: db}
+	lock, err := conn.AcquireRunLock(context.Background(), "enercity/prod", LockOwner{RunID: "run-1", Host: "batch-1"}, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lock.Release()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAcquireRunLock_FailFast(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("SELECT set_config").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectLockHeld(mock)
+
+	conn := &Connection{db: db}
+	_, err = conn.AcquireRunLock(context.Background(), "enercity/prod", LockOwner{RunID: "run-2", Host: "laptop"}, 0)
+
+	var held *LockHeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("Expected LockHeldError, got %v", err)
+	}
+	if held.Holder != (LockOwner{RunID: "run-1", Host: "batch-1"}) || held.PID != 4711 {
+		t.Errorf("Unexpected holder %+v (pid %d)", held.Holder, held.PID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAcquireRunLock_Wait(t *testing.T) {
+	defer func(interval time.Duration) { lockPollInterval = interval }(lockPollInterval)
+	lockPollInterval = 10 * time.Millisecond
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("SELECT set_config").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectLockHeld(mock)
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+
+	conn := &Connection{db: db}
+	lock, err := conn.AcquireRunLock(context.Background(), "enercity/prod", LockOwner{RunID: "run-2"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lock == nil {
+		t.Fatal("Expected lock")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseLockOwner(t *testing.T) {
+	owner := parseLockOwner("bda run=20251127T093000Z-a1b2c3 host=ip-10-0-1-17")
+
+	if owner.RunID != "20251127T093000Z-a1b2c3" || owner.Host != "ip-10-0-1-17" {
+		t.Errorf("Unexpected owner %+v", owner)
+	}
+	if parseLockOwner("psql") != (LockOwner{}) {
+		t.Error("Expected empty owner for foreign application_name")
+	}
+}
+
+// Helper Functions
+
+func expectLockHeld(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(false))
+	mock.ExpectQuery("FROM pg_locks").
+		WillReturnRows(sqlmock.NewRows([]string{"pid", "application_name"}).AddRow(4711, "bda run=run-1 host=batch-1"))
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/enercity/billing-data-aggregator/internal/retry"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultStatementRetry runs a failed statement or script up to three more
+// times, see ScriptExecutor.WithRetry.
+var DefaultStatementRetry = retry.Policy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    10 * time.Second,
+}
+
+// conflict retries statements the server rolled back because of a
+// concurrent transaction: serialization failures, deadlocks and lock
+// timeouts (lock_timeout, NOWAIT). Running them again is safe.
+var conflict = retry.SQLState(retry.SerializationFailure, retry.DeadlockDetected, retry.LockNotAvailable)
+
+// sessionLost retries scripts whose connection broke. The session state,
+// e.g. temporary tables, is gone with it, so the script starts over on a
+// new connection. Only scripts run as a whole can: statements run
+// separately have committed already.
+func sessionLost(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || retry.Network(err)
+}
+
+// statementRetry retries a statement of a script run statement by
+// statement after a conflict. Scripts run as a whole are retried by
+// scriptRetry instead.
+func (e *ScriptExecutor) statementRetry(ctx context.Context, path string, index int) retry.Policy {
+	if !e.alwaysSeparate {
+		return retry.Policy{}
+	}
+	policy := e.retry
+	policy.Name = "statement"
+	policy.Retryable = conflict
+	policy.OnRetry = logRetry(ctx, path, index)
+	return policy
+}
+
+// scriptRetry runs a script run as a whole again after a conflict or a
+// broken connection: its statements form one implicit transaction, which
+// the server rolled back as a whole. Scripts run statement by statement
+// are not retried as a whole, see statementRetry.
+func (e *ScriptExecutor) scriptRetry(ctx context.Context, path string) retry.Policy {
+	if e.alwaysSeparate {
+		return retry.Policy{}
+	}
+	policy := e.retry
+	policy.Name = "script"
+	policy.Retryable = retry.Any(sessionLost, conflict)
+	policy.OnRetry = logRetry(ctx, path, 0)
+	return policy
+}
+
+// logRetry logs each retry of a script, or of its statement index if not
+// zero, with the SQLSTATE of the failure.
+func logRetry(ctx context.Context, path string, index int) func(attempt int, wait time.Duration, err error) {
+	return func(attempt int, wait time.Duration, err error) {
+		event := log.Ctx(ctx).Warn().
+			Err(err).
+			Str("script", path).
+			Str("sqlstate", sqlState(err)).
+			Int("retry", attempt).
+			Dur("wait", wait)
+		if index > 0 {
+			event.Int("statement", index).Msg("Retrying statement")
+			return
+		}
+		event.Msg("Retrying script")
+	}
+}
+
+// sqlState returns the SQLSTATE of a PostgreSQL error, or "" for other
+// errors, e.g. a broken connection.
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/enercity/billing-data-aggregator/internal/retry"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestExecuteScript_RetriesDeadlock(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	script := writeScript(t, "DELETE FROM report_oibl.bookings;\nSELECT 1;")
+
+	deadlock := &pgconn.PgError{Code: retry.DeadlockDetected, Message: "deadlock detected"}
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec("DELETE FROM report_oibl.bookings").WillReturnError(deadlock)
+	mock.ExpectExec("DELETE FROM report_oibl.bookings").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("SELECT 1").WillReturnError(&pgconn.PgError{Code: "42601", Message: "syntax error"})
+
+	policy := DefaultStatementRetry
+	policy.BaseDelay = time.Millisecond
+	executor := NewScriptExecutor(&Connection{db: db}, nil).WithRetry(policy)
+
+	err = executor.executeScript(context.Background(), script)
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "42601" {
+		t.Fatalf("Expected syntax error without retry, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecuteScript_RetriesLockTimeoutWithLimit(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	script := writeScript(t, "LOCK TABLE report_oibl.bookings NOWAIT;")
+
+	lockTimeout := &pgconn.PgError{Code: retry.LockNotAvailable, Message: "could not obtain lock"}
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec("LOCK TABLE report_oibl.bookings NOWAIT").WillReturnError(lockTimeout)
+	mock.ExpectExec("LOCK TABLE report_oibl.bookings NOWAIT").WillReturnError(lockTimeout)
+
+	executor := NewScriptExecutor(&Connection{db: db}, nil).WithRetry(retry.Policy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	err = executor.executeScript(context.Background(), script)
+	if !errors.Is(err, lockTimeout) {
+		t.Fatalf("Expected lock timeout after the last attempt, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecuteScript_FailsAfterConnectionResetBetweenStatements(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	script := writeScript(t, "DELETE FROM report_oibl.bookings;\nINSERT INTO report_oibl.bookings SELECT 1;")
+
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec("DELETE FROM report_oibl.bookings").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("INSERT INTO report_oibl.bookings SELECT 1").
+		WillReturnError(fmt.Errorf("read tcp: %w", syscall.ECONNRESET))
+
+	policy := DefaultStatementRetry
+	policy.BaseDelay = time.Millisecond
+	executor := NewScriptExecutor(&Connection{db: db}, nil).WithRetry(policy)
+
+	// The DELETE has committed, so the script must not run again.
+	err = executor.executeScript(context.Background(), script)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("Expected connection reset, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecuteScript_RerunsWholeScriptAfterConnectionReset(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	content := "CREATE TEMP TABLE charges AS SELECT 1;\nINSERT INTO charge_data SELECT * FROM charges;"
+	script := writeScript(t, content)
+
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec(content).WillReturnError(fmt.Errorf("read tcp: %w", syscall.ECONNRESET))
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(43))
+	mock.ExpectExec(content).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	policy := DefaultStatementRetry
+	policy.BaseDelay = time.Millisecond
+	executor := NewScriptExecutor(&Connection{db: db}, nil).WithRetry(policy).WithTransaction(true)
+
+	if err := executor.executeScript(context.Background(), script); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecuteScript_RerunsWholeScriptAfterDeadlock(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	content := "DELETE FROM report_oibl.bookings;\nINSERT INTO report_oibl.bookings SELECT 1;"
+	script := writeScript(t, content)
+
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec(content).WillReturnError(&pgconn.PgError{Code: retry.DeadlockDetected, Message: "deadlock detected"})
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec(content).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	policy := DefaultStatementRetry
+	policy.BaseDelay = time.Millisecond
+	executor := NewScriptExecutor(&Connection{db: db}, nil).WithRetry(policy).WithTransaction(true)
+
+	if err := executor.executeScript(context.Background(), script); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSessionLost(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("read tcp: %w", syscall.ECONNRESET), true},
+		{&pgconn.PgError{Code: "57P01"}, true},
+		{&pgconn.PgError{Code: retry.DeadlockDetected}, false},
+		{errors.New("syntax error"), false},
+	}
+
+	for _, tt := range tests {
+		if got := sessionLost(tt.err); got != tt.want {
+			t.Errorf("sessionLost(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -2,19 +2,30 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/enercity/billing-data-aggregator/internal/retry"
 	"github.com/rs/zerolog/log"
 )
 
+// defaultOverlay is the overlay directory of clients without their own.
+const defaultOverlay = "default"
+
 type ScriptExecutor struct {
 	conn           *Connection
 	ignoredSystems []string
 	alwaysSeparate bool
+	timeouts       Timeouts
+	client         string
+	environment    string
+	retry          retry.Policy
 }
 
 func NewScriptExecutor(conn *Connection, ignoredSystems []string) *ScriptExecutor {
@@ -22,27 +33,74 @@ func NewScriptExecutor(conn *Connection, ignoredSystems []string) *ScriptExecuto
 		conn:           conn,
 		ignoredSystems: ignoredSystems,
 		alwaysSeparate: true,
+		retry:          DefaultStatementRetry,
 	}
 }
 
+// WithTimeouts sets the default script and statement timeouts.
+func (e *ScriptExecutor) WithTimeouts(t Timeouts) *ScriptExecutor {
+	e.timeouts = t
+	return e
+}
+
+// WithRetry sets the attempts and backoff of statements and scripts that
+// failed on a transient error, see DefaultStatementRetry.
+func (e *ScriptExecutor) WithRetry(p retry.Policy) *ScriptExecutor {
+	e.retry = p
+	return e
+}
+
+// WithTransaction runs every script as a whole, as one implicit
+// transaction, instead of statement by statement. A failed script then
+// leaves no changes behind and is retried as a whole.
+func (e *ScriptExecutor) WithTransaction(enabled bool) *ScriptExecutor {
+	e.alwaysSeparate = !enabled
+	return e
+}
+
+// WithClient selects the client overlay of every system directory, see
+// collectScripts.
+func (e *ScriptExecutor) WithClient(client string) *ScriptExecutor {
+	e.client = client
+	return e
+}
+
+// WithEnvironment selects the environment overlay of every system
+// directory, e.g. local/, see collectScripts.
+func (e *ScriptExecutor) WithEnvironment(env string) *ScriptExecutor {
+	e.environment = env
+	return e
+}
+
 func (e *ScriptExecutor) ExecuteScriptsInDir(ctx context.Context, dir string) error {
-	log.Info().Str("directory", dir).Msg("Executing scripts in directory")
+	log.Ctx(ctx).Info().Str("directory", dir).Msg("Executing scripts in directory")
 
-	scriptsBySystem, err := e.orderScripts(dir)
+	scriptsBySystem, err := e.orderScripts(ctx, dir)
 	if err != nil {
 		return fmt.Errorf("failed to order scripts: %w", err)
 	}
 
 	for system, scripts := range scriptsBySystem {
 		if e.isSystemIgnored(system) {
-			log.Info().Str("system", system).Msg("Skipping ignored system")
+			log.Ctx(ctx).Info().Str("system", system).Msg("Skipping ignored system")
 			continue
 		}
 
-		log.Info().Str("system", system).Int("scripts", len(scripts)).Msg("Processing system")
+		log.Ctx(ctx).Info().Str("system", system).Int("scripts", len(scripts)).Msg("Processing system")
 
 		for _, script := range scripts {
 			if err := e.executeScript(ctx, script); err != nil {
+				// Script and timeout errors name the script themselves.
+				var scriptErr *ScriptError
+				if errors.As(err, &scriptErr) {
+					scriptErr.System = system
+					scriptErr.Phase = filepath.Base(dir)
+					return fmt.Errorf("failed to execute script: %w", err)
+				}
+				var timeoutErr *TimeoutError
+				if errors.As(err, &timeoutErr) {
+					return fmt.Errorf("failed to execute script: %w", err)
+				}
 				return fmt.Errorf("failed to execute script %s: %w", script, err)
 			}
 		}
@@ -51,13 +109,13 @@ func (e *ScriptExecutor) ExecuteScriptsInDir(ctx context.Context, dir string) er
 	return nil
 }
 
-func (e *ScriptExecutor) orderScripts(dir string) (map[string][]string, error) {
+func (e *ScriptExecutor) orderScripts(ctx context.Context, dir string) (map[string][]string, error) {
 	scriptsBySystem := make(map[string][]string)
 
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Warn().Str("directory", dir).Msg("Directory does not exist, skipping")
+			log.Ctx(ctx).Warn().Str("directory", dir).Msg("Directory does not exist, skipping")
 			return scriptsBySystem, nil
 		}
 		return nil, err
@@ -71,7 +129,7 @@ func (e *ScriptExecutor) orderScripts(dir string) (map[string][]string, error) {
 		system := entry.Name()
 		systemDir := filepath.Join(dir, system)
 
-		scripts, err := e.collectScripts(systemDir)
+		scripts, err := e.collectScripts(ctx, systemDir)
 		if err != nil {
 			return nil, fmt.Errorf("failed to collect scripts from %s: %w", systemDir, err)
 		}
@@ -84,40 +142,81 @@ func (e *ScriptExecutor) orderScripts(dir string) (map[string][]string, error) {
 	return scriptsBySystem, nil
 }
 
-func (e *ScriptExecutor) collectScripts(dir string) ([]string, error) {
-	var scripts []string
+// collectScripts returns the scripts of a system directory ordered by file
+// name: the scripts directly in dir, overlaid by the scripts in the
+// subdirectory named after the client, or in default/ if the client has
+// none, and then by the scripts in the subdirectory named after the
+// environment, e.g. local/. An overlay script replaces the script of the
+// same name below it; other subdirectories are not run.
+func (e *ScriptExecutor) collectScripts(ctx context.Context, dir string) ([]string, error) {
+	byName := make(map[string]string)
+	if err := addScripts(ctx, byName, dir); err != nil {
+		return nil, err
+	}
 
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	overlays := []string{filepath.Join(dir, defaultOverlay)}
+	if e.client != "" && isDir(filepath.Join(dir, e.client)) {
+		overlays[0] = filepath.Join(dir, e.client)
+	}
+	if e.environment != "" {
+		overlays = append(overlays, filepath.Join(dir, e.environment))
+	}
+	for _, overlay := range overlays {
+		if !isDir(overlay) {
+			continue
 		}
-
-		if info.IsDir() {
-			return nil
+		log.Ctx(ctx).Debug().Str("overlay", overlay).Msg("Applying overlay scripts")
+		if err := addScripts(ctx, byName, overlay); err != nil {
+			return nil, err
 		}
+	}
 
-		if strings.HasPrefix(info.Name(), "NOEXEC_") {
-			log.Debug().Str("file", path).Msg("Skipping NOEXEC file")
-			return nil
-		}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	scripts := make([]string, len(names))
+	for i, name := range names {
+		scripts[i] = byName[name]
+	}
+	return scripts, nil
+}
+
+// addScripts adds the SQL scripts directly in dir to byName.
+func addScripts(ctx context.Context, byName map[string]string, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
 
-		if strings.HasSuffix(info.Name(), ".sql") {
-			scripts = append(scripts, path)
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if entry.IsDir() {
+			continue
 		}
 
-		return nil
-	})
+		if strings.HasPrefix(name, "NOEXEC_") {
+			log.Ctx(ctx).Debug().Str("file", path).Msg("Skipping NOEXEC file")
+			continue
+		}
 
-	if err != nil {
-		return nil, err
+		if strings.HasSuffix(name, ".sql") {
+			byName[name] = path
+		}
 	}
+	return nil
+}
 
-	sort.Strings(scripts)
-	return scripts, nil
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
 }
 
 func (e *ScriptExecutor) executeScript(ctx context.Context, scriptPath string) error {
-	log.Info().Str("script", scriptPath).Msg("Executing SQL script")
+	log.Ctx(ctx).Info().Str("script", scriptPath).Msg("Executing SQL script")
 
 	// #nosec G304 -- scriptPath is sanitized and part of application SQL scripts directory
 	content, err := os.ReadFile(scriptPath)
@@ -127,55 +226,137 @@ func (e *ScriptExecutor) executeScript(ctx context.Context, scriptPath string) e
 
 	script := string(content)
 
+	timeouts, err := scriptTimeouts(script, e.timeouts)
+	if err != nil {
+		return err
+	}
+	if timeouts.Script > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeouts.Script,
+			fmt.Errorf("script timeout of %s exceeded", timeouts.Script))
+		defer cancel()
+	}
+
+	return retry.Do(ctx, e.scriptRetry(ctx, scriptPath), func(ctx context.Context) error {
+		return e.runScript(ctx, scriptPath, script, timeouts)
+	})
+}
+
+// runScript runs script on a connection of its own.
+func (e *ScriptExecutor) runScript(ctx context.Context, scriptPath, script string, timeouts Timeouts) error {
+	// All statements share one session, so statement_timeout applies to
+	// them and a timed out statement can be cancelled by its backend PID.
+	conn, err := e.conn.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer func() {
+		_ = conn.Close() // Ignore close error, the pool discards broken connections
+	}()
+
+	s := &scriptSession{
+		conn:     conn,
+		path:     scriptPath,
+		script:   script,
+		pid:      backendPID(ctx, conn),
+		timeouts: timeouts,
+	}
+	defer s.reset(ctx)
+
 	if e.alwaysSeparate {
-		return e.executeSeparateStatements(ctx, script, scriptPath)
+		return e.executeSeparateStatements(ctx, s, script)
 	}
 
-	return e.executeAsWhole(ctx, script, scriptPath)
+	return e.executeAsWhole(ctx, s, script)
+}
+
+// scriptSession is the connection a script runs on.
+type scriptSession struct {
+	conn     *sql.Conn
+	path     string
+	script   string
+	pid      int
+	timeouts Timeouts
+	// current is the statement_timeout set on the session.
+	current time.Duration
 }
 
-func (e *ScriptExecutor) executeSeparateStatements(ctx context.Context, script, scriptPath string) error {
+func (e *ScriptExecutor) executeSeparateStatements(ctx context.Context, s *scriptSession, script string) error {
 	statements := e.splitStatements(script)
 
-	log.Debug().
-		Str("script", scriptPath).
+	log.Ctx(ctx).Debug().
+		Str("script", s.path).
 		Int("statements", len(statements)).
 		Msg("Executing statements separately")
 
 	for i, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
-		if stmt == "" {
-			continue
+		if err := e.executeStatement(ctx, s, i+1, stmt); err != nil {
+			return err
 		}
+	}
 
-		if err := e.executeStatement(ctx, stmt); err != nil {
-			return fmt.Errorf("statement %d failed: %w", i+1, err)
+	return nil
+}
+
+func (e *ScriptExecutor) executeAsWhole(ctx context.Context, s *scriptSession, script string) error {
+	return e.executeStatement(ctx, s, 1, scriptStatement{SQL: script})
+}
+
+func (e *ScriptExecutor) executeStatement(ctx context.Context, s *scriptSession, index int, stmt scriptStatement) error {
+	timeout := statementTimeout(stmt.SQL, s.timeouts.Statement)
+	if timeout != s.current {
+		if err := setStatementTimeout(ctx, s.conn, timeout); err != nil {
+			return err
 		}
+		s.current = timeout
 	}
 
-	return nil
+	err := retry.Do(ctx, e.statementRetry(ctx, s.path, index), func(ctx context.Context) error {
+		_, err := s.conn.ExecContext(ctx, stmt.SQL)
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+
+	if cause := timeoutCause(ctx, err, timeout); cause != nil {
+		if ctx.Err() != nil {
+			e.conn.cancelBackend(ctx, s.pid)
+		}
+		return &TimeoutError{Script: s.path, Statement: index, SQL: excerpt(stmt.SQL), Err: cause}
+	}
+	return newScriptError(s.path, s.script, index, stmt.Offset, stmt.SQL, err)
 }
 
-func (e *ScriptExecutor) executeAsWhole(ctx context.Context, script, scriptPath string) error {
-	return e.executeStatement(ctx, script)
+// reset clears statement_timeout before the connection returns to the pool.
+func (s *scriptSession) reset(ctx context.Context) {
+	if s.current == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelGrace)
+	defer cancel()
+	if _, err := s.conn.ExecContext(ctx, "RESET statement_timeout"); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("script", s.path).Msg("Failed to reset statement_timeout")
+	}
 }
 
-func (e *ScriptExecutor) executeStatement(ctx context.Context, stmt string) error {
-	_, err := e.conn.ExecContext(ctx, stmt)
-	return err
+// scriptStatement is a statement of a script and its byte offset in the
+// script, which locates errors in the file.
+type scriptStatement struct {
+	SQL    string
+	Offset int
 }
 
-func (e *ScriptExecutor) splitStatements(script string) []string {
-	statements := strings.Split(script, ";")
-	
-	var result []string
-	for _, stmt := range statements {
-		stmt = strings.TrimSpace(stmt)
+func (e *ScriptExecutor) splitStatements(script string) []scriptStatement {
+	var result []scriptStatement
+	offset := 0
+	for _, part := range strings.Split(script, ";") {
+		stmt := strings.TrimSpace(part)
 		if stmt != "" {
-			result = append(result, stmt)
+			result = append(result, scriptStatement{SQL: stmt, Offset: offset + strings.Index(part, stmt)})
 		}
+		offset += len(part) + 1
 	}
-	
 	return result
 }
 
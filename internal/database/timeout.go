@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+// Timeouts limits how long scripts and their statements may run. Zero
+// disables a limit. Scripts override them with marker comments in their
+// header:
+//
+//	-- bda:script_timeout=2h
+//	-- bda:statement_timeout=30m
+//
+// A statement_timeout marker further down, directly above a statement, only
+// applies to that statement.
+type Timeouts struct {
+	Script    time.Duration
+	Statement time.Duration
+}
+
+// TimeoutError reports a statement that was cancelled because a job,
+// script or statement timeout expired.
+type TimeoutError struct {
+	Script    string
+	Statement int
+	SQL       string
+	Err       error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("script %s: statement %d cancelled: %v: %s", e.Script, e.Statement, e.Err, e.SQL)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// cancelGrace bounds the pg_cancel_backend call after a timeout.
+const cancelGrace = 10 * time.Second
+
+// excerptLength is the maximum length of the SQL shown in errors.
+const excerptLength = 120
+
+var timeoutMarker = regexp.MustCompile(`(?m)^\s*--\s*bda:(script|statement)_timeout\s*=\s*(\S+)\s*$`)
+
+// scriptTimeouts applies the marker comments in the header of script, the
+// comment lines before the first statement, to t.
+func scriptTimeouts(script string, t Timeouts) (Timeouts, error) {
+	for _, m := range timeoutMarker.FindAllStringSubmatch(script, -1) {
+		if d, err := time.ParseDuration(m[2]); err != nil || d < 0 {
+			return t, fmt.Errorf("invalid bda:%s_timeout %q", m[1], m[2])
+		}
+	}
+
+	for _, m := range timeoutMarker.FindAllStringSubmatch(leadingComments(script), -1) {
+		d, _ := time.ParseDuration(m[2])
+		if m[1] == "script" {
+			t.Script = d
+		} else {
+			t.Statement = d
+		}
+	}
+	return t, nil
+}
+
+// statementTimeout returns the statement_timeout marker in the comment
+// lines directly above stmt, or def. Markers after the start of stmt belong
+// to the next statement.
+func statementTimeout(stmt string, def time.Duration) time.Duration {
+	for _, m := range timeoutMarker.FindAllStringSubmatch(leadingComments(stmt), -1) {
+		if m[1] == "statement" {
+			def, _ = time.ParseDuration(m[2]) // validated by scriptTimeouts
+		}
+	}
+	return def
+}
+
+// leadingComments returns the blank and comment lines at the start of text.
+func leadingComments(text string) string {
+	var header []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+			break
+		}
+		header = append(header, line)
+	}
+	return strings.Join(header, "\n")
+}
+
+// setStatementTimeout sets statement_timeout on the session of conn.
+func setStatementTimeout(ctx context.Context, conn *sql.Conn, d time.Duration) error {
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET statement_timeout = %d", d.Milliseconds())); err != nil {
+		return fmt.Errorf("failed to set statement_timeout: %w", err)
+	}
+	return nil
+}
+
+// backendPID returns the server process ID of conn, or 0 if unknown.
+func backendPID(ctx context.Context, conn *sql.Conn) int {
+	var pid int
+	if err := conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to query backend PID, timeouts cannot cancel server-side")
+		return 0
+	}
+	return pid
+}
+
+// cancelBackend cancels the running query of the server process pid from
+// another pooled connection. The client-side cancel request of the driver
+// is not guaranteed to arrive, e.g. behind a proxy, so the statement
+// could otherwise keep running after the job gave up on it.
+func (c *Connection) cancelBackend(ctx context.Context, pid int) {
+	if pid == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cancelGrace)
+	defer cancel()
+
+	var cancelled bool
+	if err := c.db.QueryRowContext(ctx, "SELECT pg_cancel_backend($1)", pid).Scan(&cancelled); err != nil {
+		log.Ctx(ctx).Error().Err(err).Int("pid", pid).Msg("Failed to cancel backend")
+		return
+	}
+	log.Ctx(ctx).Warn().Int("pid", pid).Bool("cancelled", cancelled).Msg("Cancelled backend after timeout")
+}
+
+// timeoutCause returns why stmt was cancelled, or nil if err is not a
+// timeout or cancellation.
+func timeoutCause(ctx context.Context, err error, statementTimeout time.Duration) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "57014" && statementTimeout > 0 {
+		return fmt.Errorf("statement timeout of %s exceeded: %w", statementTimeout, err)
+	}
+	return nil
+}
+
+// excerpt drops comment lines, collapses whitespace in stmt and truncates
+// it for error messages.
+func excerpt(stmt string) string {
+	var lines []string
+	for _, line := range strings.Split(stmt, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	s := []rune(strings.Join(strings.Fields(strings.Join(lines, " ")), " "))
+	if len(s) > excerptLength {
+		return string(s[:excerptLength]) + "..."
+	}
+	return string(s)
+}
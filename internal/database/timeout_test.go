@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestScriptTimeouts(t *testing.T) {
+	script := "-- bda:script_timeout=2h\n-- bda:statement_timeout = 30m\nSELECT 1;"
+
+	timeouts, err := scriptTimeouts(script, Timeouts{Script: time.Hour, Statement: time.Minute})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if timeouts.Script != 2*time.Hour {
+		t.Errorf("Expected script timeout 2h, got %s", timeouts.Script)
+	}
+	if timeouts.Statement != 30*time.Minute {
+		t.Errorf("Expected statement timeout 30m, got %s", timeouts.Statement)
+	}
+
+	if _, err := scriptTimeouts("-- bda:script_timeout=forever\nSELECT 1;", Timeouts{}); err == nil {
+		t.Error("Expected error for invalid marker")
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	tests := []struct {
+		stmt string
+		want time.Duration
+	}{
+		{"-- bda:statement_timeout=2h\nSELECT 2", 2 * time.Hour},
+		{"-- refresh charges\n-- bda:statement_timeout=30m\n\nUPDATE charges SET amount = 0", 30 * time.Minute},
+		{"UPDATE charges SET amount = 0\n-- bda:statement_timeout=2h", time.Minute},
+		{"SELECT id\n  -- bda:statement_timeout=2h\n  FROM charges", time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := statementTimeout(tt.stmt, time.Minute); got != tt.want {
+			t.Errorf("statementTimeout(%q) = %s, want %s", tt.stmt, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteScript_MarkerAfterStatement(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// The marker follows SELECT 1 without a semicolon in between, so it
+	// ends up in the text of the first statement.
+	script := writeScript(t, "SELECT 1\n-- bda:statement_timeout=2h\n;\nSELECT 2;")
+
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec("SET statement_timeout = 600000").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT 1\n-- bda:statement_timeout=2h").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT 2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RESET statement_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	executor := NewScriptExecutor(&Connection{db: db}, nil).WithTimeouts(Timeouts{Statement: 10 * time.Minute})
+
+	if err := executor.executeScript(context.Background(), script); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	stmt := "-- bda:statement_timeout=1m\nUPDATE charges\n   SET amount = 0\n WHERE id = 1"
+
+	if got := excerpt(stmt); got != "UPDATE charges SET amount = 0 WHERE id = 1" {
+		t.Errorf("Unexpected excerpt %q", got)
+	}
+
+	long := excerpt("SELECT " + strings.Repeat("x", 200))
+	if len(long) != excerptLength+len("...") {
+		t.Errorf("Expected excerpt truncated to %d characters, got %d", excerptLength, len(long))
+	}
+}
+
+func TestExecuteScript_StatementTimeouts(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	script := writeScript(t, "SELECT 1;\n-- bda:statement_timeout=2h\nSELECT 2;\nSELECT 3;")
+
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec("SET statement_timeout = 600000").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT 1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET statement_timeout = 7200000").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("-- bda:statement_timeout=2h\nSELECT 2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SET statement_timeout = 600000").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT 3").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RESET statement_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	executor := NewScriptExecutor(&Connection{db: db}, nil).WithTimeouts(Timeouts{Statement: 10 * time.Minute})
+
+	if err := executor.executeScript(context.Background(), script); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecuteScript_ScriptTimeoutCancelsBackend(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("Failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	script := writeScript(t, "-- bda:script_timeout=50ms\nSELECT 1;\nSELECT pg_sleep(3600);")
+
+	mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pid"}).AddRow(42))
+	mock.ExpectExec("-- bda:script_timeout=50ms\nSELECT 1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT pg_sleep(3600)").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT pg_cancel_backend($1)").WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_cancel_backend"}).AddRow(true))
+
+	executor := NewScriptExecutor(&Connection{db: db}, nil)
+
+	err = executor.executeScript(context.Background(), script)
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected TimeoutError, got %v", err)
+	}
+	if timeoutErr.Statement != 2 || timeoutErr.SQL != "SELECT pg_sleep(3600)" {
+		t.Errorf("Unexpected statement %d: %q", timeoutErr.Statement, timeoutErr.SQL)
+	}
+	if !strings.Contains(err.Error(), "script timeout of 50ms exceeded") || !strings.Contains(err.Error(), script) {
+		t.Errorf("Expected error to name script and timeout, got %q", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Helper Functions
+
+func writeScript(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "120_charge-data.sql")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	return path
+}
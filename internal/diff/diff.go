@@ -0,0 +1,423 @@
+// Package diff compares the published open items balance list with the
+// version of the previous run and reports which items were added, removed
+// or changed.
+//
+// The previous version is the archive the publisher keeps of the replaced
+// report tables, see package publish. Items are matched on a key built from
+// the key columns, and the amount deltas are aggregated per combination of
+// the dimension columns:
+//
+//	key,change,changed_columns,glid_previous,glid,...,amount_gross_previous,amount_gross,delta
+//	T-1|,changed,amount_gross;dunning_level,ENERGY,ENERGY,...,100.00,80.00,-20.00
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultTable is the compared table, relative to the report schemas.
+const DefaultTable = "oibl_customer"
+
+// DefaultAmount is the column whose deltas are aggregated.
+const DefaultAmount = "amount_gross"
+
+// DefaultKey are the columns identifying an open item. Charges carry a
+// transaction ID, bookings additionally an aggregate ID.
+var DefaultKey = []string{"transaction_id", "aggregate_id"}
+
+// DefaultDimensions are the columns the deltas are aggregated by.
+var DefaultDimensions = []string{"glid", "dunning_level", "booking_mainledger_account"}
+
+// ErrNoPrevious is returned by Run when there is no previous version to
+// compare with, e.g. on the first run.
+var ErrNoPrevious = errors.New("no previous version to compare with")
+
+// DuplicateKeyError is returned by Run when item keys of a version are not
+// unique. Matching them would pair every duplicate with every other one
+// and multiply their changes.
+type DuplicateKeyError struct {
+	Table string
+	// Count is the number of duplicated keys, Keys the first of them.
+	Count int
+	Keys  []string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("%d item keys of %s are not unique, e.g. %s", e.Count, e.Table, strings.Join(e.Keys, ", "))
+}
+
+// duplicateSample limits the keys reported by a DuplicateKeyError.
+const duplicateSample = 10
+
+// Kinds of changes of an item.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Options configures a Differ. Current and Previous are schema-qualified
+// table or view names.
+type Options struct {
+	Current    string
+	Previous   string
+	Key        []string
+	Dimensions []string
+	Amount     string
+}
+
+// Differ compares two versions of a table.
+type Differ struct {
+	db   *sql.DB
+	opts Options
+}
+
+// Report summarises the differences between two versions.
+type Report struct {
+	Current  string  `json:"current"`
+	Previous string  `json:"previous"`
+	Added    int     `json:"added"`
+	Removed  int     `json:"removed"`
+	Changed  int     `json:"changed"`
+	Delta    string  `json:"delta"`
+	Groups   []Group `json:"groups"`
+}
+
+// Group aggregates the changes of one combination of dimension values.
+// Items that moved between groups count as removed from the previous one
+// and added to the current one.
+type Group struct {
+	Dimensions map[string]string `json:"dimensions"`
+	Added      int               `json:"added"`
+	Removed    int               `json:"removed"`
+	Changed    int               `json:"changed"`
+	Delta      string            `json:"delta"`
+}
+
+// group accumulates a Group.
+type group struct {
+	Group
+	values []string
+	delta  *big.Rat
+}
+
+// NewDiffer creates a Differ, filling in defaults for empty options.
+func NewDiffer(db *sql.DB, opts Options) (*Differ, error) {
+	if opts.Current == "" || opts.Previous == "" {
+		return nil, fmt.Errorf("current and previous table are required")
+	}
+	if len(opts.Key) == 0 {
+		opts.Key = DefaultKey
+	}
+	if opts.Dimensions == nil {
+		opts.Dimensions = DefaultDimensions
+	}
+	if opts.Amount == "" {
+		opts.Amount = DefaultAmount
+	}
+	return &Differ{db: db, opts: opts}, nil
+}
+
+// Run compares the current with the previous version, writes every
+// differing item as a CSV line to w and returns the summary. It returns
+// ErrNoPrevious if the previous version does not exist and a
+// DuplicateKeyError if the key does not identify the items of a version.
+func (d *Differ) Run(ctx context.Context, w io.Writer) (*Report, error) {
+	var exists bool
+	if err := d.db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", d.opts.Previous).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up %s: %w", d.opts.Previous, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", d.opts.Previous, ErrNoPrevious)
+	}
+	for _, table := range []string{d.opts.Current, d.opts.Previous} {
+		if err := d.checkUnique(ctx, table); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := d.db.QueryContext(ctx, d.query())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare %s with %s: %w", d.opts.Current, d.opts.Previous, err)
+	}
+	defer rows.Close()
+
+	out := csv.NewWriter(w)
+	if err := out.Write(d.header()); err != nil {
+		return nil, fmt.Errorf("failed to write diff: %w", err)
+	}
+
+	n := len(d.opts.Dimensions)
+	var (
+		key, columns    string
+		inPrev, inCur   bool
+		prevAmt, curAmt sql.NullString
+		prevDims        = make([]sql.NullString, n)
+		curDims         = make([]sql.NullString, n)
+		groups          = make(map[string]*group)
+		total           = new(big.Rat)
+		report          = &Report{Current: d.opts.Current, Previous: d.opts.Previous}
+	)
+	dest := []any{&key, &inPrev, &inCur, &columns, &prevAmt, &curAmt}
+	for i := range prevDims {
+		dest = append(dest, &prevDims[i], &curDims[i])
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to read diff: %w", err)
+		}
+
+		prev, err := amount(prevAmt)
+		if err != nil {
+			return nil, err
+		}
+		cur, err := amount(curAmt)
+		if err != nil {
+			return nil, err
+		}
+		delta := new(big.Rat).Sub(cur, prev)
+		total.Add(total, delta)
+
+		change := Changed
+		switch {
+		case !inPrev:
+			change = Added
+			report.Added++
+			d.group(groups, curDims).add(Added, cur)
+		case !inCur:
+			change = Removed
+			report.Removed++
+			d.group(groups, prevDims).add(Removed, new(big.Rat).Neg(prev))
+		case sameValues(prevDims, curDims):
+			report.Changed++
+			d.group(groups, curDims).add(Changed, delta)
+		default:
+			report.Changed++
+			d.group(groups, prevDims).add(Removed, new(big.Rat).Neg(prev))
+			d.group(groups, curDims).add(Added, cur)
+		}
+
+		record := []string{key, change, columns}
+		for i := range prevDims {
+			record = append(record, prevDims[i].String, curDims[i].String)
+		}
+		record = append(record, prevAmt.String, curAmt.String, delta.FloatString(scale(delta)))
+		if err := out.Write(record); err != nil {
+			return nil, fmt.Errorf("failed to write diff: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to compare %s with %s: %w", d.opts.Current, d.opts.Previous, err)
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write diff: %w", err)
+	}
+
+	report.Delta = total.FloatString(scale(total))
+	report.Groups = d.groups(groups)
+
+	log.Ctx(ctx).Info().
+		Str("current", d.opts.Current).
+		Str("previous", d.opts.Previous).
+		Int("added", report.Added).
+		Int("removed", report.Removed).
+		Int("changed", report.Changed).
+		Str("delta", report.Delta).
+		Msg("Compared open items with the previous run")
+	return report, nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// checkUnique returns a DuplicateKeyError if item keys of table occur more
+// than once.
+func (d *Differ) checkUnique(ctx context.Context, table string) error {
+	// #nosec G201 -- tables and columns come from the configuration and are quoted
+	query := fmt.Sprintf(`SELECT item_key, count(*) OVER ()
+FROM (SELECT %s AS item_key FROM %s t GROUP BY item_key HAVING count(*) > 1) d
+ORDER BY item_key LIMIT %d`, d.itemKey(), table, duplicateSample)
+
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to check the item keys of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	dup := &DuplicateKeyError{Table: table}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key, &dup.Count); err != nil {
+			return fmt.Errorf("failed to check the item keys of %s: %w", table, err)
+		}
+		dup.Keys = append(dup.Keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check the item keys of %s: %w", table, err)
+	}
+	if dup.Count > 0 {
+		return dup
+	}
+	return nil
+}
+
+// itemKey returns the expression of the key of an item t.
+func (d *Differ) itemKey() string {
+	parts := make([]string, 0, len(d.opts.Key))
+	for _, column := range d.opts.Key {
+		parts = append(parts, fmt.Sprintf("coalesce(t.%s::text, '')", quote(column)))
+	}
+	return strings.Join(parts, " || '|' || ")
+}
+
+// query selects the differing items with the previous and current values
+// of the dimension and amount columns. Rows are compared as JSON, which
+// also yields the names of the changed columns.
+func (d *Differ) query() string {
+	key := d.itemKey()
+
+	selects := []string{
+		"coalesce(cur.item_key, prev.item_key)",
+		"prev.item IS NOT NULL",
+		"cur.item IS NOT NULL",
+		`coalesce((SELECT string_agg(j.key, ';' ORDER BY j.key) FROM jsonb_each(cur.item) j
+			WHERE prev.item IS NOT NULL AND j.value IS DISTINCT FROM prev.item -> j.key), '')`,
+		fmt.Sprintf("prev.item ->> %s", literal(d.opts.Amount)),
+		fmt.Sprintf("cur.item ->> %s", literal(d.opts.Amount)),
+	}
+	for _, column := range d.opts.Dimensions {
+		selects = append(selects,
+			fmt.Sprintf("prev.item ->> %s", literal(column)),
+			fmt.Sprintf("cur.item ->> %s", literal(column)))
+	}
+
+	// #nosec G201 -- tables and columns come from the configuration and are quoted
+	return fmt.Sprintf(`WITH cur AS (
+	SELECT %[1]s AS item_key, to_jsonb(t) AS item FROM %[2]s t
+), prev AS (
+	SELECT %[1]s AS item_key, to_jsonb(t) AS item FROM %[3]s t
+)
+SELECT %[4]s
+FROM cur FULL JOIN prev ON cur.item_key = prev.item_key
+WHERE cur.item IS DISTINCT FROM prev.item
+ORDER BY 1`, key, d.opts.Current, d.opts.Previous, strings.Join(selects, ",\n\t"))
+}
+
+// header returns the CSV header of the item lines.
+func (d *Differ) header() []string {
+	header := []string{"key", "change", "changed_columns"}
+	for _, column := range d.opts.Dimensions {
+		header = append(header, column+"_previous", column)
+	}
+	return append(header, d.opts.Amount+"_previous", d.opts.Amount, "delta")
+}
+
+// group returns the accumulator of the dimension values.
+func (d *Differ) group(groups map[string]*group, dims []sql.NullString) *group {
+	values := make([]string, len(dims))
+	for i, v := range dims {
+		values[i] = v.String
+	}
+	id := strings.Join(values, "\x00")
+	if g, ok := groups[id]; ok {
+		return g
+	}
+
+	g := &group{values: values, delta: new(big.Rat)}
+	g.Dimensions = make(map[string]string, len(dims))
+	for i, column := range d.opts.Dimensions {
+		g.Dimensions[column] = values[i]
+	}
+	groups[id] = g
+	return g
+}
+
+func (g *group) add(change string, delta *big.Rat) {
+	switch change {
+	case Added:
+		g.Added++
+	case Removed:
+		g.Removed++
+	default:
+		g.Changed++
+	}
+	g.delta.Add(g.delta, delta)
+}
+
+// groups returns the groups ordered by their dimension values.
+func (d *Differ) groups(groups map[string]*group) []Group {
+	sorted := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.Join(sorted[i].values, "\x00") < strings.Join(sorted[j].values, "\x00")
+	})
+
+	result := make([]Group, 0, len(sorted))
+	for _, g := range sorted {
+		g.Delta = g.delta.FloatString(scale(g.delta))
+		result = append(result, g.Group)
+	}
+	return result
+}
+
+// amount parses a numeric value, NULL counts as zero.
+func amount(v sql.NullString) (*big.Rat, error) {
+	if !v.Valid || v.String == "" {
+		return new(big.Rat), nil
+	}
+	r, ok := new(big.Rat).SetString(v.String)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", v.String)
+	}
+	return r, nil
+}
+
+// scale returns the decimal places needed to show r exactly, at least two.
+func scale(r *big.Rat) int {
+	places := 2
+	pow := big.NewInt(100)
+	for places < 18 && new(big.Int).Mod(pow, r.Denom()).Sign() != 0 {
+		pow.Mul(pow, big.NewInt(10))
+		places++
+	}
+	return places
+}
+
+func sameValues(a, b []sql.NullString) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// quote quotes an identifier.
+func quote(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// literal quotes a string literal.
+func literal(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,195 @@
+package diff
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffer_Run(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+	expectPrevious(mock, true)
+	expectUnique(mock)
+	mock.ExpectQuery("WITH cur AS").WillReturnRows(diffRows().
+		// key, in previous, in current, changed columns, amounts, glid, dunning_level, account
+		AddRow("T-1|", false, true, "", nil, "10.00", nil, "ENERGY", nil, "1", nil, "4000").
+		AddRow("T-2|", true, false, "", "25.50", nil, "ENERGY", nil, "1", nil, "4000", nil).
+		AddRow("T-3|", true, true, "amount_gross", "100.00", "80.00", "ENERGY", "ENERGY", "1", "1", "4000", "4000").
+		AddRow("T-4|A-1", true, true, "dunning_level", "5.00", "5.00", "ENERGY", "ENERGY", "1", "2", "4000", "4000"))
+
+	differ, err := NewDiffer(db, Options{Current: "report_oibl.oibl_customer", Previous: "report_oibl__v1.oibl_customer"})
+	require.NoError(t, err)
+	var out bytes.Buffer
+
+	// Execute
+	report, err := differ.Run(context.Background(), &out)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Added)
+	assert.Equal(t, 1, report.Removed)
+	assert.Equal(t, 2, report.Changed)
+	assert.Equal(t, "-35.50", report.Delta)
+	assert.Equal(t, []Group{
+		{
+			Dimensions: map[string]string{"glid": "ENERGY", "dunning_level": "1", "booking_mainledger_account": "4000"},
+			Added:      1,
+			Removed:    2,
+			Changed:    1,
+			Delta:      "-40.50",
+		},
+		{
+			Dimensions: map[string]string{"glid": "ENERGY", "dunning_level": "2", "booking_mainledger_account": "4000"},
+			Added:      1,
+			Delta:      "5.00",
+		},
+	}, report.Groups)
+
+	assert.Equal(t, "key,change,changed_columns,"+
+		"glid_previous,glid,dunning_level_previous,dunning_level,booking_mainledger_account_previous,booking_mainledger_account,"+
+		"amount_gross_previous,amount_gross,delta\n"+
+		"T-1|,added,,,ENERGY,,1,,4000,,10.00,10.00\n"+
+		"T-2|,removed,,ENERGY,,1,,4000,,25.50,,-25.50\n"+
+		"T-3|,changed,amount_gross,ENERGY,ENERGY,1,1,4000,4000,100.00,80.00,-20.00\n"+
+		"T-4|A-1,changed,dunning_level,ENERGY,ENERGY,1,2,4000,4000,5.00,5.00,0.00\n", out.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDiffer_RunNoPrevious(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+	expectPrevious(mock, false)
+
+	differ, err := NewDiffer(db, Options{Current: "report_oibl.oibl_customer", Previous: "report_oibl__v1.oibl_customer"})
+	require.NoError(t, err)
+
+	// Execute
+	_, err = differ.Run(context.Background(), &bytes.Buffer{})
+
+	// Assert
+	assert.True(t, errors.Is(err, ErrNoPrevious), "expected ErrNoPrevious, got %v", err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDiffer_RunInvalidAmount(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+	expectPrevious(mock, true)
+	expectUnique(mock)
+	mock.ExpectQuery("WITH cur AS").WillReturnRows(diffRows().
+		AddRow("T-1|", false, true, "", nil, "n/a", nil, "ENERGY", nil, "1", nil, "4000"))
+
+	differ, err := NewDiffer(db, Options{Current: "report_oibl.oibl_customer", Previous: "report_oibl__v1.oibl_customer"})
+	require.NoError(t, err)
+
+	// Execute
+	_, err = differ.Run(context.Background(), &bytes.Buffer{})
+
+	// Assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid amount "n/a"`)
+}
+
+func TestDiffer_RunDuplicateKeys(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+	expectPrevious(mock, true)
+	mock.ExpectQuery("GROUP BY item_key HAVING count").WillReturnRows(sqlmock.NewRows([]string{"item_key", "count"}))
+	mock.ExpectQuery("GROUP BY item_key HAVING count").WillReturnRows(sqlmock.NewRows([]string{"item_key", "count"}).
+		AddRow("|A-1", 2).
+		AddRow("T-1|", 2))
+
+	differ, err := NewDiffer(db, Options{Current: "report_oibl.oibl_customer", Previous: "report_oibl__v1.oibl_customer"})
+	require.NoError(t, err)
+
+	// Execute
+	_, err = differ.Run(context.Background(), &bytes.Buffer{})
+
+	// Assert
+	var dup *DuplicateKeyError
+	require.ErrorAs(t, err, &dup)
+	assert.Equal(t, "report_oibl__v1.oibl_customer", dup.Table)
+	assert.Equal(t, 2, dup.Count)
+	assert.Equal(t, []string{"|A-1", "T-1|"}, dup.Keys)
+	assert.NoError(t, mock.ExpectationsWereMet(), "items must not be compared")
+}
+
+func TestDiffer_Query(t *testing.T) {
+	differ, err := NewDiffer(nil, Options{
+		Current:    "report_oibl.oibl_customer",
+		Previous:   "report_oibl__v1.oibl_customer",
+		Key:        []string{"transaction_id"},
+		Dimensions: []string{"glid"},
+	})
+	require.NoError(t, err)
+
+	query := differ.query()
+
+	assert.Contains(t, query, `SELECT coalesce(t."transaction_id"::text, '') AS item_key, to_jsonb(t) AS item FROM report_oibl.oibl_customer t`)
+	assert.Contains(t, query, `FROM report_oibl__v1.oibl_customer t`)
+	assert.Contains(t, query, `prev.item ->> 'amount_gross'`)
+	assert.Contains(t, query, `cur.item ->> 'glid'`)
+	assert.Contains(t, query, "WHERE cur.item IS DISTINCT FROM prev.item")
+}
+
+func TestNewDiffer_RequiresTables(t *testing.T) {
+	_, err := NewDiffer(nil, Options{Current: "report_oibl.oibl_customer"})
+
+	assert.Error(t, err)
+}
+
+func TestReport_WriteJSON(t *testing.T) {
+	// Setup
+	report := &Report{Current: "a", Previous: "b", Added: 1, Delta: "1.00", Groups: []Group{}}
+	var out bytes.Buffer
+
+	// Execute
+	err := report.WriteJSON(&out)
+
+	// Assert
+	require.NoError(t, err)
+	var decoded Report
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, *report, decoded)
+}
+
+// Helper Functions
+
+func newMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db, mock
+}
+
+func expectPrevious(mock sqlmock.Sqlmock, exists bool) {
+	mock.ExpectQuery("SELECT to_regclass").
+		WithArgs("report_oibl__v1.oibl_customer").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+func expectUnique(mock sqlmock.Sqlmock) {
+	for range 2 {
+		mock.ExpectQuery("GROUP BY item_key HAVING count").WillReturnRows(sqlmock.NewRows([]string{"item_key", "count"}))
+	}
+}
+
+func diffRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"key", "in_previous", "in_current", "changed_columns",
+		"amount_previous", "amount",
+		"glid_previous", "glid",
+		"dunning_level_previous", "dunning_level",
+		"account_previous", "account",
+	})
+}
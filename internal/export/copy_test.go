@@ -0,0 +1,250 @@
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/enercity/billing-data-aggregator/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVExporter_ExportTableCopy(t *testing.T) {
+	// Setup
+	src := &fakeCopySource{
+		data: "id,name\n1,Alice\n2,\"Bob\nSmith\"\n3,\"say \"\"hi\"\"\"\n4,\n5,Eve\n",
+		rows: 5,
+		step: 3,
+	}
+	tmpDir := t.TempDir()
+	exporter := NewCSVExporter(nil, tmpDir, 2).WithCopy(src)
+
+	// Execute
+	files, err := exporter.ExportTable(context.Background(), "report_oibl.oibl_customer", "tripica")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "COPY (SELECT * FROM report_oibl.oibl_customer) TO STDOUT WITH (FORMAT csv, HEADER)", src.sql)
+	require.Len(t, files, 3)
+	assert.Equal(t, []int64{2, 2, 1}, []int64{files[0].Rows, files[1].Rows, files[2].Rows})
+	assert.Equal(t, "id,name\n1,Alice\n2,\"Bob\nSmith\"\n", readFile(t, files[0].Path))
+	assert.Equal(t, "id,name\n3,\"say \"\"hi\"\"\"\n4,\n", readFile(t, files[1].Path))
+	assert.Equal(t, "id,name\n5,Eve\n", readFile(t, files[2].Path))
+	assert.Equal(t, filepath.Join(tmpDir, "tripica_report_oibl.oibl_customer_0002.csv"), files[2].Path)
+}
+
+func TestCSVExporter_ExportTableCopyGzip(t *testing.T) {
+	// Setup
+	src := &fakeCopySource{data: "id\n1\n2\n", rows: 2}
+	exporter, err := NewCSVExporter(nil, t.TempDir(), 10).WithCopy(src).WithCompression(CompressionGzip)
+	require.NoError(t, err)
+
+	// Execute
+	files, err := exporter.ExportTable(context.Background(), "tripica_results", "tripica")
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0].Path, ".csv.gz"))
+
+	f, err := os.Open(files[0].Path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "id\n1\n2\n", string(content))
+}
+
+func TestCSVExporter_ExportTableCopyEmpty(t *testing.T) {
+	exporter := NewCSVExporter(nil, t.TempDir(), 10).WithCopy(&fakeCopySource{data: "id,name\n"})
+
+	files, err := exporter.ExportTable(context.Background(), "tripica_results", "tripica")
+
+	require.NoError(t, err)
+	assert.Empty(t, files, "an empty table should not produce files, like the scan path")
+}
+
+func TestCSVExporter_ExportTableCopyRowMismatch(t *testing.T) {
+	exporter := NewCSVExporter(nil, t.TempDir(), 10).WithCopy(&fakeCopySource{data: "id\n1\n", rows: 2})
+
+	_, err := exporter.ExportTable(context.Background(), "tripica_results", "tripica")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "COPY returned 2")
+}
+
+func TestCSVExporter_StreamTableCopyAbortsOnError(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	src := &fakeCopySource{data: "id\n1\n2\n3\n", err: errors.New("connection reset")}
+	exporter := NewCSVExporter(nil, "", 2).WithCopy(src)
+
+	// Execute
+	_, err := exporter.StreamTable(context.Background(), "tripica_results", "tripica", NewLocalSink(dir))
+
+	// Assert
+	require.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "only the completed first chunk should be left")
+	assert.Equal(t, "tripica_tripica_results_0000.csv", entries[0].Name())
+}
+
+// The scan and copy benchmarks measure the client-side CPU of both paths
+// on the same data, without disk I/O:
+//
+//	go test ./internal/export/ -run '^$' -bench 'CSVExporter_(Scan|Copy)' -benchmem
+func BenchmarkCSVExporter_Scan(b *testing.B) {
+	const rowCount = 100000
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, mock, err := sqlmock.New()
+		require.NoError(b, err)
+		rows := sqlmock.NewRows(benchmarkColumns)
+		for r := 0; r < rowCount; r++ {
+			rows.AddRow(benchmarkRow(r)...)
+		}
+		mock.ExpectQuery("SELECT").WillReturnRows(rows)
+		exporter := NewCSVExporter(db, "", rowCount/4)
+		b.StartTimer()
+
+		_, err = exporter.StreamTable(context.Background(), "oibl_tripica", "tripica", discardSink{})
+		require.NoError(b, err)
+
+		b.StopTimer()
+		_ = db.Close()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkCSVExporter_Copy(b *testing.B) {
+	const rowCount = 100000
+	b.ReportAllocs()
+
+	var data strings.Builder
+	data.WriteString(strings.Join(benchmarkColumns, ",") + "\n")
+	for r := 0; r < rowCount; r++ {
+		row := benchmarkRow(r)
+		fmt.Fprintf(&data, "%s,%s,%s,%s,%s,%d\n", row[0], row[1], row[2].(time.Time).Format("2006-01-02 15:04:05-07"), row[3], row[4], row[5])
+	}
+	src := &fakeCopySource{data: data.String(), rows: rowCount}
+	exporter := NewCSVExporter(nil, "", rowCount/4).WithCopy(src)
+	b.SetBytes(int64(data.Len()))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := exporter.StreamTable(context.Background(), "oibl_tripica", "tripica", discardSink{})
+		require.NoError(b, err)
+	}
+}
+
+// BenchmarkCSVExporter_Postgres compares both paths end to end against a
+// real database, see TestPostgresSink_Integration for how to start one.
+func BenchmarkCSVExporter_Postgres(b *testing.B) {
+	dsn := os.Getenv("BDA_TEST_TARGET_DSN")
+	if dsn == "" {
+		b.Skip("Requires database (set BDA_TEST_TARGET_DSN)")
+	}
+
+	ctx := context.Background()
+	conn, err := database.NewConnection(context.Background(), dsn, nil, 4, 5)
+	require.NoError(b, err)
+	defer conn.Close()
+	db := conn.DB()
+
+	_, err = db.ExecContext(ctx, `DROP TABLE IF EXISTS bda_bench;
+		CREATE TABLE bda_bench AS
+		SELECT 'C-' || g AS customer_id, (g * 1.17)::numeric(12,2) AS amount_gross,
+		       now() - g * interval '1 minute' AS created_at, 'open' AS status,
+		       'tripica' AS source, g % 4 AS dunning_level
+		FROM generate_series(1, 1000000) g`)
+	require.NoError(b, err)
+	defer func() { _, _ = db.Exec(`DROP TABLE IF EXISTS bda_bench`) }()
+
+	for name, exporter := range map[string]*CSVExporter{
+		"scan": NewCSVExporter(db, "", 250000),
+		"copy": NewCSVExporter(db, "", 250000).WithCopy(conn),
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := exporter.StreamTable(ctx, "bda_bench", "bench", discardSink{})
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+// Helper Functions
+
+var benchmarkColumns = []string{"customer_id", "amount_gross", "created_at", "status", "source", "dunning_level"}
+
+func benchmarkRow(r int) []driver.Value {
+	return []driver.Value{
+		fmt.Sprintf("C-%d", r),
+		fmt.Sprintf("%d.%02d", r, r%100),
+		time.Date(2025, 11, 27, 8, 30, 0, 0, time.UTC).Add(-time.Duration(r) * time.Minute),
+		"open",
+		"tripica",
+		int64(r % 4),
+	}
+}
+
+// fakeCopySource writes data in steps of step bytes and then returns rows
+// and err.
+type fakeCopySource struct {
+	data     string
+	rows     int64
+	step     int
+	err      error
+	sql      string
+	snapshot string
+}
+
+func (f *fakeCopySource) CopyToSnapshot(ctx context.Context, w io.Writer, sql, snapshot string) (int64, error) {
+	f.snapshot = snapshot
+	return f.CopyTo(ctx, w, sql)
+}
+
+func (f *fakeCopySource) CopyTo(_ context.Context, w io.Writer, sql string) (int64, error) {
+	f.sql = sql
+	step := f.step
+	if step == 0 {
+		step = len(f.data)
+	}
+	for start := 0; start < len(f.data); start += step {
+		end := min(start+step, len(f.data))
+		if _, err := w.Write([]byte(f.data[start:end])); err != nil {
+			return 0, err
+		}
+	}
+	return f.rows, f.err
+}
+
+type discardSink struct{}
+
+func (discardSink) Put(_ context.Context, _ string, body io.Reader, _ ObjectMeta) error {
+	_, err := io.Copy(io.Discard, body)
+	return err
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
@@ -1,10 +1,12 @@
 package export
 
 import (
+	"compress/gzip"
 	"context"
 	"database/sql"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -15,6 +17,10 @@ type CSVExporter struct {
 	db             *sql.DB
 	outputDir      string
 	maxRowsPerFile int
+	compression    string
+	copy           CopySource
+	reconcile      bool
+	sumColumns     []string
 }
 
 func NewCSVExporter(db *sql.DB, outputDir string, maxRowsPerFile int) *CSVExporter {
@@ -25,18 +31,83 @@ func NewCSVExporter(db *sql.DB, outputDir string, maxRowsPerFile int) *CSVExport
 	}
 }
 
-func (e *CSVExporter) ExportTable(ctx context.Context, tableName, system string) ([]string, error) {
-	log.Info().Str("table", tableName).Str("system", system).Msg("Exporting table to CSV")
+// WithCompression enables compression of the written chunks. Supported
+// values are "gzip" and "none".
+func (e *CSVExporter) WithCompression(compression string) (*CSVExporter, error) {
+	switch compression {
+	case "", CompressionNone:
+		e.compression = ""
+	case CompressionGzip:
+		e.compression = CompressionGzip
+	default:
+		return nil, fmt.Errorf("unsupported CSV compression %q", compression)
+	}
+	return e, nil
+}
+
+func (e *CSVExporter) ExportTable(ctx context.Context, tableName, system string) ([]File, error) {
+	log.Ctx(ctx).Info().Str("table", tableName).Str("system", system).Msg("Exporting table to CSV")
+
+	// Create output directory with restricted permissions (owner + group)
+	if err := os.MkdirAll(e.outputDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
 
+	return e.export(ctx, tableName, system, func(name string) (chunkWriter, string, error) {
+		filePath := filepath.Join(e.outputDir, name)
+		// #nosec G304 -- filePath is internally generated, not from user input
+		file, err := os.Create(filePath)
+		if err != nil {
+			return nil, filePath, fmt.Errorf("failed to create file: %w", err)
+		}
+		return fileChunk{file}, filePath, nil
+	})
+}
+
+// StreamTable exports the table like ExportTable, but streams every chunk
+// straight into dst instead of writing it to the local disk. The Path of
+// the returned files is the name of the streamed object.
+func (e *CSVExporter) StreamTable(ctx context.Context, tableName, system string, dst Sink) ([]File, error) {
+	log.Ctx(ctx).Info().Str("table", tableName).Str("system", system).Msg("Streaming table to CSV")
+
+	meta := ObjectMeta{System: system, Table: tableName}
+	return e.export(ctx, tableName, system, func(name string) (chunkWriter, string, error) {
+		return newStreamChunk(ctx, dst, name, meta), name, nil
+	})
+}
+
+func (e *CSVExporter) export(ctx context.Context, tableName, system string, open chunkOpener) ([]File, error) {
+	if !e.reconcile {
+		if e.copy != nil {
+			return e.exportCopy(ctx, tableName, system, open, nil, "")
+		}
+		return e.exportRows(ctx, e.db, tableName, system, open, nil)
+	}
+
+	return reconciled(ctx, e.db, tableName, e.sumColumns, func(tx *sql.Tx, sums *columnSums) ([]File, error) {
+		if e.copy != nil {
+			snapshot, err := exportSnapshot(ctx, tx)
+			if err != nil {
+				return nil, err
+			}
+			return e.exportCopy(ctx, tableName, system, open, sums, snapshot)
+		}
+		return e.exportRows(ctx, tx, tableName, system, open, sums)
+	})
+}
+
+// exportRows writes the rows of the table into chunks, adding up the
+// columns of sums if set.
+func (e *CSVExporter) exportRows(ctx context.Context, q queryer, tableName, system string, open chunkOpener, sums *columnSums) ([]File, error) {
 	// #nosec G201 -- tableName is validated and schema-qualified, not user input
 	query := fmt.Sprintf("SELECT * FROM %s", tableName)
-	rows, err := e.db.QueryContext(ctx, query)
+	rows, err := q.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query table %s: %w", tableName, err)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
-			log.Error().Err(err).Msg("Failed to close rows")
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to close rows")
 		}
 	}()
 
@@ -44,41 +115,47 @@ func (e *CSVExporter) ExportTable(ctx context.Context, tableName, system string)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get columns: %w", err)
 	}
-
-	// Create output directory with restricted permissions (owner + group)
-	if err := os.MkdirAll(e.outputDir, 0750); err != nil {
-		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	if sums != nil {
+		if err := sums.header(columns); err != nil {
+			return nil, err
+		}
 	}
 
-	var files []string
+	ext := e.extension()
+
+	var files []File
 	fileIndex := 0
 	rowCount := 0
-	var writer *csv.Writer
-	var currentFile *os.File
+	var current *csvChunk
+
+	// fail discards the chunk in progress so a partial object never lands.
+	fail := func(err error) ([]File, error) {
+		if current != nil {
+			current.abort(err)
+		}
+		return files, err
+	}
 
 	for rows.Next() {
 		if rowCount%e.maxRowsPerFile == 0 {
-			if currentFile != nil {
-				writer.Flush()
-				if err := currentFile.Close(); err != nil {
-					log.Warn().Err(err).Msg("Failed to close CSV file")
+			if current != nil {
+				if err := current.close(); err != nil {
+					current = nil
+					return fail(fmt.Errorf("failed to finish chunk: %w", err))
 				}
+				files[len(files)-1].Bytes = current.written()
 			}
 
-			filename := fmt.Sprintf("%s_%s_%04d.csv", system, tableName, fileIndex)
-			filePath := filepath.Join(e.outputDir, filename)
-			files = append(files, filePath)
-
-			// #nosec G304 -- filePath is internally generated, not from user input
-			currentFile, err = os.Create(filePath)
+			dst, location, err := open(chunkFileName(system, tableName, fileIndex, ext))
+			files = append(files, File{Path: location, System: system, Table: tableName})
 			if err != nil {
-				return files, fmt.Errorf("failed to create file: %w", err)
+				current = nil
+				return fail(err)
 			}
 
-			writer = csv.NewWriter(currentFile)
-			if err := writer.Write(columns); err != nil {
-				_ = currentFile.Close() // Ignore close error during error handling
-				return files, fmt.Errorf("failed to write headers: %w", err)
+			current = newCSVChunk(dst, e.compression)
+			if err := current.csv.Write(columns); err != nil {
+				return fail(fmt.Errorf("failed to write headers: %w", err))
 			}
 
 			fileIndex++
@@ -92,7 +169,7 @@ func (e *CSVExporter) ExportTable(ctx context.Context, tableName, system string)
 		}
 
 		if err := rows.Scan(valuePtrs...); err != nil {
-			return files, fmt.Errorf("failed to scan row: %w", err)
+			return fail(fmt.Errorf("failed to scan row: %w", err))
 		}
 
 		strValues := make([]string, len(values))
@@ -104,24 +181,103 @@ func (e *CSVExporter) ExportTable(ctx context.Context, tableName, system string)
 			}
 		}
 
-		if err := writer.Write(strValues); err != nil {
-			return files, fmt.Errorf("failed to write row: %w", err)
+		if err := current.csv.Write(strValues); err != nil {
+			return fail(fmt.Errorf("failed to write row: %w", err))
+		}
+		if sums != nil {
+			if err := sums.add(strValues); err != nil {
+				return fail(fmt.Errorf("failed to sum row: %w", err))
+			}
 		}
 
 		rowCount++
+		files[len(files)-1].Rows++
 	}
 
-	if currentFile != nil {
-		writer.Flush()
-		if err := currentFile.Close(); err != nil {
-			log.Warn().Err(err).Msg("Failed to close final CSV file")
-		}
+	if err := rows.Err(); err != nil {
+		return fail(fmt.Errorf("error iterating rows: %w", err))
 	}
 
-	if err := rows.Err(); err != nil {
-		return files, fmt.Errorf("error iterating rows: %w", err)
+	if current != nil {
+		if err := current.close(); err != nil {
+			return files, fmt.Errorf("failed to finish final chunk: %w", err)
+		}
+		files[len(files)-1].Bytes = current.written()
 	}
 
-	log.Info().Str("table", tableName).Int("total_files", len(files)).Msg("Export completed")
+	log.Ctx(ctx).Info().Str("table", tableName).Int("total_files", len(files)).Msg("Export completed")
 	return files, nil
 }
+
+func (e *CSVExporter) extension() string {
+	if e.compression == CompressionGzip {
+		return FormatCSV + ".gz"
+	}
+	return FormatCSV
+}
+
+// chunkOpener opens the destination of the named chunk and returns where
+// it ends up, e.g. the local path.
+type chunkOpener func(name string) (chunkWriter, string, error)
+
+// chunkWriter is the destination of a single export chunk. Close commits
+// the chunk, Abort discards it.
+type chunkWriter interface {
+	io.Writer
+	Close() error
+	Abort(err error)
+}
+
+type fileChunk struct {
+	*os.File
+}
+
+func (f fileChunk) Abort(error) {
+	_ = f.File.Close() // Ignore close error during error handling
+}
+
+// csvChunk layers the CSV writer and optional compression on a chunk. Raw
+// takes already encoded CSV.
+type csvChunk struct {
+	dst     chunkWriter
+	counter *countingWriter
+	gzip    *gzip.Writer
+	csv     *csv.Writer
+	raw     io.Writer
+}
+
+func newCSVChunk(dst chunkWriter, compression string) *csvChunk {
+	counter := &countingWriter{w: dst}
+	c := &csvChunk{dst: dst, counter: counter, raw: counter}
+	if compression == CompressionGzip {
+		c.gzip = gzip.NewWriter(counter)
+		c.raw = c.gzip
+	}
+	c.csv = csv.NewWriter(c.raw)
+	return c
+}
+
+// written returns the bytes written to the destination, i.e. the size of
+// the chunk after compression.
+func (c *csvChunk) written() int64 {
+	return c.counter.n
+}
+
+func (c *csvChunk) close() error {
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		c.dst.Abort(err)
+		return err
+	}
+	if c.gzip != nil {
+		if err := c.gzip.Close(); err != nil {
+			c.dst.Abort(err)
+			return err
+		}
+	}
+	return c.dst.Close()
+}
+
+func (c *csvChunk) abort(err error) {
+	c.dst.Abort(err)
+}
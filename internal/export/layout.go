@@ -0,0 +1,239 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/enercity/billing-data-aggregator/internal/retry"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultKeyTemplate is the historic layout in which every run overwrites
+// the objects of the previous one.
+const DefaultKeyTemplate = "{client}/{env}/{file}"
+
+// SuccessMarker is written into every directory of a run once all of its
+// objects were uploaded.
+const SuccessMarker = "_SUCCESS"
+
+// LatestName is the object name of the pointer to the last complete run
+// below the prefix.
+const LatestName = "latest.json"
+
+// keyPlaceholders are the placeholders a key template may use.
+var keyPlaceholders = []string{"{client}", "{env}", "{system}", "{table}", "{date}", "{run_id}", "{file}"}
+
+var placeholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// chunkPattern matches the names of exported chunks.
+var chunkPattern = regexp.MustCompile(`_\d{4}\.(csv|csv\.gz|parquet)$`)
+
+// Latest points to the last complete run.
+type Latest struct {
+	RunID       string    `json:"run_id"`
+	Date        string    `json:"date,omitempty"`
+	Manifest    string    `json:"manifest,omitempty"`
+	Prefixes    []string  `json:"prefixes"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// WithKeyTemplate lays out the uploaded objects according to tmpl, e.g.
+// "{client}/{env}/{system}/dt={date}/run={run_id}/{file}". Placeholders
+// that are empty for an object, like {system} for the manifest, are dropped
+// together with their path segment separator. Without a template objects
+// are written directly below the prefix.
+func (u *S3Uploader) WithKeyTemplate(tmpl string) (*S3Uploader, error) {
+	if err := validateKeyTemplate(tmpl); err != nil {
+		return nil, err
+	}
+	u.keyTemplate = tmpl
+	return u, nil
+}
+
+func validateKeyTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	if !strings.Contains(tmpl, "{file}") {
+		return fmt.Errorf("key template %q must contain {file}", tmpl)
+	}
+	for _, p := range placeholderPattern.FindAllString(tmpl, -1) {
+		known := false
+		for _, k := range keyPlaceholders {
+			known = known || p == k
+		}
+		if !known {
+			return fmt.Errorf("key template %q has unknown placeholder %s", tmpl, p)
+		}
+	}
+	return nil
+}
+
+// objectKey returns the key of the object name.
+func (u *S3Uploader) objectKey(name string, meta ObjectMeta) string {
+	if u.keyTemplate == "" {
+		return path.Join(u.prefix, name)
+	}
+
+	key := strings.NewReplacer(
+		"{client}", u.run.ClientID,
+		"{env}", u.run.Environment,
+		"{system}", meta.System,
+		"{table}", meta.Table,
+		"{date}", u.run.date(),
+		"{run_id}", u.run.ID,
+		"{file}", name,
+	).Replace(u.keyTemplate)
+
+	var segments []string
+	for _, s := range strings.Split(key, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func (r RunInfo) date() string {
+	if r.Started.IsZero() {
+		return ""
+	}
+	return r.Started.Format("2006-01-02")
+}
+
+// Publish marks the run as complete. It writes a _SUCCESS marker into every
+// directory the run wrote to and then replaces latest.json below the prefix,
+// so readers following the pointer never see a partial run. Publish must
+// only be called after every export and the manifest were uploaded.
+func (u *S3Uploader) Publish(ctx context.Context) error {
+	prefixes := u.runPrefixes()
+	for _, prefix := range prefixes {
+		key := path.Join(prefix, SuccessMarker)
+		attrs := u.attributes(key, ObjectMeta{})
+		if err := u.putBytes(ctx, key, nil, attrs); err != nil {
+			return fmt.Errorf("failed to write success marker %s: %w", key, err)
+		}
+	}
+
+	u.mu.Lock()
+	manifestKey := u.manifestKey
+	u.mu.Unlock()
+
+	body, err := json.MarshalIndent(Latest{
+		RunID:       u.run.ID,
+		Date:        u.run.date(),
+		Manifest:    manifestKey,
+		Prefixes:    prefixes,
+		CompletedAt: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode latest pointer: %w", err)
+	}
+
+	key := path.Join(u.prefix, LatestName)
+	attrs := u.attributes(key, ObjectMeta{})
+	if err := u.putBytes(ctx, key, body, attrs); err != nil {
+		return fmt.Errorf("failed to write latest pointer: %w", err)
+	}
+
+	log.Ctx(ctx).Info().
+		Str("bucket", u.bucket).
+		Str("key", key).
+		Strs("prefixes", prefixes).
+		Msg("Run published")
+	return nil
+}
+
+// runPrefixes returns the directories the run wrote to.
+func (u *S3Uploader) runPrefixes() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	seen := map[string]bool{}
+	var prefixes []string
+	add := func(key string) {
+		if dir := path.Dir(key); !seen[dir] {
+			seen[dir] = true
+			prefixes = append(prefixes, dir)
+		}
+	}
+	for _, obj := range u.objects {
+		add(obj.Key)
+	}
+	if u.manifestKey != "" {
+		add(u.manifestKey)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// CleanupStale deletes chunks, manifests and success markers directly below
+// the prefix that were not written by this run. These are left over from
+// runs that used the fixed layout, e.g. the sixth chunk of a table that only
+// has five chunks today.
+func (u *S3Uploader) CleanupStale(ctx context.Context) (int, error) {
+	keep := map[string]bool{}
+	for _, obj := range u.Objects() {
+		keep[obj.Key] = true
+	}
+	u.mu.Lock()
+	keep[u.manifestKey] = true
+	u.mu.Unlock()
+	for _, prefix := range u.runPrefixes() {
+		keep[path.Join(prefix, SuccessMarker)] = true
+	}
+
+	var stale []types.ObjectIdentifier
+	paginator := s3.NewListObjectsV2Paginator(u.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(u.bucket),
+		Prefix:    aws.String(u.prefix + "/"),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list %s: %w", u.prefix, err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			name := path.Base(key)
+			if keep[key] || !(chunkPattern.MatchString(name) || name == ManifestName || name == SuccessMarker) {
+				continue
+			}
+			stale = append(stale, types.ObjectIdentifier{Key: obj.Key})
+		}
+	}
+
+	// DeleteObjects accepts at most 1000 keys per request.
+	for start := 0; start < len(stale); start += 1000 {
+		end := min(start+1000, len(stale))
+		var out *s3.DeleteObjectsOutput
+		err := retry.Do(ctx, u.retryPolicy("S3 delete"), func(ctx context.Context) error {
+			var err error
+			out, err = u.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(u.bucket),
+				Delete: &types.Delete{Objects: stale[start:end], Quiet: aws.Bool(true)},
+			}, withoutSDKRetries)
+			return err
+		})
+		if err != nil {
+			return start, fmt.Errorf("failed to delete stale objects: %w", err)
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return start, fmt.Errorf("failed to delete stale object %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+	}
+
+	log.Ctx(ctx).Info().Str("bucket", u.bucket).Str("prefix", u.prefix).Int("deleted", len(stale)).Msg("Stale objects cleaned up")
+	return len(stale), nil
+}
@@ -0,0 +1,210 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const runTemplate = "{client}/{env}/{system}/dt={date}/run={run_id}/{file}"
+
+func TestS3Uploader_ObjectKey(t *testing.T) {
+	run := RunInfo{
+		ID:          "20251127T013000Z-a1b2c3",
+		ClientID:    "enercity",
+		Environment: "prod",
+		Started:     time.Date(2025, 11, 27, 2, 30, 0, 0, time.UTC),
+	}
+	meta := ObjectMeta{System: "tripica", Table: "tripica_results"}
+
+	tests := []struct {
+		name     string
+		template string
+		file     string
+		meta     ObjectMeta
+		expected string
+	}{
+		{"no template", "", "tripica_results_0000.csv", meta, "enercity/prod/tripica_results_0000.csv"},
+		{"default template", DefaultKeyTemplate, "tripica_results_0000.csv", meta, "enercity/prod/tripica_results_0000.csv"},
+		{"run template", runTemplate, "tripica_results_0000.csv", meta, "enercity/prod/tripica/dt=2025-11-27/run=20251127T013000Z-a1b2c3/tripica_results_0000.csv"},
+		{"run template without system", runTemplate, ManifestName, ObjectMeta{}, "enercity/prod/dt=2025-11-27/run=20251127T013000Z-a1b2c3/manifest.json"},
+		{"table placeholder", "{client}/{table}/{file}", "x.csv", meta, "enercity/tripica_results/x.csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploader, err := newS3UploaderWithClient(nil, "exports", "enercity/prod").WithKeyTemplate(tt.template)
+			require.NoError(t, err)
+			uploader.WithRun(run)
+
+			assert.Equal(t, tt.expected, uploader.objectKey(tt.file, tt.meta))
+		})
+	}
+}
+
+func TestValidateKeyTemplate(t *testing.T) {
+	assert.NoError(t, validateKeyTemplate(""))
+	assert.NoError(t, validateKeyTemplate(runTemplate))
+	assert.Error(t, validateKeyTemplate("{client}/{env}"), "{file} is required")
+	assert.Error(t, validateKeyTemplate("{client}/{day}/{file}"), "unknown placeholders are rejected")
+}
+
+func TestS3Uploader_Publish(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	uploader, err := newS3UploaderWithClient(client, "exports", "enercity/prod").WithKeyTemplate(runTemplate)
+	require.NoError(t, err)
+	uploader.WithRun(RunInfo{
+		ID:          "run-1",
+		ClientID:    "enercity",
+		Environment: "prod",
+		Started:     time.Date(2025, 11, 27, 2, 30, 0, 0, time.UTC),
+	})
+
+	ctx := context.Background()
+	for _, system := range []string{"tripica", "bookkeeper"} {
+		err := uploader.Put(ctx, system+"_results_0000.csv", bytes.NewReader([]byte("id\n1\n")), ObjectMeta{System: system})
+		require.NoError(t, err)
+	}
+	require.NoError(t, uploader.UploadManifest(ctx, uploader.Manifest(nil)))
+
+	// Execute
+	err = uploader.Publish(ctx)
+
+	// Assert
+	require.NoError(t, err)
+	for _, prefix := range []string{
+		"enercity/prod/tripica/dt=2025-11-27/run=run-1",
+		"enercity/prod/bookkeeper/dt=2025-11-27/run=run-1",
+		"enercity/prod/dt=2025-11-27/run=run-1",
+	} {
+		assert.Equal(t, "", getObject(t, client, "exports", prefix+"/"+SuccessMarker))
+	}
+
+	var latest Latest
+	require.NoError(t, json.Unmarshal([]byte(getObject(t, client, "exports", "enercity/prod/latest.json")), &latest))
+	assert.Equal(t, "run-1", latest.RunID)
+	assert.Equal(t, "2025-11-27", latest.Date)
+	assert.Equal(t, "enercity/prod/dt=2025-11-27/run=run-1/manifest.json", latest.Manifest)
+	assert.Len(t, latest.Prefixes, 3)
+}
+
+func TestS3Uploader_PublishRetriesThrottling(t *testing.T) {
+	// Setup
+	// The SDK gives up after three attempts, the upload policy does not.
+	throttled := map[string]int{}
+	var mu sync.Mutex
+	client, _ := newFakeS3WithMiddleware(t, func(w http.ResponseWriter, r *http.Request) bool {
+		name := path.Base(r.URL.Path)
+		if r.Method != http.MethodPut || (name != SuccessMarker && name != ManifestName && name != LatestName) {
+			return false
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if throttled[r.URL.Path] == 3 {
+			return false
+		}
+		throttled[r.URL.Path]++
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	})
+	uploader := newTestMultipartUploader(client)
+
+	ctx := context.Background()
+	require.NoError(t, uploader.Put(ctx, "tripica_results_0000.csv", bytes.NewReader([]byte("id\n1\n")), ObjectMeta{System: "tripica"}))
+
+	// Execute
+	err := uploader.UploadManifest(ctx, uploader.Manifest(nil))
+	require.NoError(t, err)
+	err = uploader.Publish(ctx)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Len(t, throttled, 3, "the manifest, success marker and latest pointer should each be retried")
+	assert.Equal(t, "", getObject(t, client, "exports", "enercity/prod/"+SuccessMarker))
+	assert.Contains(t, getObject(t, client, "exports", "enercity/prod/"+LatestName), `"manifest": "enercity/prod/manifest.json"`)
+}
+
+func TestS3Uploader_CleanupStale(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	ctx := context.Background()
+
+	// Yesterday's run with the fixed layout wrote five chunks
+	for _, key := range []string{
+		"enercity/prod/tripica_results_0000.csv",
+		"enercity/prod/tripica_results_0001.csv",
+		"enercity/prod/tripica_results_0002.csv",
+		"enercity/prod/tripica_results_0003.csv",
+		"enercity/prod/tripica_results_0004.csv",
+		"enercity/prod/manifest.json",
+		"enercity/prod/README.txt",
+		"enercity/prod/latest.json",
+		"enercity/prod/tripica/dt=2025-11-26/run=run-0/tripica_results_0000.csv",
+		"enercity/test/tripica_results_0000.csv",
+	} {
+		putObject(t, client, key)
+	}
+
+	uploader := newS3UploaderWithClient(client, "exports", "enercity/prod")
+	for i := 0; i < 3; i++ {
+		name := chunkFileName("tripica", "results", i, FormatCSV)
+		require.NoError(t, uploader.Put(ctx, name, bytes.NewReader([]byte("id\n")), ObjectMeta{System: "tripica"}))
+	}
+	require.NoError(t, uploader.UploadManifest(ctx, uploader.Manifest(nil)))
+
+	// Execute
+	deleted, err := uploader.CleanupStale(ctx)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, []string{
+		"enercity/prod/README.txt",
+		"enercity/prod/latest.json",
+		"enercity/prod/manifest.json",
+		"enercity/prod/tripica/dt=2025-11-26/run=run-0/tripica_results_0000.csv",
+		"enercity/prod/tripica_results_0000.csv",
+		"enercity/prod/tripica_results_0001.csv",
+		"enercity/prod/tripica_results_0002.csv",
+		"enercity/test/tripica_results_0000.csv",
+	}, listKeys(t, client, "exports"))
+}
+
+// Helper Functions
+
+func putObject(t *testing.T, client *s3.Client, key string) {
+	t.Helper()
+
+	_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("exports"),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader([]byte("stale")),
+	})
+	require.NoError(t, err)
+}
+
+func listKeys(t *testing.T, client *s3.Client, bucket string) []string {
+	t.Helper()
+
+	out, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+	require.NoError(t, err)
+
+	var keys []string
+	for _, obj := range out.Contents {
+		keys = append(keys, aws.ToString(obj.Key))
+	}
+	sort.Strings(keys)
+	return keys
+}
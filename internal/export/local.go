@@ -0,0 +1,60 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LocalSink stores chunks in a local directory, e.g. for local development.
+type LocalSink struct {
+	dir string
+}
+
+// NewLocalSink returns a sink writing into dir, which is created on demand.
+func NewLocalSink(dir string) *LocalSink {
+	return &LocalSink{dir: dir}
+}
+
+// Put writes body to a temporary file and renames it to name once it is
+// complete, so readers never see a partial file.
+func (s *LocalSink) Put(ctx context.Context, name string, body io.Reader, _ ObjectMeta) error {
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	target := filepath.Join(s.dir, filepath.FromSlash(name))
+
+	// Create output directory with restricted permissions (owner + group)
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.part")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	cleanup := func() {
+		_ = tmp.Close()           // Ignore close error during error handling
+		_ = os.Remove(tmp.Name()) // Ignore remove error during error handling
+	}
+
+	if _, err := io.Copy(tmp, ctxReader{ctx: ctx, r: body}); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to close %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to rename %s: %w", name, err)
+	}
+
+	log.Ctx(ctx).Info().Str("file", target).Msg("File written")
+	return nil
+}
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ManifestName is the object name of the run manifest.
+const ManifestName = "manifest.json"
+
+// Manifest describes the objects a run uploaded and how they were stored.
+type Manifest struct {
+	RunID       string           `json:"run_id"`
+	ClientID    string           `json:"client_id"`
+	Environment string           `json:"environment"`
+	Version     string           `json:"version"`
+	Commit      string           `json:"commit"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Bucket      string           `json:"bucket"`
+	Objects     []UploadedObject `json:"objects"`
+	Quality     []QualityCheck   `json:"quality,omitempty"`
+	Changes     *ChangeSummary   `json:"changes,omitempty"`
+}
+
+// QualityCheck is the outcome of a data-quality assertion of the run.
+type QualityCheck struct {
+	Name       string `json:"name"`
+	Severity   string `json:"severity"`
+	Status     string `json:"status"`
+	Violations int    `json:"violations"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ChangeSummary counts the open items that changed since the previous run.
+type ChangeSummary struct {
+	Previous string `json:"previous"`
+	Added    int    `json:"added"`
+	Removed  int    `json:"removed"`
+	Changed  int    `json:"changed"`
+	Delta    string `json:"delta"`
+}
+
+// Manifest assembles the manifest of all objects written so far. Row counts
+// of streamed chunks are only known once the export finished, so they are
+// filled in from files.
+func (u *S3Uploader) Manifest(files []File) Manifest {
+	rows := make(map[string]int64, len(files))
+	for _, f := range files {
+		rows[filepath.Base(f.Path)] = f.Rows
+	}
+
+	objects := u.Objects()
+	for i := range objects {
+		if objects[i].Rows == 0 {
+			objects[i].Rows = rows[path.Base(objects[i].Key)]
+		}
+	}
+
+	return Manifest{
+		RunID:       u.run.ID,
+		ClientID:    u.run.ClientID,
+		Environment: u.run.Environment,
+		Version:     u.run.Version,
+		Commit:      u.run.Commit,
+		CreatedAt:   time.Now().UTC(),
+		Bucket:      u.bucket,
+		Objects:     objects,
+	}
+}
+
+// UploadManifest writes the manifest into the run directory, i.e. the key
+// template with {system} and {table} left empty.
+func (u *S3Uploader) UploadManifest(ctx context.Context, manifest Manifest) error {
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	key := u.objectKey(ManifestName, ObjectMeta{})
+	attrs := u.attributes(key, ObjectMeta{})
+	if err := u.putBytes(ctx, key, body, attrs); err != nil {
+		return fmt.Errorf("failed to upload manifest: %w", err)
+	}
+
+	u.mu.Lock()
+	u.manifestKey = key
+	u.mu.Unlock()
+
+	log.Ctx(ctx).Info().
+		Str("bucket", u.bucket).
+		Str("key", key).
+		Int("objects", len(manifest.Objects)).
+		Msg("Manifest uploaded")
+	return nil
+}
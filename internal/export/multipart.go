@@ -0,0 +1,265 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec G501 -- Content-MD5 is an integrity check required by S3, not a security measure
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/enercity/billing-data-aggregator/internal/retry"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// MinPartSize is the smallest part size S3 accepts, except for the last part.
+	MinPartSize = 5 * 1024 * 1024
+	// maxParts is the maximum number of parts of a single multipart upload.
+	maxParts = 10000
+
+	DefaultPartSize           = 16 * 1024 * 1024
+	DefaultMultipartThreshold = 64 * 1024 * 1024
+	DefaultUploadConcurrency  = 4
+	DefaultPartRetries        = 5
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WithMultipart configures multipart uploads. Files of at least threshold
+// bytes are uploaded in parts of partSize, with up to concurrency parts in
+// flight and every part retried up to maxRetries times. Zero values keep
+// the defaults.
+func (u *S3Uploader) WithMultipart(threshold, partSize int64, concurrency, maxRetries int) *S3Uploader {
+	if threshold > 0 {
+		u.multipartThreshold = threshold
+	}
+	if partSize > 0 {
+		u.partSize = int(max(partSize, MinPartSize))
+	}
+	if concurrency > 0 {
+		u.concurrency = concurrency
+	}
+	if maxRetries > 0 {
+		u.maxPartRetries = maxRetries
+	}
+	return u
+}
+
+// multipartUpload tracks an upload between CreateMultipartUpload and its
+// completion or abort.
+type multipartUpload struct {
+	u        *S3Uploader
+	key      string
+	uploadID *string
+	ctx      context.Context
+}
+
+func (u *S3Uploader) startMultipart(ctx context.Context, key string, attrs objectAttributes) (*multipartUpload, error) {
+	created, err := u.client.CreateMultipartUpload(ctx, u.createMultipartInput(key, attrs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start multipart upload for %s: %w", key, err)
+	}
+	return &multipartUpload{u: u, key: key, uploadID: created.UploadId, ctx: ctx}, nil
+}
+
+func (m *multipartUpload) complete(ctx context.Context, parts []types.CompletedPart) error {
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+	})
+
+	if _, err := m.u.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.u.bucket),
+		Key:             aws.String(m.key),
+		UploadId:        m.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return m.abort(fmt.Errorf("failed to complete multipart upload for %s: %w", m.key, err))
+	}
+	return nil
+}
+
+// abort discards the uploaded parts and returns cause.
+func (m *multipartUpload) abort(cause error) error {
+	// The upload context may already be cancelled; aborting must still happen.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(m.ctx), 30*time.Second)
+	defer cancel()
+
+	if _, err := m.u.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(m.u.bucket),
+		Key:      aws.String(m.key),
+		UploadId: m.uploadID,
+	}); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("key", m.key).Msg("Failed to abort multipart upload")
+	} else {
+		log.Ctx(ctx).Warn().Err(cause).Str("key", m.key).Msg("Aborted multipart upload")
+	}
+	return cause
+}
+
+// uploadMultipart uploads size bytes of r in parallel parts.
+func (u *S3Uploader) uploadMultipart(ctx context.Context, key string, r io.ReaderAt, size int64, attrs objectAttributes) error {
+	partSize := int64(u.partSize)
+	if size > partSize*maxParts {
+		partSize = (size + maxParts - 1) / maxParts
+	}
+	numParts := int((size + partSize - 1) / partSize)
+
+	log.Ctx(ctx).Info().
+		Str("bucket", u.bucket).
+		Str("key", key).
+		Int64("size", size).
+		Int("parts", numParts).
+		Int("concurrency", u.concurrency).
+		Msg("Starting multipart upload")
+
+	mu, err := u.startMultipart(ctx, key, attrs)
+	if err != nil {
+		return err
+	}
+
+	partCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	partNumbers := make(chan int32)
+	var (
+		mutex    sync.Mutex
+		parts    []types.CompletedPart
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	for w := 0; w < min(u.concurrency, numParts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, partSize)
+
+			for partNumber := range partNumbers {
+				offset := int64(partNumber-1) * partSize
+				n, err := r.ReadAt(buf[:min(partSize, size-offset)], offset)
+				if err == io.EOF && int64(n) == min(partSize, size-offset) {
+					err = nil
+				}
+				if err == nil {
+					var part types.CompletedPart
+					part, err = u.uploadPart(partCtx, mu, partNumber, buf[:n])
+					if err == nil {
+						mutex.Lock()
+						parts = append(parts, part)
+						mutex.Unlock()
+						continue
+					}
+				}
+
+				mutex.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mutex.Unlock()
+				cancel()
+			}
+		}()
+	}
+
+dispatch:
+	for partNumber := int32(1); int(partNumber) <= numParts; partNumber++ {
+		select {
+		case partNumbers <- partNumber:
+		case <-partCtx.Done():
+			break dispatch
+		}
+	}
+	close(partNumbers)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return mu.abort(fmt.Errorf("multipart upload of %s failed: %w", key, firstErr))
+	}
+
+	if err := mu.complete(ctx, parts); err != nil {
+		return err
+	}
+
+	log.Ctx(ctx).Info().Str("bucket", u.bucket).Str("key", key).Int("parts", numParts).Msg("Upload successful")
+	return nil
+}
+
+// uploadPart uploads a single part with MD5 and CRC32C checksums, retrying
+// failed attempts, see retryPolicy.
+func (u *S3Uploader) uploadPart(ctx context.Context, mu *multipartUpload, partNumber int32, data []byte) (types.CompletedPart, error) {
+	md5Sum := md5.Sum(data) // #nosec G401 -- see import comment
+	contentMD5 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(data, crc32cTable))
+	checksumCRC32C := base64.StdEncoding.EncodeToString(crc)
+
+	var out *s3.UploadPartOutput
+	policy := u.retryPolicy("S3 part upload")
+	policy.OnRetry = func(attempt int, wait time.Duration, err error) {
+		log.Ctx(ctx).Warn().
+			Err(err).
+			Str("key", mu.key).
+			Int32("part", partNumber).
+			Int("retry", attempt).
+			Dur("wait", wait).
+			Msg("Retrying part upload")
+	}
+	err := retry.Do(ctx, policy, func(ctx context.Context) error {
+		var err error
+		out, err = u.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:         aws.String(u.bucket),
+			Key:            aws.String(mu.key),
+			UploadId:       mu.uploadID,
+			PartNumber:     aws.Int32(partNumber),
+			Body:           bytes.NewReader(data),
+			ContentLength:  aws.Int64(int64(len(data))),
+			ContentMD5:     aws.String(contentMD5),
+			ChecksumCRC32C: aws.String(checksumCRC32C),
+		}, withoutSDKRetries)
+		return err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return types.CompletedPart{}, ctx.Err()
+		}
+		return types.CompletedPart{}, fmt.Errorf("part %d: %w", partNumber, err)
+	}
+
+	return types.CompletedPart{
+		ETag:           out.ETag,
+		PartNumber:     aws.Int32(partNumber),
+		ChecksumCRC32C: aws.String(checksumCRC32C),
+	}, nil
+}
+
+// withoutSDKRetries disables the retryer of the SDK for a request the
+// uploader retries itself with retryPolicy, so maxPartRetries is the only
+// retry limit.
+func withoutSDKRetries(o *s3.Options) {
+	o.Retryer = aws.NopRetryer{}
+}
+
+// retryPolicy retries S3 requests failing on throttling, server or
+// connection errors up to maxPartRetries times, with exponential backoff
+// and full jitter.
+func (u *S3Uploader) retryPolicy(name string) retry.Policy {
+	return retry.Policy{
+		Name:        name,
+		MaxAttempts: u.maxPartRetries + 1,
+		BaseDelay:   u.retryBaseDelay,
+		MaxDelay:    u.retryMaxDelay,
+		Retryable:   retry.Any(retry.AWS, retry.Network),
+	}
+}
@@ -0,0 +1,207 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Uploader_UploadFileMultipart(t *testing.T) {
+	// Setup
+	client, _ := newFakeS3WithMiddleware(t, nil)
+	uploader := newTestMultipartUploader(client)
+
+	content := bytes.Repeat([]byte("billing-data;"), 1000)
+	localPath := writeTempFile(t, "tripica_results_0000.csv", content)
+
+	// Execute
+	err := uploader.UploadFile(context.Background(), localPath)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, string(content), getObject(t, client, "exports", "enercity/prod/tripica_results_0000.csv"))
+}
+
+func TestS3Uploader_UploadFileRetriesFailedPart(t *testing.T) {
+	// Setup
+	var partTwoAttempts atomic.Int32
+	client, _ := newFakeS3WithMiddleware(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && r.URL.Query().Get("partNumber") == "2" {
+			if partTwoAttempts.Add(1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return true
+			}
+		}
+		return false
+	})
+	uploader := newTestMultipartUploader(client)
+
+	content := bytes.Repeat([]byte("x"), 5000)
+	localPath := writeTempFile(t, "retry.csv", content)
+
+	// Execute
+	err := uploader.UploadFile(context.Background(), localPath)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), partTwoAttempts.Load(), "Part 2 should succeed on the third attempt")
+	assert.Equal(t, string(content), getObject(t, client, "exports", "enercity/prod/retry.csv"))
+}
+
+func TestS3Uploader_UploadFilePartRetryLimit(t *testing.T) {
+	// Setup
+	var partAttempts atomic.Int32
+	client, _ := newFakeS3WithMiddleware(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && r.URL.Query().Get("partNumber") == "2" {
+			partAttempts.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+	uploader := newTestMultipartUploader(client)
+	uploader.maxPartRetries = 2
+
+	localPath := writeTempFile(t, "failing.csv", bytes.Repeat([]byte("x"), 5000))
+
+	// Execute
+	err := uploader.UploadFile(context.Background(), localPath)
+
+	// Assert
+	require.Error(t, err)
+	assert.Equal(t, int32(3), partAttempts.Load(), "The SDK should not retry below the uploader")
+}
+
+func TestS3Uploader_UploadFilePutObjectRetryLimit(t *testing.T) {
+	// Setup
+	var putAttempts atomic.Int32
+	client, _ := newFakeS3WithMiddleware(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut {
+			putAttempts.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+	uploader := newTestMultipartUploader(client)
+	uploader.maxPartRetries = 1
+
+	localPath := writeTempFile(t, "small.csv", []byte("billing-data"))
+
+	// Execute
+	err := uploader.UploadFile(context.Background(), localPath)
+
+	// Assert
+	require.Error(t, err)
+	assert.Equal(t, int32(2), putAttempts.Load(), "The SDK should not retry below the uploader")
+}
+
+func TestS3Uploader_UploadFileAbortsOnCancel(t *testing.T) {
+	// Setup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, _ := newFakeS3WithMiddleware(t, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == http.MethodPut && r.URL.Query().Get("partNumber") != "" {
+			cancel()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+	uploader := newTestMultipartUploader(client)
+
+	localPath := writeTempFile(t, "cancelled.csv", bytes.Repeat([]byte("x"), 5000))
+
+	// Execute
+	err := uploader.UploadFile(ctx, localPath)
+
+	// Assert
+	require.ErrorIs(t, err, context.Canceled)
+
+	uploads, err := client.ListMultipartUploads(context.Background(), &s3.ListMultipartUploadsInput{
+		Bucket: aws.String("exports"),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, uploads.Uploads, "Cancelled multipart upload should be aborted")
+}
+
+func TestS3Uploader_Backoff(t *testing.T) {
+	uploader := newS3UploaderWithClient(nil, "bucket", "")
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		wait := uploader.retryPolicy("test").Backoff(attempt)
+		assert.GreaterOrEqual(t, wait, time.Duration(0))
+		assert.LessOrEqual(t, wait, 30*time.Second, "Backoff should be capped")
+	}
+}
+
+func TestS3Uploader_WithMultipartEnforcesMinPartSize(t *testing.T) {
+	uploader := newS3UploaderWithClient(nil, "bucket", "").WithMultipart(1, 1024, 8, 2)
+
+	assert.Equal(t, MinPartSize, uploader.partSize)
+	assert.Equal(t, int64(1), uploader.multipartThreshold)
+	assert.Equal(t, 8, uploader.concurrency)
+	assert.Equal(t, 2, uploader.maxPartRetries)
+}
+
+// Helper Functions
+
+func newTestMultipartUploader(client *s3.Client) *S3Uploader {
+	uploader := newS3UploaderWithClient(client, "exports", "enercity/prod")
+	// Bypass the S3 minimum part size to keep test payloads small.
+	uploader.partSize = 1024
+	uploader.multipartThreshold = 2048
+	uploader.concurrency = 3
+	uploader.retryBaseDelay = time.Millisecond
+	uploader.retryMaxDelay = 5 * time.Millisecond
+	return uploader
+}
+
+// newFakeS3WithMiddleware starts a gofakes3 server whose requests pass
+// through intercept first; intercept returns true if it handled the request.
+func newFakeS3WithMiddleware(t *testing.T, intercept func(http.ResponseWriter, *http.Request) bool) (*s3.Client, *httptest.Server) {
+	t.Helper()
+
+	backend := s3mem.New()
+	require.NoError(t, backend.CreateBucket("exports"))
+	handler := gofakes3.New(backend).Server()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if intercept != nil && intercept(w, r) {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		BaseEndpoint: aws.String(server.URL),
+		Region:       "eu-central-1",
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+	return client, server
+}
+
+func writeTempFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, content, 0600))
+	return path
+}
@@ -0,0 +1,234 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/enercity/billing-data-aggregator/internal/retry"
+)
+
+// Supported server-side encryption modes.
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+// ObjectSettings are applied to every object the uploader writes.
+type ObjectSettings struct {
+	// SSE is empty (bucket default), AES256 or aws:kms.
+	SSE string
+	// KMSKeyID selects the KMS key for aws:kms, empty for the AWS managed key.
+	KMSKeyID string
+	// StorageClass is empty (STANDARD) or any S3 storage class.
+	StorageClass string
+	// RetentionClass is written as the retention_class tag for lifecycle rules.
+	RetentionClass string
+}
+
+// RunInfo identifies the run that produced the uploaded objects.
+type RunInfo struct {
+	ID          string
+	ClientID    string
+	Environment string
+	Version     string
+	Commit      string
+	// Started is the start of the run in the export time zone. Its date
+	// fills the {date} placeholder of the key template.
+	Started time.Time
+}
+
+// UploadedObject records an object written by the uploader.
+type UploadedObject struct {
+	Key          string            `json:"key"`
+	System       string            `json:"system,omitempty"`
+	Table        string            `json:"table,omitempty"`
+	Rows         int64             `json:"rows"`
+	Size         int64             `json:"size"`
+	SHA256       string            `json:"sha256"`
+	ContentType  string            `json:"content_type"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	SSE          string            `json:"sse,omitempty"`
+	KMSKeyID     string            `json:"kms_key_id,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// objectAttributes are the headers of a single object.
+type objectAttributes struct {
+	contentType string
+	tags        map[string]string
+	metadata    map[string]string
+}
+
+func (u *S3Uploader) attributes(name string, meta ObjectMeta) objectAttributes {
+	attrs := objectAttributes{
+		contentType: contentType(name),
+		tags:        map[string]string{},
+		metadata:    map[string]string{},
+	}
+
+	setIf := func(m map[string]string, k, v string) {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	setIf(attrs.tags, "client", u.run.ClientID)
+	setIf(attrs.tags, "environment", u.run.Environment)
+	setIf(attrs.tags, "system", meta.System)
+	setIf(attrs.tags, "run_id", u.run.ID)
+	setIf(attrs.tags, "retention_class", u.settings.RetentionClass)
+
+	if meta.Rows > 0 || meta.SHA256 != "" {
+		attrs.metadata["rows"] = strconv.FormatInt(meta.Rows, 10)
+	}
+	setIf(attrs.metadata, "sha256", meta.SHA256)
+	setIf(attrs.metadata, "version", u.run.Version)
+	setIf(attrs.metadata, "commit", u.run.Commit)
+
+	return attrs
+}
+
+func (a objectAttributes) tagging() *string {
+	if len(a.tags) == 0 {
+		return nil
+	}
+	values := url.Values{}
+	for k, v := range a.tags {
+		values.Set(k, v)
+	}
+	return aws.String(values.Encode())
+}
+
+func (u *S3Uploader) putObjectInput(key string, body io.Reader, attrs objectAttributes) *s3.PutObjectInput {
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(u.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(attrs.contentType),
+		Metadata:    attrs.metadata,
+		Tagging:     attrs.tagging(),
+	}
+	if u.settings.SSE != "" {
+		in.ServerSideEncryption = types.ServerSideEncryption(u.settings.SSE)
+	}
+	if u.settings.SSE == SSEKMS && u.settings.KMSKeyID != "" {
+		in.SSEKMSKeyId = aws.String(u.settings.KMSKeyID)
+	}
+	if u.settings.StorageClass != "" {
+		in.StorageClass = types.StorageClass(u.settings.StorageClass)
+	}
+	return in
+}
+
+// putBytes uploads body to key, retried on transient errors like the data
+// files.
+func (u *S3Uploader) putBytes(ctx context.Context, key string, body []byte, attrs objectAttributes) error {
+	return retry.Do(ctx, u.retryPolicy("S3 upload"), func(ctx context.Context) error {
+		_, err := u.client.PutObject(ctx, u.putObjectInput(key, bytes.NewReader(body), attrs), withoutSDKRetries)
+		return err
+	})
+}
+
+func (u *S3Uploader) createMultipartInput(key string, attrs objectAttributes) *s3.CreateMultipartUploadInput {
+	in := &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(u.bucket),
+		Key:               aws.String(key),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+		ContentType:       aws.String(attrs.contentType),
+		Metadata:          attrs.metadata,
+		Tagging:           attrs.tagging(),
+	}
+	if u.settings.SSE != "" {
+		in.ServerSideEncryption = types.ServerSideEncryption(u.settings.SSE)
+	}
+	if u.settings.SSE == SSEKMS && u.settings.KMSKeyID != "" {
+		in.SSEKMSKeyId = aws.String(u.settings.KMSKeyID)
+	}
+	if u.settings.StorageClass != "" {
+		in.StorageClass = types.StorageClass(u.settings.StorageClass)
+	}
+	return in
+}
+
+// record adds a successfully written object to the run manifest.
+func (u *S3Uploader) record(key string, meta ObjectMeta, size int64, sha string, attrs objectAttributes) {
+	obj := UploadedObject{
+		Key:          key,
+		System:       meta.System,
+		Table:        meta.Table,
+		Rows:         meta.Rows,
+		Size:         size,
+		SHA256:       sha,
+		ContentType:  attrs.contentType,
+		StorageClass: u.settings.StorageClass,
+		SSE:          u.settings.SSE,
+		Tags:         attrs.tags,
+		Metadata:     attrs.metadata,
+	}
+	if u.settings.SSE == SSEKMS {
+		obj.KMSKeyID = u.settings.KMSKeyID
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.objects = append(u.objects, obj)
+}
+
+// Objects returns the objects written so far.
+func (u *S3Uploader) Objects() []UploadedObject {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]UploadedObject(nil), u.objects...)
+}
+
+// contentType derives the Content-Type from the chunk extension.
+func contentType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return "application/gzip"
+	case strings.HasSuffix(name, ".csv"):
+		return "text/csv; charset=utf-8"
+	case strings.HasSuffix(name, ".parquet"):
+		return "application/vnd.apache.parquet"
+	case path.Ext(name) == ".json":
+		return "application/json"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func validateSettings(settings ObjectSettings) error {
+	switch settings.SSE {
+	case "", SSEAES256, SSEKMS:
+	default:
+		return fmt.Errorf("unsupported server-side encryption %q", settings.SSE)
+	}
+	if settings.KMSKeyID != "" && settings.SSE != SSEKMS {
+		return fmt.Errorf("KMS key requires server-side encryption %s", SSEKMS)
+	}
+	return nil
+}
+
+func hashFile(f *os.File) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,102 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Uploader_ObjectSettings(t *testing.T) {
+	// Setup
+	uploader := newS3UploaderWithClient(nil, "exports", "enercity/prod")
+	uploader.settings = ObjectSettings{
+		SSE:            SSEKMS,
+		KMSKeyID:       "arn:aws:kms:eu-central-1:123456789012:key/billing",
+		StorageClass:   "STANDARD_IA",
+		RetentionClass: "finance-10y",
+	}
+	uploader.WithRun(RunInfo{ID: "run-1", ClientID: "enercity", Environment: "prod", Version: "1.2.3", Commit: "abc123"})
+
+	// Execute
+	attrs := uploader.attributes("tripica_results_0000.csv", ObjectMeta{System: "tripica", Rows: 42, SHA256: "deadbeef"})
+	in := uploader.putObjectInput("enercity/prod/tripica_results_0000.csv", nil, attrs)
+
+	// Assert
+	assert.Equal(t, types.ServerSideEncryptionAwsKms, in.ServerSideEncryption)
+	assert.Equal(t, "arn:aws:kms:eu-central-1:123456789012:key/billing", aws.ToString(in.SSEKMSKeyId))
+	assert.Equal(t, types.StorageClassStandardIa, in.StorageClass)
+	assert.Equal(t, "text/csv; charset=utf-8", aws.ToString(in.ContentType))
+	assert.Equal(t, map[string]string{"rows": "42", "sha256": "deadbeef", "version": "1.2.3", "commit": "abc123"}, in.Metadata)
+
+	tags, err := url.ParseQuery(aws.ToString(in.Tagging))
+	require.NoError(t, err)
+	assert.Equal(t, "enercity", tags.Get("client"))
+	assert.Equal(t, "prod", tags.Get("environment"))
+	assert.Equal(t, "tripica", tags.Get("system"))
+	assert.Equal(t, "run-1", tags.Get("run_id"))
+	assert.Equal(t, "finance-10y", tags.Get("retention_class"))
+
+	multipart := uploader.createMultipartInput("key", attrs)
+	assert.Equal(t, types.ServerSideEncryptionAwsKms, multipart.ServerSideEncryption)
+	assert.Equal(t, in.Tagging, multipart.Tagging)
+}
+
+func TestValidateSettings(t *testing.T) {
+	assert.NoError(t, validateSettings(ObjectSettings{}))
+	assert.NoError(t, validateSettings(ObjectSettings{SSE: SSEAES256}))
+	assert.NoError(t, validateSettings(ObjectSettings{SSE: SSEKMS, KMSKeyID: "key"}))
+	assert.Error(t, validateSettings(ObjectSettings{SSE: "rot13"}))
+	assert.Error(t, validateSettings(ObjectSettings{SSE: SSEAES256, KMSKeyID: "key"}))
+}
+
+func TestContentType(t *testing.T) {
+	assert.Equal(t, "text/csv; charset=utf-8", contentType("a.csv"))
+	assert.Equal(t, "application/gzip", contentType("a.csv.gz"))
+	assert.Equal(t, "application/vnd.apache.parquet", contentType("a.parquet"))
+	assert.Equal(t, "application/json", contentType("manifest.json"))
+}
+
+func TestS3Uploader_UploadFilesWritesManifest(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	uploader := newS3UploaderWithClient(client, "exports", "enercity/prod").
+		WithRun(RunInfo{ID: "run-1", ClientID: "enercity", Environment: "prod", Version: "1.2.3", Commit: "abc123"})
+
+	files := []File{{
+		Path:   writeTempFile(t, "tripica_results_0000.csv", []byte("id\n1\n2\n")),
+		System: "tripica",
+		Table:  "results",
+		Rows:   2,
+	}}
+
+	// Execute
+	require.NoError(t, uploader.UploadFiles(context.Background(), files))
+	require.NoError(t, uploader.UploadManifest(context.Background(), uploader.Manifest(files)))
+
+	// Assert
+	head, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("exports"),
+		Key:    aws.String("enercity/prod/tripica_results_0000.csv"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "text/csv; charset=utf-8", aws.ToString(head.ContentType))
+	assert.Equal(t, "2", head.Metadata["rows"])
+	assert.Equal(t, "1.2.3", head.Metadata["version"])
+
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal([]byte(getObject(t, client, "exports", "enercity/prod/manifest.json")), &manifest))
+	assert.Equal(t, "run-1", manifest.RunID)
+	require.Len(t, manifest.Objects, 1)
+	assert.Equal(t, "enercity/prod/tripica_results_0000.csv", manifest.Objects[0].Key)
+	assert.Equal(t, int64(2), manifest.Objects[0].Rows)
+	assert.Equal(t, int64(7), manifest.Objects[0].Size)
+	assert.Len(t, manifest.Objects[0].SHA256, 64)
+	assert.Equal(t, "tripica", manifest.Objects[0].Tags["system"])
+}
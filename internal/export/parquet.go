@@ -0,0 +1,599 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/encoding"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultParquetRowGroupSize keeps row groups in the range Athena and
+	// Spark split well on without buffering whole chunks in memory.
+	DefaultParquetRowGroupSize = 100000
+
+	// maxDecimalPrecision is the largest precision Spark and Athena read.
+	// Unconstrained NUMERIC columns carry no typmod and may hold any number
+	// of digits, so they and wider columns are written as strings.
+	maxDecimalPrecision = 38
+)
+
+// ParquetOptions configures the Parquet writer.
+type ParquetOptions struct {
+	// Compression is one of snappy, zstd, gzip or none. Defaults to snappy.
+	Compression string
+	// RowGroupSize is the maximum number of rows per row group.
+	RowGroupSize int
+	// Location is used to interpret timestamps without time zone.
+	// Defaults to UTC.
+	Location *time.Location
+}
+
+type ParquetExporter struct {
+	db             *sql.DB
+	outputDir      string
+	maxRowsPerFile int
+	codec          compress.Codec
+	rowGroupSize   int
+	location       *time.Location
+	reconcile      bool
+	sumColumns     []string
+}
+
+func NewParquetExporter(db *sql.DB, outputDir string, maxRowsPerFile int, opts ParquetOptions) (*ParquetExporter, error) {
+	codec, err := parquetCodec(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	rowGroupSize := opts.RowGroupSize
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultParquetRowGroupSize
+	}
+
+	location := opts.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	return &ParquetExporter{
+		db:             db,
+		outputDir:      outputDir,
+		maxRowsPerFile: maxRowsPerFile,
+		codec:          codec,
+		rowGroupSize:   rowGroupSize,
+		location:       location,
+	}, nil
+}
+
+func parquetCodec(name string) (compress.Codec, error) {
+	switch strings.ToLower(name) {
+	case "", "snappy":
+		return &parquet.Snappy, nil
+	case "zstd":
+		return &parquet.Zstd, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "none", "uncompressed":
+		return &parquet.Uncompressed, nil
+	default:
+		return nil, fmt.Errorf("unsupported parquet compression %q", name)
+	}
+}
+
+func (e *ParquetExporter) ExportTable(ctx context.Context, tableName, system string) ([]File, error) {
+	log.Ctx(ctx).Info().Str("table", tableName).Str("system", system).Msg("Exporting table to Parquet")
+
+	if !e.reconcile {
+		return e.exportRows(ctx, e.db, tableName, system, nil)
+	}
+	return reconciled(ctx, e.db, tableName, e.sumColumns, func(tx *sql.Tx, sums *columnSums) ([]File, error) {
+		return e.exportRows(ctx, tx, tableName, system, sums)
+	})
+}
+
+// exportRows writes the rows of the table into chunks, adding up the
+// columns of sums if set.
+func (e *ParquetExporter) exportRows(ctx context.Context, q queryer, tableName, system string, sums *columnSums) ([]File, error) {
+	// #nosec G201 -- tableName is validated and schema-qualified, not user input
+	query := fmt.Sprintf("SELECT * FROM %s", tableName)
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table %s: %w", tableName, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to close rows")
+		}
+	}()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get column types: %w", err)
+	}
+
+	schema, columns, err := parquetSchema(tableName, columnTypes)
+	if err != nil {
+		return nil, err
+	}
+	if sums != nil {
+		names := make([]string, len(columns))
+		for i, col := range columns {
+			names[i] = col.name
+		}
+		if err := sums.header(names); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(e.outputDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	var files []File
+	fileIndex := 0
+	rowCount := 0
+	var writer *parquet.Writer
+	var currentFile *os.File
+
+	// abort removes the partial chunk, which must not be delivered.
+	abort := func(err error) ([]File, error) {
+		_ = currentFile.Close()           // Ignore close error during error handling
+		_ = os.Remove(currentFile.Name()) // Ignore remove error during error handling
+		return files[:len(files)-1], err
+	}
+
+	closeCurrent := func() error {
+		if currentFile == nil {
+			return nil
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("failed to finalize parquet file: %w", err)
+		}
+		if err := currentFile.Close(); err != nil {
+			return fmt.Errorf("failed to close parquet file: %w", err)
+		}
+		currentFile = nil
+		return nil
+	}
+
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if rowCount%e.maxRowsPerFile == 0 {
+			if err := closeCurrent(); err != nil {
+				return abort(err)
+			}
+
+			filePath := filepath.Join(e.outputDir, chunkFileName(system, tableName, fileIndex, FormatParquet))
+			files = append(files, File{Path: filePath, System: system, Table: tableName})
+
+			// #nosec G304 -- filePath is internally generated, not from user input
+			currentFile, err = os.Create(filePath)
+			if err != nil {
+				return files[:len(files)-1], fmt.Errorf("failed to create file: %w", err)
+			}
+
+			writer = parquet.NewWriter(currentFile, schema,
+				parquet.Compression(e.codec),
+				parquet.MaxRowsPerRowGroup(int64(e.rowGroupSize)),
+			)
+
+			fileIndex++
+			rowCount = 0
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return abort(fmt.Errorf("failed to scan row: %w", err))
+		}
+
+		row := make(parquet.Row, len(columns))
+		for i, col := range columns {
+			v, err := col.value(values[i], e.location)
+			if err != nil {
+				return abort(fmt.Errorf("column %s: %w", col.name, err))
+			}
+			row[col.leaf] = v
+		}
+
+		if _, err := writer.WriteRows([]parquet.Row{row}); err != nil {
+			return abort(fmt.Errorf("failed to write row: %w", err))
+		}
+		if sums != nil {
+			for i, idx := range sums.index {
+				if values[idx] == nil {
+					continue
+				}
+				if err := sums.addValue(i, toText(values[idx])); err != nil {
+					return abort(fmt.Errorf("failed to sum row: %w", err))
+				}
+			}
+		}
+
+		rowCount++
+		files[len(files)-1].Rows++
+	}
+
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("error iterating rows: %w", err)
+		if currentFile != nil {
+			return abort(err)
+		}
+		return files, err
+	}
+
+	if err := closeCurrent(); err != nil {
+		return abort(err)
+	}
+
+	log.Ctx(ctx).Info().Str("table", tableName).Int("total_files", len(files)).Msg("Export completed")
+	return files, nil
+}
+
+type parquetKind int
+
+const (
+	kindString parquetKind = iota
+	kindBool
+	kindInt32
+	kindInt64
+	kindFloat
+	kindDouble
+	kindDecimal
+	kindDate
+	kindTimestamp
+	kindTimestampTZ
+)
+
+// parquetColumn maps a result column onto its leaf in the Parquet schema.
+type parquetColumn struct {
+	name      string
+	kind      parquetKind
+	leaf      int
+	precision int
+	scale     int
+}
+
+// parquetSchema derives a Parquet schema from the database column types,
+// keeping their order. All columns are optional since the source tables do
+// not guarantee NOT NULL.
+func parquetSchema(tableName string, columnTypes []*sql.ColumnType) (*parquet.Schema, []parquetColumn, error) {
+	group := orderedGroup{}
+	seen := make(map[string]bool, len(columnTypes))
+	columns := make([]parquetColumn, len(columnTypes))
+
+	for i, ct := range columnTypes {
+		col := parquetColumn{name: ct.Name()}
+		var node parquet.Node
+
+		switch strings.ToUpper(ct.DatabaseTypeName()) {
+		case "BOOL":
+			col.kind, node = kindBool, parquet.Leaf(parquet.BooleanType)
+		case "INT2", "INT4":
+			col.kind, node = kindInt32, parquet.Int(32)
+		case "INT8":
+			col.kind, node = kindInt64, parquet.Int(64)
+		case "FLOAT4":
+			col.kind, node = kindFloat, parquet.Leaf(parquet.FloatType)
+		case "FLOAT8":
+			col.kind, node = kindDouble, parquet.Leaf(parquet.DoubleType)
+		case "NUMERIC", "DECIMAL":
+			precision, scale, ok := ct.DecimalSize()
+			if !ok || precision <= 0 || precision > maxDecimalPrecision {
+				col.kind, node = kindString, parquet.String()
+				break
+			}
+			col.kind = kindDecimal
+			col.precision, col.scale = int(precision), int(scale)
+			node = parquet.Decimal(col.scale, col.precision, decimalType(col.precision))
+		case "DATE":
+			col.kind, node = kindDate, parquet.Date()
+		case "TIMESTAMP":
+			col.kind, node = kindTimestamp, parquet.TimestampAdjusted(parquet.Microsecond, true)
+		case "TIMESTAMPTZ":
+			col.kind, node = kindTimestampTZ, parquet.TimestampAdjusted(parquet.Microsecond, true)
+		default:
+			col.kind, node = kindString, parquet.String()
+		}
+
+		if seen[col.name] {
+			return nil, nil, fmt.Errorf("duplicate column %s", col.name)
+		}
+		seen[col.name] = true
+		group = append(group, orderedField{Node: parquet.Optional(node), name: col.name})
+		columns[i] = col
+	}
+
+	schema := parquet.NewSchema(tableName, group)
+
+	for i := range columns {
+		leaf, ok := schema.Lookup(columns[i].name)
+		if !ok {
+			return nil, nil, fmt.Errorf("column %s missing from parquet schema", columns[i].name)
+		}
+		columns[i].leaf = leaf.ColumnIndex
+	}
+
+	return schema, columns, nil
+}
+
+// orderedGroup is a Parquet group whose fields keep the order of the
+// result columns, like the CSV export. parquet.Group orders them by name.
+type orderedGroup []parquet.Field
+
+func (g orderedGroup) ID() int { return 0 }
+
+func (g orderedGroup) String() string {
+	s := new(strings.Builder)
+	_ = parquet.PrintSchema(s, "", g) // Writing to a strings.Builder never fails
+	return s.String()
+}
+
+func (g orderedGroup) Type() parquet.Type { return parquet.Group{}.Type() }
+
+func (g orderedGroup) Optional() bool { return false }
+
+func (g orderedGroup) Repeated() bool { return false }
+
+func (g orderedGroup) Required() bool { return true }
+
+func (g orderedGroup) Leaf() bool { return false }
+
+func (g orderedGroup) Fields() []parquet.Field { return g }
+
+func (g orderedGroup) Encoding() encoding.Encoding { return nil }
+
+func (g orderedGroup) Compression() compress.Codec { return nil }
+
+// GoType returns the struct type of a row, with the fields in order.
+func (g orderedGroup) GoType() reflect.Type {
+	fields := make([]reflect.StructField, len(g))
+	for i, f := range g {
+		fields[i] = reflect.StructField{
+			Name: goFieldName(f.Name()),
+			Type: f.GoType(),
+			Tag:  reflect.StructTag(fmt.Sprintf("parquet:%q", f.Name())),
+		}
+	}
+	return reflect.StructOf(fields)
+}
+
+// orderedField is a named field of an orderedGroup.
+type orderedField struct {
+	parquet.Node
+	name string
+}
+
+func (f orderedField) Name() string { return f.name }
+
+// Value returns the field of a row struct of the group's GoType.
+func (f orderedField) Value(base reflect.Value) reflect.Value {
+	if base.Kind() == reflect.Pointer {
+		if base.IsNil() {
+			return reflect.Value{}
+		}
+		base = base.Elem()
+	}
+	return base.FieldByName(goFieldName(f.name))
+}
+
+// goFieldName exports a column name as a struct field name.
+func goFieldName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// decimalType picks the narrowest physical type able to hold the precision.
+func decimalType(precision int) parquet.Type {
+	switch {
+	case precision <= 9:
+		return parquet.Int32Type
+	case precision <= 18:
+		return parquet.Int64Type
+	default:
+		return parquet.FixedLenByteArrayType(decimalByteWidth(precision))
+	}
+}
+
+func decimalByteWidth(precision int) int {
+	bits := float64(precision)*math.Log2(10) + 1
+	return int(math.Ceil(bits / 8))
+}
+
+func (c parquetColumn) value(v interface{}, location *time.Location) (parquet.Value, error) {
+	if v == nil {
+		return parquet.NullValue().Level(0, 0, c.leaf), nil
+	}
+
+	var pv parquet.Value
+	switch c.kind {
+	case kindBool:
+		b, ok := v.(bool)
+		if !ok {
+			return pv, fmt.Errorf("unexpected %T for boolean", v)
+		}
+		pv = parquet.BooleanValue(b)
+	case kindInt32, kindInt64:
+		n, err := toInt64(v)
+		if err != nil {
+			return pv, err
+		}
+		if c.kind == kindInt32 {
+			pv = parquet.Int32Value(int32(n)) // #nosec G115 -- INT2/INT4 columns fit into int32
+		} else {
+			pv = parquet.Int64Value(n)
+		}
+	case kindFloat, kindDouble:
+		f, err := toFloat64(v)
+		if err != nil {
+			return pv, err
+		}
+		if c.kind == kindFloat {
+			pv = parquet.FloatValue(float32(f))
+		} else {
+			pv = parquet.DoubleValue(f)
+		}
+	case kindDecimal:
+		unscaled, err := parseDecimal(toText(v), c.precision, c.scale)
+		if err != nil {
+			return pv, err
+		}
+		switch {
+		case c.precision <= 9:
+			pv = parquet.Int32Value(int32(unscaled.Int64())) // #nosec G115 -- bounded by precision
+		case c.precision <= 18:
+			pv = parquet.Int64Value(unscaled.Int64())
+		default:
+			pv = parquet.FixedLenByteArrayValue(twosComplement(unscaled, decimalByteWidth(c.precision)))
+		}
+	case kindDate:
+		t, ok := v.(time.Time)
+		if !ok {
+			return pv, fmt.Errorf("unexpected %T for date", v)
+		}
+		days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+		pv = parquet.Int32Value(int32(days)) // #nosec G115 -- dates fit into int32 days
+	case kindTimestamp, kindTimestampTZ:
+		t, ok := v.(time.Time)
+		if !ok {
+			return pv, fmt.Errorf("unexpected %T for timestamp", v)
+		}
+		if c.kind == kindTimestamp {
+			// Timestamps without time zone are wall-clock values of the
+			// configured location.
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), location)
+		}
+		pv = parquet.Int64Value(t.UnixMicro())
+	default:
+		pv = parquet.ByteArrayValue([]byte(toText(v)))
+	}
+
+	return pv.Level(0, 1, c.leaf), nil
+}
+
+func toText(v interface{}) string {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case string:
+		return t
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case int32:
+		return int64(n), nil
+	case int:
+		return int64(n), nil
+	case []byte, string:
+		return strconv.ParseInt(toText(n), 10, 64)
+	default:
+		return 0, fmt.Errorf("unexpected %T for integer", v)
+	}
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch f := v.(type) {
+	case float64:
+		return f, nil
+	case float32:
+		return float64(f), nil
+	case []byte, string:
+		return strconv.ParseFloat(toText(f), 64)
+	default:
+		return 0, fmt.Errorf("unexpected %T for float", v)
+	}
+}
+
+// parseDecimal converts the textual NUMERIC representation into its unscaled
+// integer value. Fractional digits beyond scale are rounded half to even;
+// values that exceed precision are rejected.
+func parseDecimal(s string, precision, scale int) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	fracPart = strings.TrimRight(fracPart, "0")
+	var dropped string
+	if len(fracPart) > scale {
+		fracPart, dropped = fracPart[:scale], fracPart[scale:]
+	}
+	fracPart += strings.Repeat("0", scale-len(fracPart))
+
+	digits := strings.TrimLeft(intPart+fracPart, "0")
+	if digits == "" {
+		digits = "0"
+	}
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+	if !ok || strings.Trim(dropped, "0123456789") != "" {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	if roundsUp(dropped, unscaled.Bit(0) == 1) {
+		unscaled.Add(unscaled, big.NewInt(1))
+	}
+
+	if unscaled.Sign() != 0 && len(unscaled.String()) > precision {
+		return nil, fmt.Errorf("decimal %s exceeds precision %d", s, precision)
+	}
+	if negative {
+		unscaled.Neg(unscaled)
+	}
+	return unscaled, nil
+}
+
+// roundsUp reports whether the dropped fractional digits round the kept
+// value away from zero, rounding half to even.
+func roundsUp(dropped string, odd bool) bool {
+	if dropped == "" {
+		return false
+	}
+	switch {
+	case dropped[0] > '5':
+		return true
+	case dropped[0] < '5':
+		return false
+	case strings.TrimRight(dropped[1:], "0") != "":
+		return true
+	default:
+		return odd
+	}
+}
+
+// twosComplement encodes n as a big-endian two's complement of the given width.
+func twosComplement(n *big.Int, width int) []byte {
+	out := make([]byte, width)
+	if n.Sign() >= 0 {
+		n.FillBytes(out)
+		return out
+	}
+	// -n == 2^(8*width) - |n|
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(width*8)) // #nosec G115 -- width is small and positive
+	mod.Add(mod, n).FillBytes(out)
+	return out
+}
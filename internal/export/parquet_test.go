@@ -0,0 +1,235 @@
+package export
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterInterface(t *testing.T) {
+	var _ Writer = &CSVExporter{}
+	var _ Writer = &ParquetExporter{}
+	var _ Writer = &PostgresSink{}
+}
+
+func TestParquetExporter_ExportTable(t *testing.T) {
+	// Setup
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	created := time.Date(2025, 11, 27, 8, 30, 0, 0, time.UTC)
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("customer_id").OfType("TEXT", ""),
+		sqlmock.NewColumn("amount_gross").OfType("NUMERIC", "").WithPrecisionAndScale(12, 2),
+		sqlmock.NewColumn("created_at").OfType("TIMESTAMPTZ", time.Time{}),
+		sqlmock.NewColumn("due_date").OfType("DATE", time.Time{}),
+		sqlmock.NewColumn("dunning_level").OfType("INT4", int64(0)),
+	).
+		AddRow("C-1", []byte("119.00"), created, created, int64(1)).
+		AddRow("C-2", []byte("-0.5"), created, created, nil).
+		AddRow("C-3", nil, nil, nil, int64(3))
+	mock.ExpectQuery("SELECT \\* FROM report_oibl.oibl_customer").WillReturnRows(rows)
+
+	tmpDir := t.TempDir()
+	exporter, err := NewParquetExporter(db, tmpDir, 2, ParquetOptions{Compression: "zstd"})
+	require.NoError(t, err)
+
+	// Execute
+	files, err := exporter.ExportTable(context.Background(), "report_oibl.oibl_customer", "tripica")
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, files, 2, "3 rows with 2 rows per file should produce 2 chunks")
+	assert.Equal(t, int64(2), files[0].Rows)
+	assert.Equal(t, int64(1), files[1].Rows)
+	assert.Contains(t, files[0].Path, "tripica_report_oibl.oibl_customer_0000.parquet")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	pf := openParquet(t, files[0].Path)
+	assert.Equal(t, int64(2), pf.NumRows())
+	var order []string
+	for _, field := range pf.Schema().Fields() {
+		order = append(order, field.Name())
+	}
+	assert.Equal(t, []string{"customer_id", "amount_gross", "created_at", "due_date", "dunning_level"}, order,
+		"columns should keep the table order like the CSV export")
+
+	amount, ok := pf.Schema().Lookup("amount_gross")
+	require.True(t, ok)
+	logical := amount.Node.Type().LogicalType()
+	require.NotNil(t, logical.Decimal, "NUMERIC should map to decimal")
+	assert.Equal(t, int32(12), logical.Decimal.Precision)
+	assert.Equal(t, int32(2), logical.Decimal.Scale)
+
+	createdAt, ok := pf.Schema().Lookup("created_at")
+	require.True(t, ok)
+	require.NotNil(t, createdAt.Node.Type().LogicalType().Timestamp)
+	assert.True(t, createdAt.Node.Type().LogicalType().Timestamp.IsAdjustedToUTC, "timestamps should carry a timezone")
+
+	customerID, ok := pf.Schema().Lookup("customer_id")
+	require.True(t, ok)
+	assert.NotNil(t, customerID.Node.Type().LogicalType().UTF8, "text should map to UTF-8 strings")
+
+	read := readParquetRows(t, files[0].Path, 2)
+	assert.Equal(t, "C-1", read[0][customerID.ColumnIndex].String())
+	assert.Equal(t, int64(11900), read[0][amount.ColumnIndex].Int64())
+	assert.Equal(t, int64(-50), read[1][amount.ColumnIndex].Int64())
+	assert.Equal(t, created.UnixMicro(), read[0][createdAt.ColumnIndex].Int64())
+
+	last := readParquetRows(t, files[1].Path, 1)
+	assert.True(t, last[0][amount.ColumnIndex].IsNull(), "NULL should stay NULL")
+}
+
+func TestParquetExporter_UnconstrainedNumeric(t *testing.T) {
+	// Setup
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// SELECT 1/3::numeric AS ratio, the result has 20 fractional digits.
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("ratio").OfType("NUMERIC", ""),
+		sqlmock.NewColumn("amount_gross").OfType("NUMERIC", "").WithPrecisionAndScale(12, 2),
+	).
+		AddRow([]byte("0.33333333333333333333"), []byte("0.335"))
+	mock.ExpectQuery("SELECT \\* FROM report_oibl.ratios").WillReturnRows(rows)
+
+	exporter, err := NewParquetExporter(db, t.TempDir(), 10, ParquetOptions{})
+	require.NoError(t, err)
+
+	// Execute
+	files, err := exporter.ExportTable(context.Background(), "report_oibl.ratios", "tripica")
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	pf := openParquet(t, files[0].Path)
+
+	ratio, ok := pf.Schema().Lookup("ratio")
+	require.True(t, ok)
+	assert.NotNil(t, ratio.Node.Type().LogicalType().UTF8, "Unconstrained NUMERIC should be written as string")
+	amount, ok := pf.Schema().Lookup("amount_gross")
+	require.True(t, ok)
+
+	read := readParquetRows(t, files[0].Path, 1)
+	assert.Equal(t, "0.33333333333333333333", read[0][ratio.ColumnIndex].String(), "All digits should be kept")
+	assert.Equal(t, int64(34), read[0][amount.ColumnIndex].Int64(), "Extra digits should round half to even")
+}
+
+func TestParquetExporter_RemovesPartialChunk(t *testing.T) {
+	// Setup
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("customer_id").OfType("TEXT", ""),
+		sqlmock.NewColumn("amount_gross").OfType("NUMERIC", "").WithPrecisionAndScale(4, 2),
+	).
+		AddRow("C-1", []byte("1.00")).
+		AddRow("C-2", []byte("2.00")).
+		AddRow("C-3", []byte("12345.00"))
+	mock.ExpectQuery("SELECT \\* FROM report_oibl.oibl_customer").WillReturnRows(rows)
+
+	tmpDir := t.TempDir()
+	exporter, err := NewParquetExporter(db, tmpDir, 2, ParquetOptions{})
+	require.NoError(t, err)
+
+	// Execute
+	files, err := exporter.ExportTable(context.Background(), "report_oibl.oibl_customer", "tripica")
+
+	// Assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds precision")
+	require.Len(t, files, 1, "only the completed chunk should be returned")
+	assert.FileExists(t, files[0].Path)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "tripica_report_oibl.oibl_customer_0001.parquet"), "the partial chunk should be removed")
+}
+
+func TestParquetExporter_UnsupportedCompression(t *testing.T) {
+	_, err := NewParquetExporter(nil, t.TempDir(), 10, ParquetOptions{Compression: "lzma"})
+	assert.Error(t, err)
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		input     string
+		precision int
+		scale     int
+		expected  string
+		wantErr   bool
+	}{
+		{"119.00", 12, 2, "11900", false},
+		{"-0.5", 12, 2, "-50", false},
+		{"0", 12, 2, "0", false},
+		{"1.005", 12, 2, "100", false},
+		{"1.015", 12, 2, "102", false},
+		{"-2.6751", 12, 2, "-268", false},
+		{"0.333333333333333333333333", 12, 2, "33", false},
+		{"99.995", 4, 2, "", true},
+		{"12345678901", 10, 0, "", true},
+		{"12345678901234567890.5", 38, 18, "12345678901234567890500000000000000000", false},
+		{"-1", 38, 18, "-1000000000000000000", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			n, err := parseDecimal(tt.input, tt.precision, tt.scale)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, n.String())
+		})
+	}
+}
+
+func TestTwosComplement(t *testing.T) {
+	assert.Equal(t, []byte{0x00, 0x00, 0x01, 0x00}, twosComplement(big.NewInt(256), 4))
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xff}, twosComplement(big.NewInt(-1), 4))
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xce}, twosComplement(big.NewInt(-50), 4))
+	assert.Equal(t, 16, decimalByteWidth(38))
+}
+
+// Helper Functions
+
+func openParquet(t *testing.T, path string) *parquet.File {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+
+	info, err := f.Stat()
+	require.NoError(t, err)
+
+	pf, err := parquet.OpenFile(f, info.Size())
+	require.NoError(t, err)
+	return pf
+}
+
+func readParquetRows(t *testing.T, path string, n int) []parquet.Row {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	reader := parquet.NewReader(f)
+	defer reader.Close()
+
+	rows := make([]parquet.Row, n)
+	read, _ := reader.ReadRows(rows)
+	require.Equal(t, n, read)
+	return rows
+}
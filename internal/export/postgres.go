@@ -0,0 +1,377 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/rs/zerolog/log"
+)
+
+// Supported load strategies of the Postgres sink.
+const (
+	// LoadTruncate empties the target table and loads it in one transaction.
+	LoadTruncate = "truncate"
+	// LoadSwap loads a copy of the target table and swaps it in.
+	LoadSwap = "swap"
+	// LoadUpsert inserts new rows and updates existing ones by key.
+	LoadUpsert = "upsert"
+)
+
+// SinkPostgres selects the Postgres sink.
+const SinkPostgres = "postgres"
+
+// PostgresOptions configure the Postgres sink.
+type PostgresOptions struct {
+	// Schema of the target tables, public if empty.
+	Schema string
+	// Strategy is truncate (default), swap or upsert.
+	Strategy string
+	// Keys are the key columns of the target tables for upsert.
+	Keys []string
+}
+
+// PostgresSink replicates exported tables into another PostgreSQL database
+// by piping COPY TO STDOUT of the source into COPY FROM STDIN of the target.
+type PostgresSink struct {
+	src      *sql.DB
+	copySrc  CopySource
+	target   *sql.DB
+	schema   string
+	strategy string
+	keys     []string
+
+	// copyFrom runs COPY FROM STDIN on conn, replaced in tests.
+	copyFrom func(ctx context.Context, conn *sql.Conn, r io.Reader, sql string) (int64, error)
+}
+
+// NewPostgresSink returns a sink copying tables from the source database,
+// read through src and copySrc, into the target database. Both have to be
+// backed by pgx.
+func NewPostgresSink(src *sql.DB, copySrc CopySource, target *sql.DB, opts PostgresOptions) (*PostgresSink, error) {
+	strategy := opts.Strategy
+	switch strategy {
+	case "", LoadTruncate:
+		strategy = LoadTruncate
+	case LoadSwap:
+	case LoadUpsert:
+		if len(opts.Keys) == 0 {
+			return nil, fmt.Errorf("load strategy %s requires key columns", LoadUpsert)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported load strategy %q", strategy)
+	}
+	schema := opts.Schema
+	if schema == "" {
+		schema = "public"
+	}
+
+	return &PostgresSink{
+		src:      src,
+		copySrc:  copySrc,
+		target:   target,
+		schema:   schema,
+		strategy: strategy,
+		keys:     opts.Keys,
+		copyFrom: pgxCopyFrom,
+	}, nil
+}
+
+// ExportTable copies all rows of tableName into the table of the same name
+// in the target schema, creating it from the source columns if it does not
+// exist yet. The load is a single transaction, so readers either see the
+// previous or the new content. The returned file names the target table.
+func (s *PostgresSink) ExportTable(ctx context.Context, tableName, system string) ([]File, error) {
+	targetName := tableName[strings.LastIndex(tableName, ".")+1:]
+	log.Ctx(ctx).Info().
+		Str("table", tableName).
+		Str("system", system).
+		Str("target", s.schema+"."+targetName).
+		Str("strategy", s.strategy).
+		Msg("Copying table to Postgres")
+
+	columns, err := s.sourceColumns(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := s.target.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire target connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to release target connection")
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback() // No-op after commit
+	}()
+
+	load, err := s.prepare(ctx, tx, targetName, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.copyRows(ctx, conn, tableName, columns, load)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.finish(ctx, tx, targetName, columns, load); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit load of %s: %w", targetName, err)
+	}
+
+	log.Ctx(ctx).Info().Str("table", tableName).Int64("rows", count).Msg("Copy completed")
+	return []File{{Path: s.schema + "." + targetName, System: system, Table: tableName, Rows: count}}, nil
+}
+
+// sourceColumns returns the columns of the source table.
+func (s *PostgresSink) sourceColumns(ctx context.Context, tableName string) ([]*sql.ColumnType, error) {
+	// #nosec G201 -- tableName is validated and schema-qualified, not user input
+	rows, err := s.src.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", tableName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table %s: %w", tableName, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to close rows")
+		}
+	}()
+
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	return columns, nil
+}
+
+// loadTable is the table COPY writes into. Schema is empty for temporary
+// tables.
+type loadTable struct {
+	schema string
+	name   string
+}
+
+func (t loadTable) identifier() pgx.Identifier {
+	if t.schema == "" {
+		return pgx.Identifier{t.name}
+	}
+	return pgx.Identifier{t.schema, t.name}
+}
+
+// prepare sets up the table the rows are loaded into.
+func (s *PostgresSink) prepare(ctx context.Context, tx *sql.Tx, targetName string, columns []*sql.ColumnType) (loadTable, error) {
+	target := s.qualified(targetName)
+
+	switch s.strategy {
+	case LoadSwap:
+		load := loadTable{schema: s.schema, name: targetName + "__load"}
+		exists, err := s.tableExists(ctx, tx, targetName)
+		if err != nil {
+			return load, err
+		}
+		stmts := []string{fmt.Sprintf("DROP TABLE IF EXISTS %s", s.qualified(load.name))}
+		if exists {
+			stmts = append(stmts, fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING ALL)", s.qualified(load.name), target))
+		} else {
+			stmts = append(stmts, createTableSQL(s.qualified(load.name), columns, nil))
+		}
+		return load, execAll(ctx, tx, stmts...)
+
+	case LoadUpsert:
+		load := loadTable{name: targetName + "__upsert"}
+		return load, execAll(ctx, tx,
+			createTableSQL(target, columns, s.keys),
+			fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", quoteIdentifier(load.name), target),
+		)
+
+	default:
+		return loadTable{schema: s.schema, name: targetName}, execAll(ctx, tx,
+			createTableSQL(target, columns, nil),
+			fmt.Sprintf("TRUNCATE %s", target),
+		)
+	}
+}
+
+// finish moves the loaded rows into the target table.
+func (s *PostgresSink) finish(ctx context.Context, tx *sql.Tx, targetName string, columns []*sql.ColumnType, load loadTable) error {
+	switch s.strategy {
+	case LoadSwap:
+		return execAll(ctx, tx,
+			fmt.Sprintf("DROP TABLE IF EXISTS %s", s.qualified(targetName)),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", s.qualified(load.name), quoteIdentifier(targetName)),
+		)
+	case LoadUpsert:
+		return execAll(ctx, tx, upsertSQL(s.qualified(targetName), quoteIdentifier(load.name), columns, s.keys))
+	default:
+		return nil
+	}
+}
+
+func (s *PostgresSink) tableExists(ctx context.Context, tx *sql.Tx, name string) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_tables WHERE schemaname = $1 AND tablename = $2)",
+		s.schema, name,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %w", name, err)
+	}
+	return exists, nil
+}
+
+func (s *PostgresSink) qualified(name string) string {
+	return pgx.Identifier{s.schema, name}.Sanitize()
+}
+
+// copyRows pipes the source table into load. COPY runs on conn, which
+// holds the open transaction.
+func (s *PostgresSink) copyRows(ctx context.Context, conn *sql.Conn, tableName string, columns []*sql.ColumnType, load loadTable) (int64, error) {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name()
+	}
+
+	// CSV tells NULL (unquoted) and empty strings ("") apart.
+	// #nosec G201 -- tableName is validated and schema-qualified, not user input
+	copyOut := fmt.Sprintf("COPY (SELECT * FROM %s) TO STDOUT WITH (FORMAT csv)", tableName)
+	copyIn := fmt.Sprintf("COPY %s (%s) FROM STDIN WITH (FORMAT csv)", load.identifier().Sanitize(), quoteIdentifiers(names))
+
+	pr, pw := io.Pipe()
+	type result struct {
+		rows int64
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rows, err := s.copySrc.CopyTo(ctx, pw, copyOut)
+		pw.CloseWithError(err)
+		done <- result{rows, err}
+	}()
+
+	loaded, err := s.copyFrom(ctx, conn, pr, copyIn)
+	// Unblock the source if the target gave up before reading everything.
+	pr.CloseWithError(err)
+	copied := <-done
+
+	if copied.err != nil {
+		return 0, fmt.Errorf("failed to copy from %s: %w", tableName, copied.err)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy into %s: %w", load.name, err)
+	}
+	if loaded != copied.rows {
+		return 0, fmt.Errorf("loaded %d rows into %s, but copied %d from %s", loaded, load.name, copied.rows, tableName)
+	}
+	return loaded, nil
+}
+
+// pgxCopyFrom runs COPY FROM STDIN on the pgx connection behind conn.
+func pgxCopyFrom(ctx context.Context, conn *sql.Conn, r io.Reader, sql string) (int64, error) {
+	var rows int64
+	err := conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("COPY requires a pgx connection, got %T", driverConn)
+		}
+		tag, err := c.Conn().PgConn().CopyFrom(ctx, r, sql)
+		rows = tag.RowsAffected()
+		return err
+	})
+	return rows, err
+}
+
+// createTableSQL creates table from the source columns unless it exists.
+func createTableSQL(table string, columns []*sql.ColumnType, keys []string) string {
+	defs := make([]string, 0, len(columns)+1)
+	for _, c := range columns {
+		defs = append(defs, quoteIdentifier(c.Name())+" "+columnType(c))
+	}
+	if len(keys) > 0 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteIdentifiers(keys)))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(defs, ", "))
+}
+
+// columnType returns the Postgres type of a source column.
+func columnType(c *sql.ColumnType) string {
+	name := c.DatabaseTypeName()
+	switch {
+	case name == "":
+		return "TEXT"
+	case strings.HasPrefix(name, "_"):
+		return name[1:] + "[]"
+	case name == "NUMERIC":
+		if precision, scale, ok := c.DecimalSize(); ok {
+			return fmt.Sprintf("NUMERIC(%d,%d)", precision, scale)
+		}
+	case name == "VARCHAR" || name == "BPCHAR":
+		if length, ok := c.Length(); ok && length > 0 {
+			if name == "BPCHAR" {
+				name = "CHAR"
+			}
+			return fmt.Sprintf("%s(%d)", name, length)
+		}
+	}
+	return name
+}
+
+// upsertSQL merges the loaded rows into table by the key columns.
+func upsertSQL(table, load string, columns []*sql.ColumnType, keys []string) string {
+	isKey := map[string]bool{}
+	for _, k := range keys {
+		isKey[k] = true
+	}
+
+	names := make([]string, len(columns))
+	var updates []string
+	for i, c := range columns {
+		names[i] = c.Name()
+		if !isKey[c.Name()] {
+			col := quoteIdentifier(c.Name())
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+		}
+	}
+
+	action := "DO NOTHING"
+	if len(updates) > 0 {
+		action = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	cols := quoteIdentifiers(names)
+	return fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT (%s) %s",
+		table, cols, cols, load, quoteIdentifiers(keys), action)
+}
+
+func quoteIdentifier(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+func quoteIdentifiers(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quoteIdentifier(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func execAll(ctx context.Context, tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to execute %q: %w", stmt, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/rs/zerolog/log"
+)
+
+// ReconcileError reports differences between a table and its export, or
+// between exported chunks and the uploaded objects.
+type ReconcileError struct {
+	Subject string
+	Diffs   []string
+}
+
+func (e *ReconcileError) Error() string {
+	return fmt.Sprintf("reconciliation of %s failed: %s", e.Subject, strings.Join(e.Diffs, "; "))
+}
+
+// Totals are the row count and column sums of a table or an export.
+type Totals struct {
+	Rows int64
+	Sums map[string]*big.Rat
+}
+
+// diff lists the differences of written from the table totals t.
+func (t Totals) diff(written Totals) []string {
+	var diffs []string
+	if t.Rows != written.Rows {
+		diffs = append(diffs, fmt.Sprintf("rows: table %d, written %d", t.Rows, written.Rows))
+	}
+
+	columns := make([]string, 0, len(t.Sums))
+	for column := range t.Sums {
+		columns = append(columns, column)
+	}
+	slices.Sort(columns)
+
+	for _, column := range columns {
+		want, got := t.Sums[column], written.Sums[column]
+		if got == nil {
+			got = new(big.Rat)
+		}
+		if want.Cmp(got) != 0 {
+			diffs = append(diffs, fmt.Sprintf("sum(%s): table %s, written %s",
+				column, want.FloatString(scale(want, got)), got.FloatString(scale(want, got))))
+		}
+	}
+	return diffs
+}
+
+// scale returns the decimal places needed to show a and b exactly. Sums of
+// decimal values always have a finite representation.
+func scale(a, b *big.Rat) int {
+	places := 0
+	for _, r := range []*big.Rat{a, b} {
+		pow := big.NewInt(1)
+		n := 0
+		for ; n < 18 && new(big.Int).Mod(pow, r.Denom()).Sign() != 0; n++ {
+			pow.Mul(pow, big.NewInt(10))
+		}
+		places = max(places, n)
+	}
+	return places
+}
+
+// WithReconcile makes every export count the rows of the table, and sum the
+// given columns where the table has them, before exporting it. The totals
+// are compared with the rows written and the sums of the written values;
+// a mismatch fails the export with a *ReconcileError.
+func (e *CSVExporter) WithReconcile(sumColumns []string) *CSVExporter {
+	e.reconcile = true
+	e.sumColumns = sumColumns
+	return e
+}
+
+// WithReconcile reconciles every export like CSVExporter.WithReconcile.
+func (e *ParquetExporter) WithReconcile(sumColumns []string) *ParquetExporter {
+	e.reconcile = true
+	e.sumColumns = sumColumns
+	return e
+}
+
+// queryer runs queries on the database or in a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// reconciled takes the totals of table and runs export in one read-only
+// REPEATABLE READ transaction, so rows committed in between cannot make
+// them differ, and reconciles the exported files with the totals.
+func reconciled(ctx context.Context, db *sql.DB, table string, sumColumns []string,
+	export func(tx *sql.Tx, sums *columnSums) ([]File, error)) ([]File, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin export of %s: %w", table, err)
+	}
+	defer func() {
+		_ = tx.Rollback() // Read-only, nothing to commit
+	}()
+
+	want, summed, err := tableTotals(ctx, tx, table, sumColumns)
+	if err != nil {
+		return nil, err
+	}
+	sums := newColumnSums(summed)
+	files, err := export(tx, sums)
+	if err != nil {
+		return files, err
+	}
+	return files, reconcile(ctx, table, want, files, sums)
+}
+
+// exportSnapshot exports the snapshot of tx for use by other connections,
+// see CopySource.CopyToSnapshot. It is valid while tx is open.
+func exportSnapshot(ctx context.Context, tx *sql.Tx) (string, error) {
+	var snapshot string
+	if err := tx.QueryRowContext(ctx, "SELECT pg_export_snapshot()").Scan(&snapshot); err != nil {
+		return "", fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// tableTotals counts the rows of table and sums the sumColumns it has.
+func tableTotals(ctx context.Context, q queryer, table string, sumColumns []string) (Totals, []string, error) {
+	// #nosec G201 -- table is validated and schema-qualified, not user input
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", table))
+	if err != nil {
+		return Totals{}, nil, fmt.Errorf("failed to query columns of %s: %w", table, err)
+	}
+	columns, err := rows.Columns()
+	_ = rows.Close() // Ignore close error, the result is empty
+	if err != nil {
+		return Totals{}, nil, fmt.Errorf("failed to query columns of %s: %w", table, err)
+	}
+
+	var summed []string
+	selects := []string{"count(*)"}
+	for _, column := range sumColumns {
+		if slices.Contains(columns, column) {
+			summed = append(summed, column)
+			selects = append(selects, fmt.Sprintf("coalesce(sum(%s), 0)::text", quoteIdentifier(column)))
+		}
+	}
+
+	sums := make([]string, len(summed))
+	dest := []any{new(int64)}
+	for i := range sums {
+		dest = append(dest, &sums[i])
+	}
+	// #nosec G201 -- table is validated, columns are quoted
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), table)
+	if err := q.QueryRowContext(ctx, query).Scan(dest...); err != nil {
+		return Totals{}, nil, fmt.Errorf("failed to count %s: %w", table, err)
+	}
+
+	totals := Totals{Rows: *dest[0].(*int64), Sums: make(map[string]*big.Rat, len(summed))}
+	for i, column := range summed {
+		sum, ok := new(big.Rat).SetString(sums[i])
+		if !ok {
+			return Totals{}, nil, fmt.Errorf("failed to parse sum(%s) %q of %s", column, sums[i], table)
+		}
+		totals.Sums[column] = sum
+	}
+	return totals, summed, nil
+}
+
+// columnSums adds up columns of the exported records.
+type columnSums struct {
+	columns []string
+	index   []int
+	sums    map[string]*big.Rat
+}
+
+func newColumnSums(columns []string) *columnSums {
+	s := &columnSums{columns: columns, sums: make(map[string]*big.Rat, len(columns))}
+	for _, column := range columns {
+		s.sums[column] = new(big.Rat)
+	}
+	return s
+}
+
+// header locates the summed columns in the header record.
+func (s *columnSums) header(record []string) error {
+	s.index = s.index[:0]
+	for _, column := range s.columns {
+		idx := slices.Index(record, column)
+		if idx < 0 {
+			return fmt.Errorf("column %s not exported", column)
+		}
+		s.index = append(s.index, idx)
+	}
+	return nil
+}
+
+func (s *columnSums) add(record []string) error {
+	for i, idx := range s.index {
+		if idx >= len(record) {
+			continue
+		}
+		if err := s.addValue(i, record[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addValue adds value to the sum of the i-th column. Empty values are NULL.
+func (s *columnSums) addValue(i int, value string) error {
+	if value == "" {
+		return nil
+	}
+	v, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return fmt.Errorf("column %s: %q is not a number", s.columns[i], value)
+	}
+	s.sums[s.columns[i]].Add(s.sums[s.columns[i]], v)
+	return nil
+}
+
+// csvSums sums columns of a CSV stream with a header line while it is
+// written, e.g. the output of COPY TO STDOUT.
+type csvSums struct {
+	*columnSums
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newCSVSums(sums *columnSums) *csvSums {
+	pr, pw := io.Pipe()
+	s := &csvSums{columnSums: sums, pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := s.read(pr)
+		_ = pr.CloseWithError(err) // Unblocks the writer if reading failed
+		s.done <- err
+	}()
+	return s
+}
+
+func (s *csvSums) read(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1
+
+	record, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.header(record); err != nil {
+		return err
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.add(record); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *csvSums) Write(p []byte) (int, error) {
+	return s.pw.Write(p)
+}
+
+// Close waits until all written records are summed.
+func (s *csvSums) Close() error {
+	_ = s.pw.Close() // Always succeeds for a PipeWriter
+	return <-s.done
+}
+
+// reconcile compares the table totals with the written files and sums.
+func reconcile(ctx context.Context, table string, want Totals, files []File, sums *columnSums) error {
+	written := Totals{}
+	for _, f := range files {
+		written.Rows += f.Rows
+	}
+	if sums != nil {
+		written.Sums = sums.sums
+	}
+
+	if diffs := want.diff(written); len(diffs) > 0 {
+		return &ReconcileError{Subject: table, Diffs: diffs}
+	}
+
+	event := log.Ctx(ctx).Info().Str("table", table).Int64("rows", want.Rows)
+	for column, sum := range want.Sums {
+		event = event.Str("sum_"+column, sum.FloatString(scale(sum, sum)))
+	}
+	event.Msg("Export reconciled")
+	return nil
+}
+
+// Verify compares the size of the uploaded object of every file with the
+// bytes written. Chunks without a recorded size, e.g. Parquet files, are
+// compared with the local file.
+func (u *S3Uploader) Verify(ctx context.Context, files []File) error {
+	var diffs []string
+	for _, f := range files {
+		want := f.Bytes
+		if want == 0 {
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				return fmt.Errorf("failed to stat %s: %w", f.Path, err)
+			}
+			want = info.Size()
+		}
+
+		key := u.objectKey(filepath.Base(f.Path), ObjectMeta{System: f.System, Table: f.Table})
+		out, err := u.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(u.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			diffs = append(diffs, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		if got := aws.ToInt64(out.ContentLength); got != want {
+			diffs = append(diffs, fmt.Sprintf("%s: written %d bytes, uploaded %d", key, want, got))
+		}
+	}
+
+	if len(diffs) > 0 {
+		return &ReconcileError{Subject: "uploaded objects", Diffs: diffs}
+	}
+	log.Ctx(ctx).Info().Int("objects", len(files)).Msg("Uploaded object sizes verified")
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,233 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVExporter_Reconcile(t *testing.T) {
+	// Setup
+	db, mock := newReconcileMock(t, 3, "60.50")
+	mock.ExpectQuery("SELECT * FROM results").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "amount_gross"}).
+			AddRow(1, "10.25").
+			AddRow(2, "50.25").
+			AddRow(3, nil))
+	mock.ExpectRollback()
+
+	exporter := NewCSVExporter(db, t.TempDir(), 2).WithReconcile([]string{"amount_gross", "amount_net"})
+
+	// Execute
+	files, err := exporter.ExportTable(context.Background(), "results", "tripica")
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, int64(len("id,amount_gross\n1,10.25\n2,50.25\n")), files[0].Bytes)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCSVExporter_ReconcileRowMismatch(t *testing.T) {
+	// Setup
+	db, mock := newReconcileMock(t, 3, "60.50")
+	mock.ExpectQuery("SELECT * FROM results").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "amount_gross"}).
+			AddRow(1, "10.25").
+			AddRow(2, "50.25"))
+
+	exporter := NewCSVExporter(db, t.TempDir(), 10).WithReconcile([]string{"amount_gross"})
+
+	// Execute
+	files, err := exporter.ExportTable(context.Background(), "results", "tripica")
+
+	// Assert
+	var reconcileErr *ReconcileError
+	require.True(t, errors.As(err, &reconcileErr), "expected ReconcileError, got %v", err)
+	assert.Equal(t, "results", reconcileErr.Subject)
+	assert.Equal(t, []string{"rows: table 3, written 2"}, reconcileErr.Diffs)
+	assert.Len(t, files, 1, "written files should be returned for the manifest")
+}
+
+func TestCSVExporter_ReconcileSumMismatch(t *testing.T) {
+	// Setup
+	db, mock := newReconcileMock(t, 2, "60.50")
+	mock.ExpectQuery("SELECT * FROM results").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "amount_gross"}).
+			AddRow(1, "10.25").
+			AddRow(2, "50.2"))
+
+	exporter := NewCSVExporter(db, t.TempDir(), 10).WithReconcile([]string{"amount_gross"})
+
+	// Execute
+	_, err := exporter.ExportTable(context.Background(), "results", "tripica")
+
+	// Assert
+	var reconcileErr *ReconcileError
+	require.True(t, errors.As(err, &reconcileErr), "expected ReconcileError, got %v", err)
+	assert.Equal(t, []string{"sum(amount_gross): table 60.50, written 60.45"}, reconcileErr.Diffs)
+}
+
+func TestCSVExporter_ReconcileCopy(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "match",
+			data: "id,amount_gross\n1,10.25\n2,\"50.25\"\n3,\n",
+		},
+		{
+			name:    "sum mismatch",
+			data:    "id,amount_gross\n1,10.25\n2,50.00\n3,\n",
+			wantErr: "sum(amount_gross): table 60.50, written 60.25",
+		},
+		{
+			name:    "not a number",
+			data:    "id,amount_gross\n1,10.25\n2,n/a\n3,\n",
+			wantErr: `"n/a" is not a number`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			db, mock := newReconcileMock(t, 3, "60.50")
+			mock.ExpectQuery("SELECT pg_export_snapshot()").
+				WillReturnRows(sqlmock.NewRows([]string{"pg_export_snapshot"}).AddRow("00000003-0000001B-1"))
+			mock.ExpectRollback()
+			src := &fakeCopySource{data: tt.data, rows: 3, step: 7}
+			exporter := NewCSVExporter(db, t.TempDir(), 2).WithCopy(src).WithReconcile([]string{"amount_gross"})
+
+			// Execute
+			files, err := exporter.ExportTable(context.Background(), "results", "tripica")
+
+			// Assert
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, files, 2)
+			assert.Equal(t, int64(len(readFile(t, files[1].Path))), files[1].Bytes)
+			assert.Equal(t, "00000003-0000001B-1", src.snapshot, "COPY should see the snapshot of the totals")
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestParquetExporter_Reconcile(t *testing.T) {
+	tests := []struct {
+		name      string
+		amounts   []any
+		wantDiffs []string
+	}{
+		{
+			name:    "match",
+			amounts: []any{[]byte("10.25"), []byte("50.25"), nil},
+		},
+		{
+			name:      "row mismatch",
+			amounts:   []any{[]byte("10.25"), []byte("50.25")},
+			wantDiffs: []string{"rows: table 3, written 2"},
+		},
+		{
+			name:      "sum mismatch",
+			amounts:   []any{[]byte("10.25"), []byte("50.00"), nil},
+			wantDiffs: []string{"sum(amount_gross): table 60.50, written 60.25"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			db, mock := newReconcileMock(t, 3, "60.50")
+			rows := sqlmock.NewRowsWithColumnDefinition(
+				sqlmock.NewColumn("id").OfType("INT8", int64(0)),
+				sqlmock.NewColumn("amount_gross").OfType("NUMERIC", "").WithPrecisionAndScale(12, 2),
+			)
+			for i, amount := range tt.amounts {
+				rows.AddRow(int64(i+1), amount)
+			}
+			mock.ExpectQuery("SELECT * FROM results").WillReturnRows(rows)
+			mock.ExpectRollback()
+
+			exporter, err := NewParquetExporter(db, t.TempDir(), 2, ParquetOptions{})
+			require.NoError(t, err)
+			exporter.WithReconcile([]string{"amount_gross", "amount_net"})
+
+			// Execute
+			files, err := exporter.ExportTable(context.Background(), "results", "tripica")
+
+			// Assert
+			assert.NoError(t, mock.ExpectationsWereMet(), "totals and export should share one transaction")
+			if tt.wantDiffs == nil {
+				require.NoError(t, err)
+				require.Len(t, files, 2)
+				return
+			}
+			var reconcileErr *ReconcileError
+			require.True(t, errors.As(err, &reconcileErr), "expected ReconcileError, got %v", err)
+			assert.Equal(t, tt.wantDiffs, reconcileErr.Diffs)
+			assert.NotEmpty(t, files, "written files should be returned for the manifest")
+		})
+	}
+}
+
+func TestS3Uploader_Verify(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	uploader := newS3UploaderWithClient(client, "exports", "enercity/dev")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tripica_results_0000.csv")
+	require.NoError(t, os.WriteFile(path, []byte("id\n1\n2\n"), 0o600))
+	files := []File{{Path: path, System: "tripica", Table: "results", Rows: 2}}
+	require.NoError(t, uploader.UploadFiles(context.Background(), files))
+
+	// Execute & Assert
+	require.NoError(t, uploader.Verify(context.Background(), files))
+
+	_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("exports"),
+		Key:    aws.String("enercity/dev/tripica_results_0000.csv"),
+		Body:   strings.NewReader("id\n1\n"),
+	})
+	require.NoError(t, err)
+
+	err = uploader.Verify(context.Background(), files)
+	var reconcileErr *ReconcileError
+	require.True(t, errors.As(err, &reconcileErr), "expected ReconcileError, got %v", err)
+	assert.Equal(t, []string{"enercity/dev/tripica_results_0000.csv: written 7 bytes, uploaded 5"}, reconcileErr.Diffs)
+}
+
+// Helper Functions
+
+// newReconcileMock expects the export transaction and the column and
+// totals queries of table results with an amount_gross column.
+func newReconcileMock(t *testing.T, rows int64, sum string) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT * FROM results LIMIT 0").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "amount_gross"}))
+	mock.ExpectQuery(`SELECT count(*), coalesce(sum("amount_gross"), 0)::text FROM results`).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "sum"}).AddRow(rows, sum))
+	return db, mock
+}
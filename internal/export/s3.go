@@ -2,38 +2,157 @@ package export
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	appconfig "github.com/enercity/billing-data-aggregator/internal/config"
+	"github.com/enercity/billing-data-aggregator/internal/retry"
 	"github.com/rs/zerolog/log"
 )
 
 type S3Uploader struct {
-	client *s3.Client
-	bucket string
-	prefix string
+	client   *s3.Client
+	bucket   string
+	prefix   string
+	partSize int
+
+	multipartThreshold int64
+	concurrency        int
+	maxPartRetries     int
+	retryBaseDelay     time.Duration
+	retryMaxDelay      time.Duration
+
+	settings    ObjectSettings
+	run         RunInfo
+	keyTemplate string
+
+	mu          sync.Mutex
+	objects     []UploadedObject
+	manifestKey string
 }
 
-func NewS3Uploader(ctx context.Context, region, bucket, prefix string) (*S3Uploader, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+// NewS3Uploader creates an uploader from the S3 configuration. A custom
+// endpoint (MinIO, LocalStack) is honored together with path-style
+// addressing, static credentials take precedence over the default chain,
+// and an optional role is assumed on top of the resolved credentials.
+func NewS3Uploader(ctx context.Context, cfg appconfig.S3Config, prefix string) (*S3Uploader, error) {
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(cfg.Region)}
+
+	if cfg.AccessKeyID != "" && cfg.SecretAccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		))
+	}
+
+	if cfg.InsecureSkipVerify {
+		log.Ctx(ctx).Warn().Str("endpoint", cfg.URL).Msg("TLS verification for S3 is disabled")
+		opts = append(opts, awsconfig.WithHTTPClient(
+			awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+				if tr.TLSClientConfig == nil {
+					tr.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+				}
+				tr.TLSClientConfig.InsecureSkipVerify = true // #nosec G402 -- opt-in for local endpoints only
+			}),
+		))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
+	if cfg.AssumeRoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "billing-data-aggregator"
+		})
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.URL != "" {
+			o.BaseEndpoint = aws.String(cfg.URL)
+			// S3 stand-ins often can't decode aws-chunked bodies with
+			// trailing checksums, so only send checksums where required.
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+	})
+
+	log.Ctx(ctx).Debug().
+		Str("region", cfg.Region).
+		Str("bucket", cfg.Bucket).
+		Str("endpoint", cfg.URL).
+		Bool("path_style", cfg.UsePathStyle).
+		Bool("static_credentials", cfg.AccessKeyID != "").
+		Str("assume_role_arn", cfg.AssumeRoleARN).
+		Msg("S3 client configured")
+
+	settings := ObjectSettings{
+		SSE:            cfg.SSE,
+		KMSKeyID:       cfg.SSEKMSKeyID,
+		StorageClass:   cfg.StorageClass,
+		RetentionClass: cfg.RetentionClass,
+	}
+	if err := validateSettings(settings); err != nil {
+		return nil, err
+	}
+
+	uploader, err := newS3UploaderWithClient(client, cfg.Bucket, prefix).WithKeyTemplate(cfg.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	uploader.settings = settings
+	return uploader.WithMultipart(
+		int64(cfg.MultipartThresholdMB)<<20,
+		int64(cfg.PartSizeMB)<<20,
+		cfg.UploadConcurrency,
+		cfg.MaxPartRetries,
+	), nil
+}
+
+func newS3UploaderWithClient(client *s3.Client, bucket, prefix string) *S3Uploader {
 	return &S3Uploader{
-		client: s3.NewFromConfig(cfg),
-		bucket: bucket,
-		prefix: prefix,
-	}, nil
+		client:             client,
+		bucket:             bucket,
+		prefix:             prefix,
+		partSize:           DefaultPartSize,
+		multipartThreshold: DefaultMultipartThreshold,
+		concurrency:        DefaultUploadConcurrency,
+		maxPartRetries:     DefaultPartRetries,
+		retryBaseDelay:     time.Second,
+		retryMaxDelay:      30 * time.Second,
+	}
+}
+
+// WithRun tags and annotates every uploaded object with the run identity.
+func (u *S3Uploader) WithRun(run RunInfo) *S3Uploader {
+	u.run = run
+	return u
 }
 
 func (u *S3Uploader) UploadFile(ctx context.Context, localPath string) error {
-	log.Info().Str("file", localPath).Msg("Uploading to S3")
+	return u.uploadFile(ctx, localPath, ObjectMeta{})
+}
+
+func (u *S3Uploader) uploadFile(ctx context.Context, localPath string, meta ObjectMeta) error {
+	log.Ctx(ctx).Info().Str("file", localPath).Msg("Uploading to S3")
 
 	// #nosec G304 -- localPath comes from CSVExporter output, not user input
 	file, err := os.Open(localPath)
@@ -42,46 +161,113 @@ func (u *S3Uploader) UploadFile(ctx context.Context, localPath string) error {
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
-			log.Error().Err(err).Msg("Failed to close file")
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to close file")
 		}
 	}()
 
-	key := filepath.Join(u.prefix, filepath.Base(localPath))
-	maxRetries := 3
-	var lastErr error
-
-	for retry := 0; retry < maxRetries; retry++ {
-		if retry > 0 {
-			waitTime := time.Duration(retry*5) * time.Second
-			log.Warn().Int("retry", retry).Dur("wait", waitTime).Msg("Retrying upload")
-			time.Sleep(waitTime)
-		}
+	key := u.objectKey(filepath.Base(localPath), meta)
 
-		_, lastErr = u.client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: aws.String(u.bucket),
-			Key:    aws.String(key),
-			Body:   file,
-		})
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	meta.SHA256, err = hashFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+	attrs := u.attributes(key, meta)
 
-		if lastErr == nil {
-			log.Info().Str("bucket", u.bucket).Str("key", key).Msg("Upload successful")
-			return nil
+	if info.Size() >= u.multipartThreshold {
+		if err := u.uploadMultipart(ctx, key, file, info.Size(), attrs); err != nil {
+			return err
 		}
+		u.record(key, meta, info.Size(), meta.SHA256, attrs)
+		return nil
+	}
 
-		if _, err := file.Seek(0, 0); err != nil {
-			return fmt.Errorf("failed to reset file: %w", err)
+	err = retry.Do(ctx, u.retryPolicy("S3 upload"), func(ctx context.Context) error {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return retry.Permanent(fmt.Errorf("failed to reset file: %w", err))
 		}
+		_, err := u.client.PutObject(ctx, u.putObjectInput(key, file, attrs), withoutSDKRetries)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
 	}
 
-	return fmt.Errorf("upload failed after %d retries: %w", maxRetries, lastErr)
+	log.Ctx(ctx).Info().Str("bucket", u.bucket).Str("key", key).Msg("Upload successful")
+	u.record(key, meta, info.Size(), meta.SHA256, attrs)
+	return nil
 }
 
-func (u *S3Uploader) UploadFiles(ctx context.Context, files []string) error {
+func (u *S3Uploader) UploadFiles(ctx context.Context, files []File) error {
 	for _, file := range files {
-		if err := u.UploadFile(ctx, file); err != nil {
-			return fmt.Errorf("failed to upload %s: %w", file, err)
+		meta := ObjectMeta{System: file.System, Table: file.Table, Rows: file.Rows}
+		if err := u.uploadFile(ctx, file.Path, meta); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", file.Path, err)
+		}
+	}
+	log.Ctx(ctx).Info().Int("count", len(files)).Msg("All files uploaded")
+	return nil
+}
+
+// Put streams body into the object name laid out by the key template. Bodies larger
+// than a single part are sent as a multipart upload, which is aborted if
+// reading body or uploading a part fails. Since metadata has to be sent
+// before the body, the SHA-256 of multipart uploads is only recorded in the
+// run manifest.
+func (u *S3Uploader) Put(ctx context.Context, name string, body io.Reader, meta ObjectMeta) error {
+	key := u.objectKey(name, meta)
+	log.Ctx(ctx).Info().Str("bucket", u.bucket).Str("key", key).Msg("Streaming upload to S3")
+
+	hash := sha256.New()
+	body = io.TeeReader(body, hash)
+
+	buf := make([]byte, u.partSize)
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Fits into a single part, no multipart upload needed.
+		meta.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		attrs := u.attributes(key, meta)
+		if err := u.putBytes(ctx, key, buf[:n], attrs); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", key, err)
+		}
+		log.Ctx(ctx).Info().Str("bucket", u.bucket).Str("key", key).Msg("Upload successful")
+		u.record(key, meta, int64(n), meta.SHA256, attrs)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	attrs := u.attributes(key, meta)
+	mu, err := u.startMultipart(ctx, key, attrs)
+	if err != nil {
+		return err
+	}
+
+	var parts []types.CompletedPart
+	var size int64
+	for partNumber := int32(1); n > 0; partNumber++ {
+		part, err := u.uploadPart(ctx, mu, partNumber, buf[:n])
+		if err != nil {
+			return mu.abort(err)
+		}
+		parts = append(parts, part)
+		size += int64(n)
+
+		n, err = io.ReadFull(body, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return mu.abort(fmt.Errorf("failed to read %s: %w", key, err))
 		}
 	}
-	log.Info().Int("count", len(files)).Msg("All files uploaded")
+
+	if err := mu.complete(ctx, parts); err != nil {
+		return err
+	}
+
+	log.Ctx(ctx).Info().Str("bucket", u.bucket).Str("key", key).Int("parts", len(parts)).Msg("Upload successful")
+	u.record(key, meta, size, hex.EncodeToString(hash.Sum(nil)), attrs)
 	return nil
 }
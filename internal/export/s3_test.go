@@ -0,0 +1,90 @@
+package export
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/enercity/billing-data-aggregator/internal/config"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewS3Uploader_CustomEndpoint(t *testing.T) {
+	// Setup
+	backend := s3mem.New()
+	require.NoError(t, backend.CreateBucket("exports"))
+	server := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	defer server.Close()
+
+	cfg := config.S3Config{
+		Region:             "eu-central-1",
+		Bucket:             "exports",
+		URL:                server.URL,
+		AccessKeyID:        "minio",
+		SecretAccessKey:    "minio-secret",
+		UsePathStyle:       true,
+		InsecureSkipVerify: true,
+	}
+
+	// Execute
+	uploader, err := NewS3Uploader(context.Background(), cfg, "enercity/dev")
+	require.NoError(t, err)
+	err = uploader.UploadFile(context.Background(), writeTempFile(t, "result.csv", []byte("id\n1\n")))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "id\n1\n", getObject(t, uploader.client, "exports", "enercity/dev/result.csv"))
+
+	creds, err := uploader.client.Options().Credentials.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "minio", creds.AccessKeyID, "Static credentials should be used")
+}
+
+func TestNewS3Uploader_VerifiesTLSByDefault(t *testing.T) {
+	// Setup
+	server := httptest.NewTLSServer(gofakes3.New(s3mem.New()).Server())
+	defer server.Close()
+
+	cfg := config.S3Config{
+		Region:          "eu-central-1",
+		Bucket:          "exports",
+		URL:             server.URL,
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio-secret",
+		UsePathStyle:    true,
+	}
+
+	// Execute
+	uploader, err := NewS3Uploader(context.Background(), cfg, "")
+	require.NoError(t, err)
+	_, err = uploader.client.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+
+	// Assert
+	assert.Error(t, err, "Self-signed certificate should be rejected")
+}
+
+func TestNewS3Uploader_AppliesMultipartSettings(t *testing.T) {
+	cfg := config.S3Config{
+		Region:               "eu-central-1",
+		Bucket:               "exports",
+		AccessKeyID:          "key",
+		SecretAccessKey:      "secret",
+		AssumeRoleARN:        "arn:aws:iam::123456789012:role/exporter",
+		MultipartThresholdMB: 128,
+		PartSizeMB:           32,
+		UploadConcurrency:    8,
+	}
+
+	uploader, err := NewS3Uploader(context.Background(), cfg, "")
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(128<<20), uploader.multipartThreshold)
+	assert.Equal(t, 32<<20, uploader.partSize)
+	assert.Equal(t, 8, uploader.concurrency)
+	assert.False(t, uploader.client.Options().UsePathStyle)
+	assert.Nil(t, uploader.client.Options().BaseEndpoint)
+}
@@ -0,0 +1,142 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	appconfig "github.com/enercity/billing-data-aggregator/internal/config"
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPSink delivers chunks into a directory on an SFTP server, e.g. the
+// drop of a client's SAP team.
+type SFTPSink struct {
+	ssh       *ssh.Client
+	client    *sftp.Client
+	remoteDir string
+}
+
+// NewSFTPSink connects to the SFTP server. Authentication uses the password
+// and/or private key; the host key is checked against the known hosts file
+// unless explicitly disabled.
+func NewSFTPSink(ctx context.Context, cfg appconfig.SFTPConfig) (*SFTPSink, error) {
+	var auth []ssh.AuthMethod
+	if cfg.PrivateKeyFile != "" {
+		// #nosec G304 -- the key file is set by the operator
+		key, err := os.ReadFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SFTP private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SFTP private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+
+	var hostKey ssh.HostKeyCallback
+	switch {
+	case cfg.KnownHostsFile != "":
+		callback, err := knownhosts.New(cfg.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read known hosts: %w", err)
+		}
+		hostKey = callback
+	case cfg.InsecureIgnoreHostKey:
+		log.Ctx(ctx).Warn().Str("host", cfg.Host).Msg("SFTP host key verification is disabled")
+		hostKey = ssh.InsecureIgnoreHostKey() // #nosec G106 -- opt-in for local servers only
+	default:
+		return nil, fmt.Errorf("SFTP host key verification requires a known hosts file")
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SFTP server %s: %w", addr, err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		_ = conn.Close() // Ignore close error during error handling
+		return nil, fmt.Errorf("failed to establish SSH connection to %s: %w", addr, err)
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close() // Ignore close error during error handling
+		return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+
+	log.Ctx(ctx).Debug().Str("address", addr).Str("user", cfg.User).Str("remote_dir", cfg.RemoteDir).Msg("SFTP client configured")
+	return &SFTPSink{ssh: sshClient, client: client, remoteDir: cfg.RemoteDir}, nil
+}
+
+// Put uploads body to a temporary file next to name and renames it once it
+// is complete, so the recipient never picks up a partial file.
+func (s *SFTPSink) Put(ctx context.Context, name string, body io.Reader, _ ObjectMeta) error {
+	target := path.Join(s.remoteDir, name)
+	tmpPath := target + ".part"
+
+	if err := s.client.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("failed to create remote directory: %w", err)
+	}
+
+	file, err := s.client.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	cleanup := func() {
+		_ = file.Close()             // Ignore close error during error handling
+		_ = s.client.Remove(tmpPath) // Ignore remove error during error handling
+	}
+
+	if _, err := file.ReadFrom(ctxReader{ctx: ctx, r: body}); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+
+	if err := s.client.PosixRename(tmpPath, target); err != nil {
+		// Servers without the posix-rename extension refuse to replace
+		// an existing file.
+		_ = s.client.Remove(target) // Ignore error, target may not exist
+		if err := s.client.Rename(tmpPath, target); err != nil {
+			_ = s.client.Remove(tmpPath) // Ignore remove error during error handling
+			return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+		}
+	}
+
+	log.Ctx(ctx).Info().Str("file", target).Msg("Upload to SFTP successful")
+	return nil
+}
+
+// Close ends the SFTP session and the SSH connection.
+func (s *SFTPSink) Close() error {
+	if err := s.client.Close(); err != nil {
+		_ = s.ssh.Close() // Ignore close error during error handling
+		return err
+	}
+	return s.ssh.Close()
+}
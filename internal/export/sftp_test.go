@@ -0,0 +1,214 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	appconfig "github.com/enercity/billing-data-aggregator/internal/config"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSFTPSink_Put(t *testing.T) {
+	// Setup
+	cfg, remoteDir := newSFTPServer(t)
+	sink, err := NewSFTPSink(context.Background(), cfg)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	// Execute
+	err = sink.Put(context.Background(), "tripica_results_0000.csv", bytes.NewReader([]byte("id\n1\n")), ObjectMeta{})
+
+	// Assert
+	require.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(remoteDir, "tripica_results_0000.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "id\n1\n", string(content))
+	assert.NoFileExists(t, filepath.Join(remoteDir, "tripica_results_0000.csv.part"))
+}
+
+func TestSFTPSink_PutReplacesExistingFile(t *testing.T) {
+	// Setup
+	cfg, remoteDir := newSFTPServer(t)
+	sink, err := NewSFTPSink(context.Background(), cfg)
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, os.WriteFile(filepath.Join(remoteDir, "oibl.csv"), []byte("old"), 0600))
+
+	// Execute
+	err = sink.Put(context.Background(), "oibl.csv", bytes.NewReader([]byte("new")), ObjectMeta{})
+
+	// Assert
+	require.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(remoteDir, "oibl.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+}
+
+func TestSFTPSink_PutRemovesPartialFileOnReadError(t *testing.T) {
+	// Setup
+	cfg, remoteDir := newSFTPServer(t)
+	sink, err := NewSFTPSink(context.Background(), cfg)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	// Execute
+	err = sink.Put(context.Background(), "broken.csv", &failingReader{err: errors.New("boom")}, ObjectMeta{})
+
+	// Assert
+	require.Error(t, err)
+	entries, err := os.ReadDir(remoteDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no partial file should be left behind")
+}
+
+func TestSFTPSink_RejectsUnknownHostKey(t *testing.T) {
+	// Setup
+	cfg, _ := newSFTPServer(t)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(otherKey)
+	require.NoError(t, err)
+	cfg.KnownHostsFile = writeKnownHosts(t, cfg.Host, cfg.Port, signer.PublicKey())
+
+	// Execute
+	_, err = NewSFTPSink(context.Background(), cfg)
+
+	// Assert
+	assert.Error(t, err)
+}
+
+func TestCSVExporter_StreamTableToSFTP(t *testing.T) {
+	// Setup
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectQuery("SELECT \\* FROM tripica_results").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3))
+
+	cfg, remoteDir := newSFTPServer(t)
+	sink, err := NewSFTPSink(context.Background(), cfg)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	exporter := NewCSVExporter(db, "", 2)
+
+	// Execute
+	files, err := exporter.StreamTable(context.Background(), "tripica_results", "tripica", sink)
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	content, err := os.ReadFile(filepath.Join(remoteDir, "tripica_tripica_results_0001.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "id\n3\n", string(content))
+}
+
+// Helper Functions
+
+// newSFTPServer starts an in-process SSH server with the SFTP subsystem
+// serving a temporary directory and returns the sink configuration for it.
+func newSFTPServer(t *testing.T) (appconfig.SFTPConfig, string) {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "sap" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, serverConfig)
+		}
+	}()
+
+	host, portStr, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	remoteDir := t.TempDir()
+	return appconfig.SFTPConfig{
+		Host:           host,
+		Port:           port,
+		User:           "sap",
+		Password:       "secret",
+		KnownHostsFile: writeKnownHosts(t, host, port, signer.PublicKey()),
+		RemoteDir:      remoteDir,
+	}, remoteDir
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				_ = server.Serve()
+				_ = server.Close()
+				return
+			}
+		}()
+	}
+}
+
+func writeKnownHosts(t *testing.T, host string, port int, key ssh.PublicKey) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))}, key)
+	require.NoError(t, os.WriteFile(path, []byte(line+"\n"), 0600))
+	return path
+}
@@ -0,0 +1,138 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Supported sinks.
+const (
+	SinkS3    = "s3"
+	SinkLocal = "local"
+	SinkSFTP  = "sftp"
+)
+
+// Sink stores exported chunks. Put stores the chunk read from body under
+// name; a read error on body must abort it without leaving a partial object
+// behind.
+type Sink interface {
+	Put(ctx context.Context, name string, body io.Reader, meta ObjectMeta) error
+}
+
+// FileSink is implemented by sinks that store local files more efficiently
+// than by reading them through Put, e.g. as parallel multipart uploads.
+type FileSink interface {
+	UploadFiles(ctx context.Context, files []File) error
+}
+
+// PutFiles stores the exported files in sink under their base names.
+func PutFiles(ctx context.Context, sink Sink, files []File) error {
+	if fs, ok := sink.(FileSink); ok {
+		return fs.UploadFiles(ctx, files)
+	}
+
+	for _, file := range files {
+		if err := putFile(ctx, sink, file); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+func putFile(ctx context.Context, sink Sink, file File) error {
+	// #nosec G304 -- file.Path comes from the exporter output, not user input
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Failed to close file")
+		}
+	}()
+
+	meta := ObjectMeta{System: file.System, Table: file.Table, Rows: file.Rows}
+	return sink.Put(ctx, filepath.Base(file.Path), f, meta)
+}
+
+// MultiSink delivers every chunk to all of its sinks.
+type MultiSink struct {
+	sinks []Sink
+}
+
+// NewMultiSink returns a sink writing to all sinks.
+func NewMultiSink(sinks ...Sink) *MultiSink {
+	return &MultiSink{sinks: sinks}
+}
+
+// Put streams body into all sinks at once. If one of them fails, the
+// others are aborted as if body failed to read.
+func (m *MultiSink) Put(ctx context.Context, name string, body io.Reader, meta ObjectMeta) error {
+	if len(m.sinks) == 1 {
+		return m.sinks[0].Put(ctx, name, body, meta)
+	}
+
+	writers := make([]io.Writer, len(m.sinks))
+	pipes := make([]*io.PipeWriter, len(m.sinks))
+	done := make(chan error, len(m.sinks))
+	for i, sink := range m.sinks {
+		pr, pw := io.Pipe()
+		writers[i], pipes[i] = pw, pw
+		go func() {
+			err := sink.Put(ctx, name, pr, meta)
+			// Unblock the copy below if the sink gave up early.
+			pr.CloseWithError(err)
+			done <- err
+		}()
+	}
+
+	_, copyErr := io.Copy(io.MultiWriter(writers...), body)
+	for _, pw := range pipes {
+		if copyErr != nil {
+			_ = pw.CloseWithError(copyErr) // PipeWriter.CloseWithError never fails
+		} else {
+			_ = pw.Close() // PipeWriter.Close never fails
+		}
+	}
+
+	var errs []error
+	for range m.sinks {
+		if err := <-done; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 && copyErr != nil {
+		errs = append(errs, copyErr)
+	}
+	return errors.Join(errs...)
+}
+
+// UploadFiles stores the files in every sink, one sink after another.
+func (m *MultiSink) UploadFiles(ctx context.Context, files []File) error {
+	for _, sink := range m.sinks {
+		if err := PutFiles(ctx, sink, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ctxReader stops reading once ctx is done, for sinks whose transport does
+// not take a context.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
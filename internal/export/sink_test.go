@@ -0,0 +1,117 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinkInterface(t *testing.T) {
+	var _ Sink = &S3Uploader{}
+	var _ Sink = &LocalSink{}
+	var _ Sink = &SFTPSink{}
+	var _ Sink = &MultiSink{}
+	var _ FileSink = &S3Uploader{}
+	var _ FileSink = &MultiSink{}
+}
+
+func TestLocalSink_Put(t *testing.T) {
+	// Setup
+	dir := filepath.Join(t.TempDir(), "exports")
+	sink := NewLocalSink(dir)
+
+	// Execute
+	err := sink.Put(context.Background(), "tripica_results_0000.csv", bytes.NewReader([]byte("id\n1\n")), ObjectMeta{})
+
+	// Assert
+	require.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(dir, "tripica_results_0000.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "id\n1\n", string(content))
+}
+
+func TestLocalSink_PutLeavesNothingOnReadError(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	sink := NewLocalSink(dir)
+
+	// Execute
+	err := sink.Put(context.Background(), "broken.csv", &failingReader{err: errors.New("boom")}, ObjectMeta{})
+
+	// Assert
+	require.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLocalSink_RejectsEscapingNames(t *testing.T) {
+	sink := NewLocalSink(t.TempDir())
+	err := sink.Put(context.Background(), "../outside.csv", bytes.NewReader(nil), ObjectMeta{})
+	assert.Error(t, err)
+}
+
+func TestMultiSink_Put(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	dir := t.TempDir()
+	sink := NewMultiSink(newS3UploaderWithClient(client, "exports", "enercity/prod"), NewLocalSink(dir))
+
+	// Execute
+	err := sink.Put(context.Background(), "oibl.csv", bytes.NewReader([]byte("id\n1\n")), ObjectMeta{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "id\n1\n", getObject(t, client, "exports", "enercity/prod/oibl.csv"))
+	content, err := os.ReadFile(filepath.Join(dir, "oibl.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "id\n1\n", string(content))
+}
+
+func TestMultiSink_PutAbortsOthersOnFailure(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	sink := NewMultiSink(NewLocalSink(dir), &rejectingSink{err: errors.New("disk full")})
+
+	// Execute
+	err := sink.Put(context.Background(), "oibl.csv", bytes.NewReader(make([]byte, 1<<20)), ObjectMeta{})
+
+	// Assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "disk full")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the local file should be discarded as well")
+}
+
+func TestPutFiles_UsesPutForPlainSinks(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	files := []File{{Path: writeTempFile(t, "tripica_results_0000.csv", []byte("id\n1\n")), System: "tripica", Rows: 1}}
+
+	// Execute
+	err := PutFiles(context.Background(), NewLocalSink(dir), files)
+
+	// Assert
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "tripica_results_0000.csv"))
+}
+
+// Helper Functions
+
+// rejectingSink reads part of the body and fails.
+type rejectingSink struct {
+	err error
+}
+
+func (s *rejectingSink) Put(_ context.Context, _ string, body io.Reader, _ ObjectMeta) error {
+	_, _ = body.Read(make([]byte, 16))
+	return s.err
+}
@@ -0,0 +1,43 @@
+package export
+
+import (
+	"context"
+	"io"
+)
+
+// streamChunk feeds a chunk through an io.Pipe into a Sink running
+// in the background, so rows never touch the local disk.
+type streamChunk struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newStreamChunk(ctx context.Context, dst Sink, name string, meta ObjectMeta) *streamChunk {
+	pr, pw := io.Pipe()
+	c := &streamChunk{pw: pw, done: make(chan error, 1)}
+
+	go func() {
+		err := dst.Put(ctx, name, pr, meta)
+		// Unblock the writer if the upload gave up before reading everything.
+		pr.CloseWithError(err)
+		c.done <- err
+	}()
+
+	return c
+}
+
+func (c *streamChunk) Write(p []byte) (int, error) {
+	return c.pw.Write(p)
+}
+
+// Close signals the end of the chunk and waits for the upload to complete.
+func (c *streamChunk) Close() error {
+	_ = c.pw.Close() // PipeWriter.Close never fails
+	return <-c.done
+}
+
+// Abort fails the pending upload with err and waits for it to be cleaned up.
+func (c *streamChunk) Abort(err error) {
+	_ = c.pw.CloseWithError(err) // PipeWriter.CloseWithError never fails
+	<-c.done
+}
@@ -0,0 +1,169 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVExporter_StreamTable(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	uploader := newS3UploaderWithClient(client, "exports", "enercity/dev")
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "name"}).
+		AddRow(1, "Customer A").
+		AddRow(2, "Customer B").
+		AddRow(3, "Customer C")
+	mock.ExpectQuery("SELECT \\* FROM results").WillReturnRows(rows)
+
+	exporter := NewCSVExporter(db, t.TempDir(), 2)
+
+	// Execute
+	files, err := exporter.StreamTable(context.Background(), "results", "tripica", uploader)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []File{
+		{Path: "tripica_results_0000.csv", System: "tripica", Table: "results", Rows: 2, Bytes: 34},
+		{Path: "tripica_results_0001.csv", System: "tripica", Table: "results", Rows: 1, Bytes: 21},
+	}, files)
+	assert.Equal(t, "id,name\n1,Customer A\n2,Customer B\n", getObject(t, client, "exports", "enercity/dev/tripica_results_0000.csv"))
+	assert.Equal(t, "id,name\n3,Customer C\n", getObject(t, client, "exports", "enercity/dev/tripica_results_0001.csv"))
+}
+
+func TestCSVExporter_StreamTableGzip(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	uploader := newS3UploaderWithClient(client, "exports", "")
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT \\* FROM results").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	exporter, err := NewCSVExporter(db, t.TempDir(), 10).WithCompression(CompressionGzip)
+	require.NoError(t, err)
+
+	// Execute
+	files, err := exporter.StreamTable(context.Background(), "results", "tripica", uploader)
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "tripica_results_0000.csv.gz", files[0].Path)
+
+	zr, err := gzip.NewReader(strings.NewReader(getObject(t, client, "exports", files[0].Path)))
+	require.NoError(t, err)
+	content, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "id\n1\n", string(content))
+}
+
+func TestS3Uploader_UploadMultipart(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	uploader := newS3UploaderWithClient(client, "exports", "prefix")
+	uploader.partSize = 1024
+
+	body := bytes.Repeat([]byte("0123456789"), 300)
+
+	// Execute
+	err := uploader.Put(context.Background(), "big.csv", bytes.NewReader(body), ObjectMeta{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, string(body), getObject(t, client, "exports", "prefix/big.csv"))
+}
+
+func TestS3Uploader_UploadAbortsOnReadError(t *testing.T) {
+	// Setup
+	client := newFakeS3(t, "exports")
+	uploader := newS3UploaderWithClient(client, "exports", "")
+	uploader.partSize = 1024
+
+	readErr := errors.New("export failed")
+	body := io.MultiReader(bytes.NewReader(make([]byte, 2500)), &failingReader{err: readErr})
+
+	// Execute
+	err := uploader.Put(context.Background(), "broken.csv", body, ObjectMeta{})
+
+	// Assert
+	require.ErrorIs(t, err, readErr)
+
+	_, err = client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("exports"),
+		Key:    aws.String("broken.csv"),
+	})
+	assert.Error(t, err, "No object should be left behind")
+
+	uploads, err := client.ListMultipartUploads(context.Background(), &s3.ListMultipartUploadsInput{
+		Bucket: aws.String("exports"),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, uploads.Uploads, "Multipart upload should be aborted")
+}
+
+// Helper Functions
+
+func newFakeS3(t *testing.T, buckets ...string) *s3.Client {
+	t.Helper()
+
+	backend := s3mem.New()
+	for _, bucket := range buckets {
+		require.NoError(t, backend.CreateBucket(bucket))
+	}
+
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	return s3.New(s3.Options{
+		BaseEndpoint: aws.String(server.URL),
+		Region:       "eu-central-1",
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+}
+
+func getObject(t *testing.T, client *s3.Client, bucket, key string) string {
+	t.Helper()
+
+	out, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	require.NoError(t, err)
+	defer out.Body.Close()
+
+	content, err := io.ReadAll(out.Body)
+	require.NoError(t, err)
+	return string(content)
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
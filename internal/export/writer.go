@@ -0,0 +1,54 @@
+package export
+
+import (
+	"context"
+	"fmt"
+)
+
+// Supported export formats.
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// Supported chunk compressions.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// File describes an exported chunk.
+type File struct {
+	// Path is the local file, or the object name for streamed chunks.
+	Path   string
+	System string
+	Table  string
+	Rows   int64
+	// Bytes is the size of the chunk as written, 0 if not tracked.
+	Bytes int64
+}
+
+// ObjectMeta describes a chunk handed to a sink. Rows and SHA256 are
+// empty while a chunk is still being streamed.
+type ObjectMeta struct {
+	System string
+	Table  string
+	Rows   int64
+	SHA256 string
+}
+
+// Writer exports a table into one or more local files, each holding at most
+// maxRowsPerFile rows, and returns the files it created.
+type Writer interface {
+	ExportTable(ctx context.Context, tableName, system string) ([]File, error)
+}
+
+// StreamWriter is implemented by writers that can stream chunks directly
+// into a Sink without touching the local disk.
+type StreamWriter interface {
+	StreamTable(ctx context.Context, tableName, system string, dst Sink) ([]File, error)
+}
+
+func chunkFileName(system, tableName string, fileIndex int, ext string) string {
+	return fmt.Sprintf("%s_%s_%04d.%s", system, tableName, fileIndex, ext)
+}
@@ -24,14 +24,14 @@ func NewBookkeeperProcessor(db *database.Connection, executor *database.ScriptEx
 }
 
 func (p *BookkeeperProcessor) Process(ctx context.Context) error {
-	log.Info().Msg("Starting Bookkeeper processor")
+	log.Ctx(ctx).Info().Msg("Starting Bookkeeper processor")
 
 	initDir := filepath.Join(p.scriptsDir, "init")
 	if err := p.executor.ExecuteScriptsInDir(ctx, initDir); err != nil {
 		return fmt.Errorf("failed to execute Bookkeeper init scripts: %w", err)
 	}
 
-	log.Info().Msg("Bookkeeper processing completed")
+	log.Ctx(ctx).Info().Msg("Bookkeeper processing completed")
 	return nil
 }
 
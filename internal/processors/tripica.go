@@ -24,14 +24,14 @@ func NewTripicaProcessor(db *database.Connection, executor *database.ScriptExecu
 }
 
 func (p *TripicaProcessor) Process(ctx context.Context) error {
-	log.Info().Msg("Starting Tripica processor")
+	log.Ctx(ctx).Info().Msg("Starting Tripica processor")
 
 	initDir := filepath.Join(p.scriptsDir, "init")
 	if err := p.executor.ExecuteScriptsInDir(ctx, initDir); err != nil {
 		return fmt.Errorf("failed to execute Tripica init scripts: %w", err)
 	}
 
-	log.Info().Msg("Tripica processing completed")
+	log.Ctx(ctx).Info().Msg("Tripica processing completed")
 	return nil
 }
 
@@ -0,0 +1,382 @@
+// Package publish swaps the tables and views built by the SQL scripts in a
+// staging schema into the report schema in a single transaction.
+//
+// Objects replaced by a publish are moved into an archive schema per run,
+// <target>__<version>, which keeps their names, indexes and grants intact.
+// The newest archives are kept so a run can be rolled back, see Prune.
+package publish
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+// Default schemas used by the SQL scripts.
+const (
+	DefaultStagingSchema = "report_oibl_staging"
+	DefaultTargetSchema  = "report_oibl"
+)
+
+// DefaultLockTimeout bounds how long a publish waits for queries on the
+// report schema to release their locks.
+const DefaultLockTimeout = 30 * time.Second
+
+// ErrNothingStaged is returned by Publish when the staging schema is empty
+// or missing.
+var ErrNothingStaged = errors.New("nothing staged to publish")
+
+// maxIdentifierLength is the PostgreSQL limit for names (NAMEDATALEN - 1).
+const maxIdentifierLength = 63
+
+// Options configures a Publisher.
+type Options struct {
+	Staging string
+	Target  string
+	// Keep is the number of archived versions to keep, 0 keeps none.
+	Keep        int
+	LockTimeout time.Duration
+}
+
+// Publisher publishes staged objects into the target schema.
+type Publisher struct {
+	db          *sql.DB
+	staging     string
+	target      string
+	keep        int
+	lockTimeout time.Duration
+}
+
+// object is a table, view or materialized view.
+type object struct {
+	name string
+	kind string
+}
+
+// drop returns the DROP statement prefix for the object kind.
+func (o object) drop() string {
+	return "DROP" + strings.TrimPrefix(o.alter(), "ALTER")
+}
+
+// alter returns the ALTER statement prefix for the object kind.
+func (o object) alter() string {
+	switch o.kind {
+	case "v":
+		return "ALTER VIEW"
+	case "m":
+		return "ALTER MATERIALIZED VIEW"
+	default:
+		return "ALTER TABLE"
+	}
+}
+
+// NewPublisher creates a Publisher, filling in defaults for empty options.
+func NewPublisher(db *sql.DB, opts Options) (*Publisher, error) {
+	if opts.Staging == "" {
+		opts.Staging = DefaultStagingSchema
+	}
+	if opts.Target == "" {
+		opts.Target = DefaultTargetSchema
+	}
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = DefaultLockTimeout
+	}
+	if opts.Staging == opts.Target {
+		return nil, fmt.Errorf("staging and target schema must differ, got %q", opts.Target)
+	}
+	if opts.Keep < 0 {
+		return nil, fmt.Errorf("keep must not be negative, got %d", opts.Keep)
+	}
+
+	return &Publisher{
+		db:          db,
+		staging:     opts.Staging,
+		target:      opts.Target,
+		keep:        opts.Keep,
+		lockTimeout: opts.LockTimeout,
+	}, nil
+}
+
+// Version derives an archive version from a run ID. Run IDs start with a
+// UTC timestamp, so versions sort chronologically.
+func Version(runID string) string {
+	return strings.ReplaceAll(strings.ToLower(runID), "-", "_")
+}
+
+// Archive returns the schema holding the objects replaced by the publish
+// of version, i.e. the report tables of the previous run.
+func (p *Publisher) Archive(version string) (string, error) {
+	return p.archiveSchema(version)
+}
+
+// archiveSchema returns the archive schema of version.
+func (p *Publisher) archiveSchema(version string) (string, error) {
+	schema := p.target + "__" + version
+	if version == "" || len(schema) > maxIdentifierLength {
+		return "", fmt.Errorf("invalid publish version %q", version)
+	}
+	return schema, nil
+}
+
+// Publish moves every table and view of the staging schema into the
+// target schema. Objects they replace are archived under version and
+// stay there until Prune, e.g. for comparing with the previous run. It
+// returns ErrNothingStaged if the staging schema is empty.
+func (p *Publisher) Publish(ctx context.Context, version string) ([]string, error) {
+	archive, err := p.archiveSchema(version)
+	if err != nil {
+		return nil, err
+	}
+
+	staged, err := p.objects(ctx, p.db, p.staging)
+	if err != nil {
+		return nil, err
+	}
+	if len(staged) == 0 {
+		return nil, fmt.Errorf("staging schema %s: %w", p.staging, ErrNothingStaged)
+	}
+
+	if err := p.swap(ctx, p.staging, staged, archive); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(staged))
+	for _, o := range staged {
+		names = append(names, o.name)
+	}
+	log.Ctx(ctx).Info().
+		Str("schema", p.target).
+		Strs("objects", names).
+		Str("archive", archive).
+		Msg("Published staging schema")
+	return names, nil
+}
+
+// Rollback restores the objects archived under version into the target
+// schema. The objects it replaces are archived under current.
+func (p *Publisher) Rollback(ctx context.Context, version, current string) error {
+	from, err := p.archiveSchema(version)
+	if err != nil {
+		return err
+	}
+	archive, err := p.archiveSchema(current)
+	if err != nil {
+		return err
+	}
+
+	restored, err := p.objects(ctx, p.db, from)
+	if err != nil {
+		return err
+	}
+	if len(restored) == 0 {
+		return fmt.Errorf("version %s not found, available: %s", version, strings.Join(p.availableVersions(ctx), ", "))
+	}
+
+	if err := p.swap(ctx, from, restored, archive); err != nil {
+		return err
+	}
+	if _, err := p.db.ExecContext(ctx, "DROP SCHEMA IF EXISTS "+quote(from)); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("schema", from).Msg("Failed to drop restored archive")
+	}
+
+	log.Ctx(ctx).Info().Str("schema", p.target).Str("version", version).Str("archive", archive).Msg("Rolled back report schema")
+	return nil
+}
+
+// Versions returns the archived versions, newest first.
+func (p *Publisher) Versions(ctx context.Context) ([]string, error) {
+	rows, err := p.db.QueryContext(ctx,
+		"SELECT nspname FROM pg_namespace WHERE starts_with(nspname, $1)", p.target+"__")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archived versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, fmt.Errorf("failed to list archived versions: %w", err)
+		}
+		versions = append(versions, strings.TrimPrefix(schema, p.target+"__"))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list archived versions: %w", err)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+	return versions, nil
+}
+
+// availableVersions lists the versions for error messages.
+func (p *Publisher) availableVersions(ctx context.Context) []string {
+	versions, err := p.Versions(ctx)
+	if err != nil {
+		return []string{"unknown"}
+	}
+	return versions
+}
+
+// swap moves objects from schema into the target schema in a single
+// transaction, archiving the target objects they replace.
+func (p *Publisher) swap(ctx context.Context, schema string, objects []object, archive string) (err error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback() // Ignore rollback error during error handling
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL lock_timeout = %d", p.lockTimeout.Milliseconds())); err != nil {
+		return fmt.Errorf("failed to set lock_timeout: %w", err)
+	}
+
+	current, err := p.objects(ctx, tx, p.target)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]object, len(current))
+	for _, o := range current {
+		existing[o.name] = o
+	}
+
+	var stmts []string
+	archived := false
+	for _, o := range objects {
+		if old, ok := existing[o.name]; ok {
+			if !archived {
+				stmts = append(stmts, "CREATE SCHEMA "+quote(archive))
+				archived = true
+			}
+			stmts = append(stmts, fmt.Sprintf("%s %s SET SCHEMA %s", old.alter(), quote(p.target, old.name), quote(archive)))
+		}
+		stmts = append(stmts, fmt.Sprintf("%s %s SET SCHEMA %s", o.alter(), quote(schema, o.name), quote(p.target)))
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to publish into %s: %s: %w", p.target, stmt, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit publish: %w", err)
+	}
+	return nil
+}
+
+// Prune drops all but the newest keep archived versions. Archives are
+// dropped with RESTRICT: a version that objects outside it still depend
+// on, e.g. a view in the report schema, is kept and its error names them.
+func (p *Publisher) Prune(ctx context.Context) error {
+	versions, err := p.Versions(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, version := range versions[min(p.keep, len(versions)):] {
+		schema := p.target + "__" + version
+		if err := p.drop(ctx, schema); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == dependentObjectsStillExist {
+				err = fmt.Errorf("%w: %s", err, pgErr.Detail)
+			}
+			errs = append(errs, fmt.Errorf("failed to drop %s: %w", schema, err))
+			continue
+		}
+		log.Ctx(ctx).Info().Str("schema", schema).Msg("Dropped archived version")
+	}
+	return errors.Join(errs...)
+}
+
+// dependentObjectsStillExist is the SQLSTATE of a DROP ... RESTRICT that
+// other objects depend on.
+const dependentObjectsStillExist = "2BP01"
+
+// drop drops an archive schema and its objects in a single transaction,
+// views before the tables they may select from.
+func (p *Publisher) drop(ctx context.Context, schema string) (err error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback() // Ignore rollback error during error handling
+		}
+	}()
+
+	objects, err := p.objects(ctx, tx, schema)
+	if err != nil {
+		return err
+	}
+	byKind := make(map[string][]string)
+	for _, o := range objects {
+		drop := o.drop()
+		byKind[drop] = append(byKind[drop], quote(schema, o.name))
+	}
+
+	var stmts []string
+	for _, drop := range []string{"DROP VIEW", "DROP MATERIALIZED VIEW", "DROP TABLE"} {
+		if names := byKind[drop]; len(names) > 0 {
+			stmts = append(stmts, drop+" "+strings.Join(names, ", ")+" RESTRICT")
+		}
+	}
+	stmts = append(stmts, "DROP SCHEMA "+quote(schema)+" RESTRICT")
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit drop: %w", err)
+	}
+	return nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// objects lists the tables, views and materialized views of schema.
+func (p *Publisher) objects(ctx context.Context, q queryer, schema string) ([]object, error) {
+	rows, err := q.QueryContext(ctx, `SELECT c.relname, c.relkind
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p', 'v', 'm')
+		ORDER BY c.relname`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects of %s: %w", schema, err)
+	}
+	defer rows.Close()
+
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.name, &o.kind); err != nil {
+			return nil, fmt.Errorf("failed to list objects of %s: %w", schema, err)
+		}
+		objects = append(objects, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list objects of %s: %w", schema, err)
+	}
+	return objects, nil
+}
+
+// quote quotes a possibly schema-qualified identifier.
+func quote(parts ...string) string {
+	return pgx.Identifier(parts).Sanitize()
+}
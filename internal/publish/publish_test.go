@@ -0,0 +1,255 @@
+package publish
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublisher_Publish(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+
+	expectObjects(mock, "report_oibl_staging", "oibl_customer", "v", "oibl_tripica", "r")
+	mock.ExpectBegin()
+	mock.ExpectExec("SET LOCAL lock_timeout = 30000").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectObjects(mock, "report_oibl", "data_charges", "r", "oibl_customer", "v", "oibl_tripica", "r")
+	expectExec(mock,
+		`CREATE SCHEMA "report_oibl__20251127t093000z_a1b2c3"`,
+		`ALTER VIEW "report_oibl"."oibl_customer" SET SCHEMA "report_oibl__20251127t093000z_a1b2c3"`,
+		`ALTER VIEW "report_oibl_staging"."oibl_customer" SET SCHEMA "report_oibl"`,
+		`ALTER TABLE "report_oibl"."oibl_tripica" SET SCHEMA "report_oibl__20251127t093000z_a1b2c3"`,
+		`ALTER TABLE "report_oibl_staging"."oibl_tripica" SET SCHEMA "report_oibl"`,
+	)
+	mock.ExpectCommit()
+
+	publisher, err := NewPublisher(db, Options{Keep: 2})
+	require.NoError(t, err)
+
+	// Execute
+	names, err := publisher.Publish(context.Background(), Version("20251127T093000Z-a1b2c3"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"oibl_customer", "oibl_tripica"}, names)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPublisher_PublishFirstRun(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+
+	expectObjects(mock, "report_oibl_staging", "oibl_tripica", "r")
+	mock.ExpectBegin()
+	mock.ExpectExec("SET LOCAL lock_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectObjects(mock, "report_oibl")
+	expectExec(mock, `ALTER TABLE "report_oibl_staging"."oibl_tripica" SET SCHEMA "report_oibl"`)
+	mock.ExpectCommit()
+
+	publisher, err := NewPublisher(db, Options{Keep: 3})
+	require.NoError(t, err)
+
+	// Execute
+	_, err = publisher.Publish(context.Background(), "v1")
+
+	// Assert
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Nothing to archive on the first run")
+}
+
+func TestPublisher_PublishNothingStaged(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+	expectObjects(mock, "report_oibl_staging")
+
+	publisher, err := NewPublisher(db, Options{})
+	require.NoError(t, err)
+
+	// Execute
+	_, err = publisher.Publish(context.Background(), "v1")
+
+	// Assert
+	assert.ErrorIs(t, err, ErrNothingStaged)
+}
+
+func TestPublisher_PublishRollsBackOnError(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+
+	expectObjects(mock, "report_oibl_staging", "oibl_customer", "v", "oibl_tripica", "r")
+	mock.ExpectBegin()
+	mock.ExpectExec("SET LOCAL lock_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectObjects(mock, "report_oibl", "oibl_customer", "v", "oibl_tripica", "r")
+	mock.ExpectExec("CREATE SCHEMA").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER VIEW").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER VIEW").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER TABLE").WillReturnError(errors.New("canceling statement due to lock timeout"))
+	mock.ExpectRollback()
+
+	publisher, err := NewPublisher(db, Options{})
+	require.NoError(t, err)
+
+	// Execute
+	_, err = publisher.Publish(context.Background(), "v1")
+
+	// Assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "lock timeout")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPublisher_Prune(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+
+	expectVersions(mock, "20251125t093000z_c3d4e5", "20251127t093000z_a1b2c3", "20251126t093000z_b2c3d4")
+	mock.ExpectBegin()
+	expectObjects(mock, "report_oibl__20251125t093000z_c3d4e5", "oibl_customer", "v", "oibl_summary", "m", "oibl_tripica", "r", "report_data", "r")
+	expectExec(mock,
+		`DROP VIEW "report_oibl__20251125t093000z_c3d4e5"."oibl_customer" RESTRICT`,
+		`DROP MATERIALIZED VIEW "report_oibl__20251125t093000z_c3d4e5"."oibl_summary" RESTRICT`,
+		`DROP TABLE "report_oibl__20251125t093000z_c3d4e5"."oibl_tripica", "report_oibl__20251125t093000z_c3d4e5"."report_data" RESTRICT`,
+		`DROP SCHEMA "report_oibl__20251125t093000z_c3d4e5" RESTRICT`,
+	)
+	mock.ExpectCommit()
+
+	publisher, err := NewPublisher(db, Options{Keep: 2})
+	require.NoError(t, err)
+
+	// Execute
+	err = publisher.Prune(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPublisher_PruneKeepsArchiveInUse(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+
+	expectVersions(mock, "v1", "v2")
+	mock.ExpectBegin()
+	expectObjects(mock, "report_oibl__v2", "oibl_tripica", "r")
+	mock.ExpectExec(regexp.QuoteMeta(`DROP TABLE "report_oibl__v2"."oibl_tripica" RESTRICT`)).
+		WillReturnError(&pgconn.PgError{
+			Code:    "2BP01",
+			Message: "cannot drop table report_oibl__v2.oibl_tripica because other objects depend on it",
+			Detail:  "view metabase.open_items depends on table report_oibl__v2.oibl_tripica",
+		})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectObjects(mock, "report_oibl__v1", "oibl_tripica", "r")
+	expectExec(mock,
+		`DROP TABLE "report_oibl__v1"."oibl_tripica" RESTRICT`,
+		`DROP SCHEMA "report_oibl__v1" RESTRICT`,
+	)
+	mock.ExpectCommit()
+
+	publisher, err := NewPublisher(db, Options{Keep: 0})
+	require.NoError(t, err)
+
+	// Execute
+	err = publisher.Prune(context.Background())
+
+	// Assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "report_oibl__v2")
+	assert.Contains(t, err.Error(), "view metabase.open_items depends on", "the error should name the dependent objects")
+	assert.NoError(t, mock.ExpectationsWereMet(), "other versions should still be pruned")
+}
+
+func TestPublisher_Rollback(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+
+	expectObjects(mock, "report_oibl__v1", "oibl_customer", "v", "oibl_tripica", "r")
+	mock.ExpectBegin()
+	mock.ExpectExec("SET LOCAL lock_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectObjects(mock, "report_oibl", "oibl_customer", "v", "oibl_tripica", "r")
+	expectExec(mock,
+		`CREATE SCHEMA "report_oibl__v2"`,
+		`ALTER VIEW "report_oibl"."oibl_customer" SET SCHEMA "report_oibl__v2"`,
+		`ALTER VIEW "report_oibl__v1"."oibl_customer" SET SCHEMA "report_oibl"`,
+		`ALTER TABLE "report_oibl"."oibl_tripica" SET SCHEMA "report_oibl__v2"`,
+		`ALTER TABLE "report_oibl__v1"."oibl_tripica" SET SCHEMA "report_oibl"`,
+	)
+	mock.ExpectCommit()
+	expectExec(mock, `DROP SCHEMA IF EXISTS "report_oibl__v1"`)
+
+	publisher, err := NewPublisher(db, Options{})
+	require.NoError(t, err)
+
+	// Execute
+	err = publisher.Rollback(context.Background(), "v1", "v2")
+
+	// Assert
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPublisher_RollbackUnknownVersion(t *testing.T) {
+	// Setup
+	db, mock := newMock(t)
+	expectObjects(mock, "report_oibl__v0")
+	expectVersions(mock, "v1", "v2")
+
+	publisher, err := NewPublisher(db, Options{})
+	require.NoError(t, err)
+
+	// Execute
+	err = publisher.Rollback(context.Background(), "v0", "v3")
+
+	// Assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "available: v2, v1")
+}
+
+func TestNewPublisher_Validation(t *testing.T) {
+	_, err := NewPublisher(nil, Options{Staging: "report_oibl"})
+	assert.Error(t, err, "staging and target must differ")
+
+	_, err = NewPublisher(nil, Options{Keep: -1})
+	assert.Error(t, err, "negative keep is rejected")
+}
+
+// Helper Functions
+
+func newMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db, mock
+}
+
+// expectObjects expects the object listing of schema, returning name/kind pairs.
+func expectObjects(mock sqlmock.Sqlmock, schema string, objects ...string) {
+	rows := sqlmock.NewRows([]string{"relname", "relkind"})
+	for i := 0; i < len(objects); i += 2 {
+		rows.AddRow(objects[i], objects[i+1])
+	}
+	mock.ExpectQuery("FROM pg_class").WithArgs(schema).WillReturnRows(rows)
+}
+
+func expectVersions(mock sqlmock.Sqlmock, versions ...string) {
+	rows := sqlmock.NewRows([]string{"nspname"})
+	for _, version := range versions {
+		rows.AddRow("report_oibl__" + version)
+	}
+	mock.ExpectQuery("FROM pg_namespace").WithArgs("report_oibl__").WillReturnRows(rows)
+}
+
+func expectExec(mock sqlmock.Sqlmock, stmts ...string) {
+	for _, stmt := range stmts {
+		mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+}
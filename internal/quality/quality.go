@@ -0,0 +1,163 @@
+// Package quality runs data-quality assertions against the aggregated
+// tables before they are published and exported.
+//
+// An assertion is a query returning the violating rows, so an empty result
+// passes. Assertions are declared in YAML files:
+//
+//	assertions:
+//	  - name: no_duplicate_charges
+//	    description: Every charge appears once
+//	    severity: error
+//	    sql: |
+//	      SELECT applied_billing_charge_ouid, count(*)
+//	      FROM report_oibl_staging.oibl_tripica
+//	      GROUP BY 1 HAVING count(*) > 1
+//
+// or as SQL files named after the assertion, with marker comments:
+//
+//	-- bda:severity=warn
+//	-- bda:description=Row count within 10% of the published table
+//	SELECT ...
+package quality
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severity decides whether a failed assertion blocks the export.
+type Severity string
+
+const (
+	// SeverityWarn failures are logged and reported only.
+	SeverityWarn Severity = "warn"
+	// SeverityError failures block publishing, export and upload.
+	SeverityError Severity = "error"
+)
+
+// Assertion is a named query returning the rows violating an invariant.
+type Assertion struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Severity    Severity `yaml:"severity"`
+	SQL         string   `yaml:"sql"`
+	// Source is the file the assertion was declared in.
+	Source string `yaml:"-"`
+}
+
+// file is the layout of a YAML assertion file.
+type file struct {
+	Assertions []Assertion `yaml:"assertions"`
+}
+
+var marker = regexp.MustCompile(`(?m)^\s*--\s*bda:(severity|description)\s*=\s*(.*?)\s*$`)
+
+// LoadDir loads the assertions of all .yaml, .yml and .sql files in dir and
+// its subdirectories, in file name order. Files prefixed with NOEXEC_ are
+// skipped like scripts. A missing directory has no assertions.
+func LoadDir(dir string) ([]Assertion, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), "NOEXEC_") {
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".sql":
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list assertions in %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	var assertions []Assertion
+	seen := make(map[string]string)
+	for _, path := range paths {
+		loaded, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range loaded {
+			if err := a.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if other, ok := seen[a.Name]; ok {
+				return nil, fmt.Errorf("%s: assertion %q already declared in %s", path, a.Name, other)
+			}
+			seen[a.Name] = path
+			assertions = append(assertions, a)
+		}
+	}
+	return assertions, nil
+}
+
+func loadFile(path string) ([]Assertion, error) {
+	// #nosec G304 -- path is part of the application quality scripts directory
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read assertions: %w", err)
+	}
+
+	if filepath.Ext(path) == ".sql" {
+		return []Assertion{parseSQL(path, string(content))}, nil
+	}
+
+	var f file
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i := range f.Assertions {
+		f.Assertions[i].Source = path
+	}
+	return f.Assertions, nil
+}
+
+// parseSQL reads a single assertion from a SQL file named after it.
+func parseSQL(path, content string) Assertion {
+	a := Assertion{
+		Name:   strings.TrimSuffix(filepath.Base(path), ".sql"),
+		SQL:    content,
+		Source: path,
+	}
+	for _, m := range marker.FindAllStringSubmatch(content, -1) {
+		if m[1] == "severity" {
+			a.Severity = Severity(m[2])
+		} else {
+			a.Description = m[2]
+		}
+	}
+	return a
+}
+
+func (a *Assertion) validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("assertion without name")
+	}
+	a.SQL = strings.TrimRight(strings.TrimSpace(a.SQL), ";")
+	if a.SQL == "" {
+		return fmt.Errorf("assertion %q has no sql", a.Name)
+	}
+	switch a.Severity {
+	case "":
+		a.Severity = SeverityError
+	case SeverityWarn, SeverityError:
+	default:
+		return fmt.Errorf("assertion %q: severity must be warn or error, got %q", a.Name, a.Severity)
+	}
+	return nil
+}
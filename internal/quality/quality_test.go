@@ -0,0 +1,111 @@
+package quality
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDir(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	writeFile(t, dir, "checks.yaml", `
+assertions:
+  - name: no_duplicates
+    description: Every charge once
+    sql: SELECT id FROM t GROUP BY id HAVING count(*) > 1;
+  - name: vat_sum
+    severity: warn
+    sql: SELECT 1 WHERE false
+`)
+	writeFile(t, dir, "sub/row_count.sql", "-- bda:severity=warn\n-- bda:description=Within 10%\nSELECT 1 WHERE false;\n")
+	writeFile(t, dir, "NOEXEC_disabled.sql", "SELECT 1")
+	writeFile(t, dir, "README.md", "not an assertion")
+
+	// Execute
+	assertions, err := LoadDir(dir)
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, assertions, 3)
+
+	assert.Equal(t, "no_duplicates", assertions[0].Name)
+	assert.Equal(t, SeverityError, assertions[0].Severity, "Severity should default to error")
+	assert.Equal(t, "SELECT id FROM t GROUP BY id HAVING count(*) > 1", assertions[0].SQL)
+	assert.Equal(t, SeverityWarn, assertions[1].Severity)
+
+	assert.Equal(t, "row_count", assertions[2].Name)
+	assert.Equal(t, SeverityWarn, assertions[2].Severity)
+	assert.Equal(t, "Within 10%", assertions[2].Description)
+	assert.Equal(t, filepath.Join(dir, "sub/row_count.sql"), assertions[2].Source)
+}
+
+func TestLoadDir_Missing(t *testing.T) {
+	assertions, err := LoadDir(filepath.Join(t.TempDir(), "missing"))
+
+	require.NoError(t, err)
+	assert.Empty(t, assertions)
+}
+
+func TestLoadDir_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantError string
+	}{
+		{
+			name:      "invalid severity",
+			files:     map[string]string{"a.sql": "-- bda:severity=fatal\nSELECT 1"},
+			wantError: "severity must be warn or error",
+		},
+		{
+			name:      "missing sql",
+			files:     map[string]string{"a.yaml": "assertions:\n  - name: empty\n"},
+			wantError: "has no sql",
+		},
+		{
+			name:      "duplicate name",
+			files:     map[string]string{"a.sql": "SELECT 1", "b.yaml": "assertions:\n  - name: a\n    sql: SELECT 1\n"},
+			wantError: "already declared",
+		},
+		{
+			name:      "malformed yaml",
+			files:     map[string]string{"a.yaml": "assertions: ["},
+			wantError: "failed to parse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, dir, name, content)
+			}
+
+			_, err := LoadDir(dir)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantError)
+		})
+	}
+}
+
+func TestLoadDir_ShippedAssertions(t *testing.T) {
+	assertions, err := LoadDir("../../scripts/quality")
+
+	require.NoError(t, err)
+	assert.NotEmpty(t, assertions)
+}
+
+// Helper Functions
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
@@ -0,0 +1,167 @@
+package quality
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSamples is the number of violating rows kept per assertion.
+const DefaultSamples = 5
+
+// Status of an assertion after it ran.
+const (
+	StatusPassed = "passed"
+	StatusFailed = "failed"
+	// StatusError means the query itself failed, which counts as failed.
+	StatusError = "error"
+)
+
+// Result is the outcome of one assertion.
+type Result struct {
+	Assertion  Assertion
+	Status     string
+	Violations int
+	// Samples are the first violating rows as "column=value" lists.
+	Samples  []string
+	Err      error
+	Duration time.Duration
+}
+
+// Report holds the results of a run.
+type Report struct {
+	Results []Result
+}
+
+// Failed returns the results that did not pass at the given severity.
+func (r *Report) Failed(severity Severity) []Result {
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Status != StatusPassed && res.Assertion.Severity == severity {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Err returns an error naming the failed error-severity assertions, or nil.
+func (r *Report) Err() error {
+	failed := r.Failed(SeverityError)
+	if len(failed) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(failed))
+	for _, res := range failed {
+		names = append(names, res.Assertion.Name)
+	}
+	return fmt.Errorf("%d data-quality assertions failed: %s", len(failed), strings.Join(names, ", "))
+}
+
+// Runner runs assertions on a database.
+type Runner struct {
+	db      *sql.DB
+	samples int
+}
+
+// NewRunner creates a Runner keeping DefaultSamples violating rows.
+func NewRunner(db *sql.DB) *Runner {
+	return &Runner{db: db, samples: DefaultSamples}
+}
+
+// Run runs all assertions and logs their results. Failing assertions do
+// not stop the run, so the report shows every violated invariant.
+func (r *Runner) Run(ctx context.Context, assertions []Assertion) *Report {
+	report := &Report{}
+	for _, a := range assertions {
+		res := r.run(ctx, a)
+		logResult(ctx, res)
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+func (r *Runner) run(ctx context.Context, a Assertion) Result {
+	start := time.Now()
+	res := Result{Assertion: a, Status: StatusPassed}
+
+	violations, samples, err := r.query(ctx, a.SQL)
+	res.Duration = time.Since(start)
+	switch {
+	case err != nil:
+		res.Status = StatusError
+		res.Err = err
+	case violations > 0:
+		res.Status = StatusFailed
+		res.Violations = violations
+		res.Samples = samples
+	}
+	return res
+}
+
+// query counts the rows of query and formats the first ones.
+func (r *Runner) query(ctx context.Context, query string) (int, []string, error) {
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	count := 0
+	var samples []string
+	for rows.Next() {
+		count++
+		if len(samples) >= r.samples {
+			continue
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return 0, nil, err
+		}
+		fields := make([]string, len(columns))
+		for i, v := range values {
+			value := "NULL"
+			if v.Valid {
+				value = v.String
+			}
+			fields[i] = columns[i] + "=" + value
+		}
+		samples = append(samples, strings.Join(fields, ", "))
+	}
+	return count, samples, rows.Err()
+}
+
+func logResult(ctx context.Context, res Result) {
+	logger := log.Ctx(ctx)
+	event := logger.Info()
+	switch {
+	case res.Status == StatusPassed:
+	case res.Assertion.Severity == SeverityError:
+		event = logger.Error()
+	default:
+		event = logger.Warn()
+	}
+
+	event.
+		Str("assertion", res.Assertion.Name).
+		Str("severity", string(res.Assertion.Severity)).
+		Str("status", res.Status).
+		Int("violations", res.Violations).
+		Strs("samples", res.Samples).
+		Dur("duration", res.Duration).
+		Err(res.Err).
+		Msg("Data-quality assertion " + res.Status)
+}
@@ -0,0 +1,61 @@
+package quality
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_Run(t *testing.T) {
+	// Setup
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT passing").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	duplicates := sqlmock.NewRows([]string{"applied_billing_charge_ouid", "occurrences"})
+	for i := 0; i < 7; i++ {
+		duplicates.AddRow("C-1", 2)
+	}
+	mock.ExpectQuery("SELECT duplicates").WillReturnRows(duplicates)
+	mock.ExpectQuery("SELECT broken").WillReturnError(errors.New(`relation "report_oibl.oibl_tripica" does not exist`))
+
+	assertions := []Assertion{
+		{Name: "passing", Severity: SeverityError, SQL: "SELECT passing"},
+		{Name: "no_duplicate_charges", Severity: SeverityError, SQL: "SELECT duplicates"},
+		{Name: "row_count_change", Severity: SeverityWarn, SQL: "SELECT broken"},
+	}
+
+	// Execute
+	report := NewRunner(db).Run(context.Background(), assertions)
+
+	// Assert
+	require.Len(t, report.Results, 3)
+	assert.Equal(t, StatusPassed, report.Results[0].Status)
+
+	assert.Equal(t, StatusFailed, report.Results[1].Status)
+	assert.Equal(t, 7, report.Results[1].Violations)
+	assert.Len(t, report.Results[1].Samples, DefaultSamples)
+	assert.Equal(t, "applied_billing_charge_ouid=C-1, occurrences=2", report.Results[1].Samples[0])
+
+	assert.Equal(t, StatusError, report.Results[2].Status)
+	assert.Error(t, report.Results[2].Err)
+
+	assert.Len(t, report.Failed(SeverityWarn), 1, "Query errors count as failed")
+	require.Error(t, report.Err())
+	assert.Equal(t, "1 data-quality assertions failed: no_duplicate_charges", report.Err().Error())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReport_ErrWarningsOnly(t *testing.T) {
+	report := &Report{Results: []Result{
+		{Assertion: Assertion{Name: "a", Severity: SeverityError}, Status: StatusPassed},
+		{Assertion: Assertion{Name: "b", Severity: SeverityWarn}, Status: StatusFailed, Violations: 3},
+	}}
+
+	assert.NoError(t, report.Err(), "Warnings must not block the export")
+}
@@ -0,0 +1,128 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsretry "github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SQLSTATEs of failures that succeed when the transaction is run again.
+const (
+	SerializationFailure = "40001"
+	DeadlockDetected     = "40P01"
+	LockNotAvailable     = "55P03"
+)
+
+// fatalSQLStates are rejected logins and missing databases, which no retry
+// will fix.
+var fatalSQLStates = map[string]bool{
+	"28000": true, // invalid_authorization_specification
+	"28P01": true, // invalid_password
+	"3D000": true, // invalid_catalog_name
+}
+
+// transientSQLStates are server conditions that pass, e.g. a restarting
+// server or a full connection limit.
+var transientSQLStates = map[string]bool{
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// fatalAWSCodes are AWS errors caused by credentials or permissions.
+var fatalAWSCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"ExpiredToken":                true,
+	"InvalidAccessKeyId":          true,
+	"InvalidClientTokenId":        true,
+	"NoSuchBucket":                true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+}
+
+// Any retries errors that any of the classifiers retries.
+func Any(classifiers ...Classifier) Classifier {
+	return func(err error) bool {
+		for _, c := range classifiers {
+			if c(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SQLState retries PostgreSQL errors with one of the given SQLSTATEs.
+func SQLState(codes ...string) Classifier {
+	return func(err error) bool {
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) {
+			return false
+		}
+		for _, code := range codes {
+			if pgErr.Code == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Network retries broken or refused connections, network timeouts and
+// PostgreSQL connection failures.
+func Network(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || transientSQLStates[pgErr.Code]
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// AWS retries what the AWS SDK retries: throttling, server errors and
+// connection errors.
+func AWS(err error) bool {
+	return awsretry.IsErrorRetryables(awsretry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// Fatal reports errors that are never retried: rejected credentials,
+// missing permissions and missing databases or buckets.
+func Fatal(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return fatalSQLStates[pgErr.Code]
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return fatalAWSCodes[apiErr.ErrorCode()]
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		network bool
+		aws     bool
+		fatal   bool
+	}{
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), network: true, aws: true},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), network: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, network: true},
+		{name: "server shutting down", err: pgError("57P01"), network: true},
+		{name: "connection failure", err: pgError("08006"), network: true},
+		{name: "deadlock", err: pgError(DeadlockDetected)},
+		{name: "invalid password", err: pgError("28P01"), fatal: true},
+		{name: "unknown database", err: pgError("3D000"), fatal: true},
+		{name: "S3 slow down", err: awsError(http.StatusServiceUnavailable, "SlowDown"), aws: true},
+		{name: "S3 internal error", err: awsError(http.StatusInternalServerError, "InternalError"), aws: true},
+		{name: "access denied", err: awsError(http.StatusForbidden, "AccessDenied"), fatal: true},
+		{name: "cancelled", err: context.Canceled},
+		{name: "other", err: errors.New("syntax error")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.network, Network(tt.err), "Network")
+			assert.Equal(t, tt.aws, AWS(tt.err), "AWS")
+			assert.Equal(t, tt.fatal, Fatal(tt.err), "Fatal")
+		})
+	}
+}
+
+func TestSQLState(t *testing.T) {
+	retryable := SQLState(SerializationFailure, DeadlockDetected)
+
+	assert.True(t, retryable(fmt.Errorf("statement 3 failed: %w", pgError(DeadlockDetected))))
+	assert.True(t, retryable(pgError(SerializationFailure)))
+	assert.False(t, retryable(pgError("42601")))
+	assert.False(t, retryable(errors.New("deadlock detected")))
+}
+
+func TestAny(t *testing.T) {
+	retryable := Any(SQLState(DeadlockDetected), Network)
+
+	assert.True(t, retryable(pgError(DeadlockDetected)))
+	assert.True(t, retryable(syscall.ECONNRESET))
+	assert.False(t, retryable(pgError("42601")))
+}
+
+// Helper Functions
+
+func pgError(code string) error {
+	return &pgconn.PgError{Severity: "ERROR", Code: code, Message: "test error " + code}
+}
+
+func awsError(status int, code string) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      &smithy.GenericAPIError{Code: code, Message: code},
+		},
+	}
+}
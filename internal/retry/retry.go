@@ -0,0 +1,135 @@
+// Package retry runs operations again after transient failures, e.g. a
+// database that is still starting, a dropped connection or S3 throttling.
+//
+// A Policy bounds the attempts and the elapsed time and waits between
+// attempts with exponential backoff and full jitter. Waits end early when
+// the context is done. Which errors are retried is decided by a Classifier,
+// see Network, AWS and SQLState; Fatal errors, e.g. rejected credentials,
+// and errors marked Permanent are never retried.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Classifier reports whether an error is transient and worth retrying.
+type Classifier func(err error) bool
+
+// Policy configures how an operation is retried. The zero value runs the
+// operation once.
+type Policy struct {
+	// Name identifies the operation in log messages.
+	Name string
+	// MaxAttempts limits the attempts including the first; 0 means no
+	// limit besides MaxElapsed.
+	MaxAttempts int
+	// BaseDelay is the maximum wait before the first retry, doubled for
+	// every further retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxElapsed stops retrying once the next attempt would start later
+	// than this after the first; 0 means no limit.
+	MaxElapsed time.Duration
+	// Retryable selects the errors to retry; nil retries every error that
+	// is not Fatal.
+	Retryable Classifier
+	// OnRetry is called before each wait instead of logging a warning.
+	OnRetry func(attempt int, wait time.Duration, err error)
+}
+
+// Backoff returns a random wait between zero and the exponential delay of
+// the retry, capped at MaxDelay. The first retry is 1.
+func (p Policy) Backoff(retry int) time.Duration {
+	delay := p.MaxDelay
+	if retry < 32 && (p.MaxDelay <= 0 || p.BaseDelay<<(retry-1) < p.MaxDelay) {
+		delay = p.BaseDelay << (retry - 1)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay) // #nosec G404 -- jitter does not need a secure source
+}
+
+// Do calls op until it succeeds, returns an error that is not retried, or
+// the policy is exhausted. The returned error wraps the last error of op,
+// or is the context error if ctx is done while waiting.
+func Do(ctx context.Context, p Policy, op func(ctx context.Context) error) error {
+	if p.MaxAttempts == 0 && p.MaxElapsed == 0 {
+		p.MaxAttempts = 1
+	}
+	started := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !p.retryable(err) {
+			return err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return fmt.Errorf("%s failed after %d attempts: %w", p.Name, attempt, err)
+		}
+
+		wait := p.Backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(started)+wait > p.MaxElapsed {
+			return fmt.Errorf("%s failed after %d attempts in %s: %w", p.Name, attempt, time.Since(started).Round(time.Millisecond), err)
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, wait, err)
+		} else {
+			log.Ctx(ctx).Warn().
+				Err(err).
+				Str("operation", p.Name).
+				Int("retry", attempt).
+				Dur("wait", wait).
+				Msg("Retrying after transient error")
+		}
+		if err := Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func (p Policy) retryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) || Fatal(err) {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// Sleep waits for d or until ctx is done, whichever comes first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Permanent marks err as not worth retrying, whatever the classifier says.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
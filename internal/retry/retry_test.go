@@ -0,0 +1,139 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo_RetriesUntilSuccess(t *testing.T) {
+	// Setup
+	attempts := 0
+	var waits []time.Duration
+	policy := Policy{
+		Name:        "test",
+		MaxAttempts: 5,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    2 * time.Millisecond,
+		OnRetry:     func(_ int, wait time.Duration, _ error) { waits = append(waits, wait) },
+	}
+
+	// Execute
+	err := Do(context.Background(), policy, func(context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Len(t, waits, 2)
+}
+
+func TestDo_MaxAttempts(t *testing.T) {
+	// Setup
+	attempts := 0
+	cause := errors.New("connection refused")
+
+	// Execute
+	err := Do(context.Background(), Policy{Name: "upload", MaxAttempts: 3}, func(context.Context) error {
+		attempts++
+		return cause
+	})
+
+	// Assert
+	require.ErrorIs(t, err, cause)
+	assert.Equal(t, 3, attempts)
+	assert.Contains(t, err.Error(), "upload failed after 3 attempts")
+}
+
+func TestDo_ZeroPolicyRunsOnce(t *testing.T) {
+	attempts := 0
+	err := Do(context.Background(), Policy{}, func(context.Context) error {
+		attempts++
+		return errors.New("boom")
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestDo_MaxElapsed(t *testing.T) {
+	// Setup
+	attempts := 0
+	policy := Policy{Name: "connect", BaseDelay: 20 * time.Millisecond, MaxDelay: 20 * time.Millisecond, MaxElapsed: 50 * time.Millisecond}
+
+	// Execute
+	err := Do(context.Background(), policy, func(context.Context) error {
+		attempts++
+		return errors.New("connection refused")
+	})
+
+	// Assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connect failed after")
+	assert.Greater(t, attempts, 1)
+	assert.Less(t, attempts, 10, "Elapsed time should bound the attempts")
+}
+
+func TestDo_NotRetried(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		err    error
+	}{
+		{"not retryable", Policy{MaxAttempts: 5, Retryable: func(error) bool { return false }}, errors.New("syntax error")},
+		{"permanent", Policy{MaxAttempts: 5}, Permanent(errors.New("invalid input"))},
+		{"fatal", Policy{MaxAttempts: 5}, pgError("28P01")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := Do(context.Background(), tt.policy, func(context.Context) error {
+				attempts++
+				return tt.err
+			})
+
+			assert.Equal(t, tt.err, err, "Error should be returned unwrapped")
+			assert.Equal(t, 1, attempts)
+		})
+	}
+}
+
+func TestDo_CancelledWhileWaiting(t *testing.T) {
+	// Setup
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := Policy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Hour,
+		MaxDelay:    time.Hour,
+		OnRetry:     func(int, time.Duration, error) { cancel() },
+	}
+
+	// Execute
+	started := time.Now()
+	err := Do(ctx, policy, func(context.Context) error { return errors.New("connection refused") })
+
+	// Assert
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(started), time.Second, "Wait should end with the context")
+}
+
+func TestPolicy_Backoff(t *testing.T) {
+	policy := Policy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+	for retry := 1; retry <= 40; retry++ {
+		wait := policy.Backoff(retry)
+		assert.GreaterOrEqual(t, wait, time.Duration(0))
+		assert.Less(t, wait, min(time.Second<<min(retry-1, 5), 30*time.Second), "Backoff should grow exponentially up to the cap")
+	}
+	assert.Zero(t, Policy{}.Backoff(1))
+}
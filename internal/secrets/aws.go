@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/rs/zerolog/log"
+)
+
+// Config configures the AWS clients of the resolvers.
+type Config struct {
+	Region string
+	// URL overrides the SSM and Secrets Manager endpoint, e.g. LocalStack
+	// for offline testing.
+	URL string
+}
+
+// SSMAPI is the part of the SSM client used to read parameters.
+type SSMAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// SecretsManagerAPI is the part of the Secrets Manager client used to read
+// secrets.
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// AWSResolver resolves ssm and secretsmanager references. The clients are
+// created on first use, so configurations without references never load
+// AWS credentials.
+type AWSResolver struct {
+	cfg Config
+
+	once           sync.Once
+	err            error
+	ssm            SSMAPI
+	secretsManager SecretsManagerAPI
+}
+
+// NewAWSResolver creates a resolver using the default AWS credential chain.
+func NewAWSResolver(cfg Config) *AWSResolver {
+	return &AWSResolver{cfg: cfg}
+}
+
+// WithClients sets the clients instead of creating them from the default
+// AWS configuration.
+func (r *AWSResolver) WithClients(ssmClient SSMAPI, secretsManagerClient SecretsManagerAPI) *AWSResolver {
+	r.once.Do(func() {})
+	r.ssm = ssmClient
+	r.secretsManager = secretsManagerClient
+	return r
+}
+
+// Resolve reads a SecureString or String parameter, or the string value of
+// a secret.
+func (r *AWSResolver) Resolve(ctx context.Context, ref Reference) (string, error) {
+	r.once.Do(func() { r.err = r.connect(ctx) })
+	if r.err != nil {
+		return "", r.err
+	}
+
+	switch ref.Scheme {
+	case SchemeSSM:
+		return r.parameter(ctx, ref.Name)
+	case SchemeSecretsManager:
+		return r.secret(ctx, ref.Name)
+	default:
+		return "", fmt.Errorf("unsupported secret reference scheme %q", ref.Scheme)
+	}
+}
+
+func (r *AWSResolver) connect(ctx context.Context) error {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(r.cfg.Region))
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	r.ssm = ssm.NewFromConfig(awsCfg, func(o *ssm.Options) {
+		if r.cfg.URL != "" {
+			o.BaseEndpoint = aws.String(r.cfg.URL)
+		}
+	})
+	r.secretsManager = secretsmanager.NewFromConfig(awsCfg, func(o *secretsmanager.Options) {
+		if r.cfg.URL != "" {
+			o.BaseEndpoint = aws.String(r.cfg.URL)
+		}
+	})
+
+	log.Ctx(ctx).Debug().
+		Str("region", r.cfg.Region).
+		Str("endpoint", r.cfg.URL).
+		Msg("Secret clients configured")
+	return nil
+}
+
+func (r *AWSResolver) parameter(ctx context.Context, name string) (string, error) {
+	out, err := r.ssm.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	var notFound *ssmtypes.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return "", fmt.Errorf("SSM parameter %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get SSM parameter %s: %w", name, err)
+	}
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return "", fmt.Errorf("SSM parameter %s has no value", name)
+	}
+	return *out.Parameter.Value, nil
+}
+
+func (r *AWSResolver) secret(ctx context.Context, name string) (string, error) {
+	out, err := r.secretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	var notFound *smtypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return "", fmt.Errorf("secret %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", name, err)
+	}
+	if out.SecretString != nil {
+		return *out.SecretString, nil
+	}
+	if out.SecretBinary != nil {
+		return string(out.SecretBinary), nil
+	}
+	return "", fmt.Errorf("secret %s has no value", name)
+}
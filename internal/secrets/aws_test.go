@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAWSResolver_Resolve(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(newStandIn(map[string]string{
+		"ssm:///config/enercity/prod/secrets/db_password": "s3cr3t",
+		"secretsmanager://billing/db":                     `{"password":"pa55"}`,
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	resolvers := NewResolvers(Config{Region: "eu-central-1", URL: server.URL})
+
+	tests := []struct {
+		value    string
+		want     string
+		notFound bool
+	}{
+		{value: "ssm:///config/enercity/prod/secrets/db_password", want: "s3cr3t"},
+		{value: "secretsmanager://billing/db#password", want: "pa55"},
+		{value: "ssm:///config/enercity/prod/secrets/missing", notFound: true},
+		{value: "secretsmanager://missing", notFound: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			ref, err := Parse(tt.value)
+			require.NoError(t, err)
+
+			got, err := resolvers.Resolve(context.Background(), ref)
+
+			if tt.notFound {
+				assert.True(t, errors.Is(err, ErrNotFound), "expected ErrNotFound, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// Helper Functions
+
+// newStandIn serves GetParameter and GetSecretValue of the SSM and Secrets
+// Manager JSON protocols from values, keyed by reference without key.
+func newStandIn(values map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name     string
+			SecretId string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetParameter":
+			value, ok := values["ssm://"+input.Name]
+			if !ok {
+				writeAWSError(w, "ParameterNotFound")
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"Parameter": map[string]any{"Name": input.Name, "Type": "SecureString", "Value": value},
+			})
+		case "secretsmanager.GetSecretValue":
+			value, ok := values["secretsmanager://"+input.SecretId]
+			if !ok {
+				writeAWSError(w, "ResourceNotFoundException")
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"Name": input.SecretId, "SecretString": value})
+		default:
+			http.Error(w, "unsupported operation", http.StatusBadRequest)
+		}
+	})
+}
+
+func writeAWSError(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"__type": code, "message": "not found"})
+}
@@ -0,0 +1,154 @@
+// Package secrets resolves configuration values that reference secrets
+// instead of containing them, e.g. ssm:///config/enercity/prod/secrets/db,
+// secretsmanager://billing-data-aggregator#password or
+// file:///run/secrets/db_password.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Schemes of secret references.
+const (
+	SchemeSSM            = "ssm"
+	SchemeSecretsManager = "secretsmanager"
+	SchemeFile           = "file"
+)
+
+// ErrNotFound is returned when a referenced secret does not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Reference points to a secret. Key selects a field of a secret that holds
+// a JSON object, as Secrets Manager stores database credentials.
+type Reference struct {
+	Scheme string
+	Name   string
+	Key    string
+}
+
+// String returns the reference in the form it is configured.
+func (r Reference) String() string {
+	s := r.Scheme + "://" + r.Name
+	if r.Key != "" {
+		s += "#" + r.Key
+	}
+	return s
+}
+
+// IsReference reports whether value is a secret reference rather than a
+// plain value.
+func IsReference(value string) bool {
+	for _, scheme := range []string{SchemeSSM, SchemeSecretsManager, SchemeFile} {
+		if strings.HasPrefix(value, scheme+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses a secret reference of the form scheme://name[#key].
+func Parse(value string) (Reference, error) {
+	if !IsReference(value) {
+		return Reference{}, fmt.Errorf("%q is not a secret reference, want %s://, %s:// or %s://",
+			value, SchemeSSM, SchemeSecretsManager, SchemeFile)
+	}
+	scheme, rest, _ := strings.Cut(value, "://")
+	name, key, _ := strings.Cut(rest, "#")
+	if name == "" {
+		return Reference{}, fmt.Errorf("secret reference %q has no name", value)
+	}
+	return Reference{Scheme: scheme, Name: name, Key: key}, nil
+}
+
+// Resolver returns the value of a secret.
+type Resolver interface {
+	Resolve(ctx context.Context, ref Reference) (string, error)
+}
+
+// Resolvers dispatches references to the resolver of their scheme and
+// selects the Key of JSON secrets.
+type Resolvers map[string]Resolver
+
+// NewResolvers returns the resolvers for files and, with cfg, for SSM
+// Parameter Store and Secrets Manager.
+func NewResolvers(cfg Config) Resolvers {
+	aws := NewAWSResolver(cfg)
+	return Resolvers{
+		SchemeFile:           FileResolver{},
+		SchemeSSM:            aws,
+		SchemeSecretsManager: aws,
+	}
+}
+
+// Resolve resolves ref with the resolver registered for its scheme.
+func (rs Resolvers) Resolve(ctx context.Context, ref Reference) (string, error) {
+	r, ok := rs[ref.Scheme]
+	if !ok {
+		return "", fmt.Errorf("no resolver for %s references", ref.Scheme)
+	}
+	value, err := r.Resolve(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if ref.Key == "" {
+		return value, nil
+	}
+	return field(value, ref.Key)
+}
+
+// field returns key of the JSON object value.
+func field(value, key string) (string, error) {
+	var object map[string]any
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot select %q: %w", key, err)
+	}
+	v, ok := object[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q: %w", key, ErrNotFound)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	// Numbers, e.g. a port, are returned in their JSON form.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode key %q: %w", key, err)
+	}
+	return string(b), nil
+}
+
+// FileResolver reads secrets from files, e.g. Docker or Kubernetes secret
+// mounts. A single trailing newline is removed.
+type FileResolver struct{}
+
+// Resolve returns the content of the file ref.Name.
+func (FileResolver) Resolve(_ context.Context, ref Reference) (string, error) {
+	data, err := os.ReadFile(ref.Name) // #nosec G304 -- path is operator configuration
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("secret file %s: %w", ref.Name, ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
+
+// Fake resolves references from memory, keyed by scheme://name. Like the
+// real backends it returns the whole secret; register it in Resolvers to
+// select keys of JSON secrets.
+type Fake map[string]string
+
+// Resolve returns the value stored for ref.
+func (f Fake) Resolve(_ context.Context, ref Reference) (string, error) {
+	value, ok := f[Reference{Scheme: ref.Scheme, Name: ref.Name}.String()]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", ref, ErrNotFound)
+	}
+	return value, nil
+}
@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		want      Reference
+		wantError string
+	}{
+		{
+			name:  "SSM parameter path",
+			value: "ssm:///config/enercity/prod/secrets/db_password",
+			want:  Reference{Scheme: SchemeSSM, Name: "/config/enercity/prod/secrets/db_password"},
+		},
+		{
+			name:  "Secrets Manager key",
+			value: "secretsmanager://billing-data-aggregator/db#password",
+			want:  Reference{Scheme: SchemeSecretsManager, Name: "billing-data-aggregator/db", Key: "password"},
+		},
+		{
+			name:  "File",
+			value: "file:///run/secrets/db",
+			want:  Reference{Scheme: SchemeFile, Name: "/run/secrets/db"},
+		},
+		{
+			name:      "Plain value",
+			value:     "postgres://localhost/octopus",
+			wantError: "is not a secret reference",
+		},
+		{
+			name:      "Missing name",
+			value:     "ssm://#key",
+			wantError: "has no name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.value)
+
+			if tt.wantError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.value, got.String())
+		})
+	}
+}
+
+func TestResolvers_Resolve(t *testing.T) {
+	// Setup
+	resolvers := Resolvers{
+		SchemeSSM: Fake{"ssm:///secrets/db_password": "s3cr3t"},
+		SchemeSecretsManager: Fake{
+			"secretsmanager://billing/db": `{"username":"billing","password":"pa55","port":5432}`,
+		},
+	}
+
+	tests := []struct {
+		value     string
+		want      string
+		wantError string
+	}{
+		{value: "ssm:///secrets/db_password", want: "s3cr3t"},
+		{value: "secretsmanager://billing/db#password", want: "pa55"},
+		{value: "secretsmanager://billing/db#port", want: "5432"},
+		{value: "secretsmanager://billing/db#host", wantError: `secret has no key "host"`},
+		{value: "ssm:///secrets/db_password#password", wantError: "not a JSON object"},
+		{value: "ssm:///secrets/missing", wantError: "secret not found"},
+		{value: "file:///run/secrets/db", wantError: "no resolver for file references"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			ref, err := Parse(tt.value)
+			require.NoError(t, err)
+
+			got, err := resolvers.Resolve(context.Background(), ref)
+
+			if tt.wantError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	// Setup
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db_password")
+	require.NoError(t, os.WriteFile(path, []byte("s3cr3t\n"), 0o600))
+
+	// Execute
+	got, err := FileResolver{}.Resolve(context.Background(), Reference{Scheme: SchemeFile, Name: path})
+	_, missingErr := FileResolver{}.Resolve(context.Background(), Reference{Scheme: SchemeFile, Name: filepath.Join(dir, "missing")})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "s3cr3t", got, "the trailing newline should be removed")
+	assert.True(t, errors.Is(missingErr, ErrNotFound), "expected ErrNotFound, got %v", missingErr)
+}
@@ -1,10 +1,14 @@
 set timezone = 'Europe/Berlin';
 
-drop table if exists report_oibl.oibl_tripica cascade;
-alter table report_oibl.new_oibl_tripica rename to oibl_tripica;
+-- build the published table and view in the staging schema, the aggregator
+-- swaps them into report_oibl in one transaction after all scripts ran
+create schema if not exists report_oibl_staging;
+drop table if exists report_oibl_staging.oibl_tripica cascade;
+alter table report_oibl.new_oibl_tripica set schema report_oibl_staging;
+alter table report_oibl_staging.new_oibl_tripica rename to oibl_tripica;
 
 -- create view with a limited column set
-create or replace view report_oibl.oibl_customer as
+create or replace view report_oibl_staging.oibl_customer as
 select
 	cba,
 	mba,
@@ -39,5 +43,5 @@ select
 	booking_credit_amount,
 	booking_amount_indicator,
 	migration_flag
-from report_oibl.oibl_tripica
+from report_oibl_staging.oibl_tripica
 ;
\ No newline at end of file
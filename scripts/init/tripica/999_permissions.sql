@@ -11,7 +11,7 @@ BEGIN
 		EXECUTE 'GRANT USAGE ON SCHEMA report_oibl TO "' || usr_name || '"';
 		EXECUTE 'ALTER DEFAULT PRIVILEGES IN SCHEMA report_oibl GRANT SELECT ON TABLES TO "' || usr_name || '"';
 
-		FOR tbl_name IN select concat(table_schema, '."', table_name, '"') FROM information_schema.tables WHERE table_schema = 'report_oibl'
+		FOR tbl_name IN select concat(table_schema, '."', table_name, '"') FROM information_schema.tables WHERE table_schema IN ('report_oibl', 'report_oibl_staging')
 		loop
 			EXECUTE 'GRANT SELECT ON ' || tbl_name || ' TO "' || usr_name || '"';
 		END LOOP;
@@ -21,6 +21,7 @@ BEGIN
 	-- metabase read only user
 	IF EXISTS (SELECT FROM pg_catalog.pg_roles WHERE rolname = 'service_metabase') THEN
 		GRANT USAGE ON SCHEMA report_oibl TO service_metabase;
-		GRANT SELECT ON report_oibl.oibl_customer TO service_metabase;
+		-- granted on the staged view, the grant moves with it into report_oibl
+		GRANT SELECT ON report_oibl_staging.oibl_customer TO service_metabase;
 	END IF;
 END$$;
@@ -0,0 +1,11 @@
+-- bda:severity=warn
+-- bda:description=Row count of oibl_tripica within 10% of the published (previous) run
+with staged as (
+	select count(*) as row_count from report_oibl_staging.oibl_tripica
+), published as (
+	select count(*) as row_count from report_oibl.oibl_tripica
+)
+select staged.row_count as staged_rows, published.row_count as published_rows
+from staged, published
+where published.row_count > 0
+  and abs(staged.row_count - published.row_count) > published.row_count * 0.10